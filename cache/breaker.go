@@ -0,0 +1,158 @@
+// Copyright 2017 Corey Scott http://www.sage42.org/
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned by NativeBreaker when the circuit is open and calls are being rejected
+var ErrCircuitOpen = errors.New("circuit open")
+
+const (
+	defaultBreakerFailureThreshold = 5
+	defaultBreakerOpenDuration     = 5 * time.Second
+)
+
+// CircuitBreaker protects the calls made by a storage (e.g. RedisStorage and DynamoDbStorage).
+//
+// Each storage instance has its own breaker; the included implementations are NativeBreaker (the default),
+// NoopBreaker and hystrixbreaker.Breaker.
+type CircuitBreaker interface {
+	// Do runs fn unless the circuit is open; returning the error from fn or from the breaker
+	Do(ctx context.Context, fn func() error) error
+}
+
+// NoopBreaker implements CircuitBreaker by always running the call
+type NoopBreaker struct{}
+
+// Do implements CircuitBreaker
+func (NoopBreaker) Do(_ context.Context, fn func() error) error {
+	return fn()
+}
+
+// NativeBreaker implements CircuitBreaker by opening the circuit after a number of consecutive failures.
+//
+// While open, calls fail immediately with ErrCircuitOpen.  After OpenDuration a single trial call is allowed; when it
+// succeeds the circuit is closed, otherwise it is opened again.  Calls that were allowed before the circuit opened do
+// not close it when they complete.  Calls that fail because the context was canceled are not counted as failures.
+type NativeBreaker struct {
+	// FailureThreshold is the number of consecutive failures that opens the circuit (optional - default 5)
+	FailureThreshold int
+
+	// OpenDuration is how long the circuit stays open before a trial call is allowed (optional - default 5 seconds)
+	OpenDuration time.Duration
+
+	mutex    sync.Mutex
+	failures int
+	openedAt time.Time
+	trial    bool
+}
+
+// Do implements CircuitBreaker
+func (b *NativeBreaker) Do(_ context.Context, fn func() error) error {
+	allowed, trial := b.allow()
+	if !allowed {
+		return ErrCircuitOpen
+	}
+
+	err := fn()
+	b.record(err, trial)
+
+	return err
+}
+
+// returns true when the call should be made; trial is true for the single call that is allowed while half open and
+// must be passed to record
+func (b *NativeBreaker) allow() (allowed bool, trial bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.openedAt.IsZero() {
+		// closed
+		return true, false
+	}
+
+	if b.trial || time.Since(b.openedAt) < b.getOpenDuration() {
+		return false, false
+	}
+
+	// half open; allow a single trial call
+	b.trial = true
+	return true, true
+}
+
+// update the state with the result of a call; only the trial call (see allow) resolves the half open state and calls
+// that were allowed before the circuit opened cannot close it
+func (b *NativeBreaker) record(err error, trial bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if trial {
+		b.trial = false
+	}
+
+	closed := b.openedAt.IsZero()
+
+	if err == nil {
+		if closed || trial {
+			b.failures = 0
+			b.openedAt = time.Time{}
+		}
+		return
+	}
+
+	if errors.Is(err, context.Canceled) {
+		// not the storage's fault; when this was the trial, another trial call is allowed
+		return
+	}
+
+	b.failures++
+	if trial || (closed && b.failures >= b.getFailureThreshold()) {
+		b.openedAt = time.Now()
+	}
+}
+
+func (b *NativeBreaker) getFailureThreshold() int {
+	if b.FailureThreshold > 0 {
+		return b.FailureThreshold
+	}
+
+	return defaultBreakerFailureThreshold
+}
+
+func (b *NativeBreaker) getOpenDuration() time.Duration {
+	if b.OpenDuration > 0 {
+		return b.OpenDuration
+	}
+
+	return defaultBreakerOpenDuration
+}
+
+// run fn via the breaker in a new goroutine; errors are sent on the returned channel (successful calls send nothing)
+func goWithBreaker(ctx context.Context, breaker CircuitBreaker, fn func() error) chan error {
+	errorCh := make(chan error, 1)
+	go func() {
+		err := breaker.Do(ctx, fn)
+		if err != nil {
+			errorCh <- err
+		}
+	}()
+
+	return errorCh
+}
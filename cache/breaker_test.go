@@ -0,0 +1,219 @@
+// Copyright 2017 Corey Scott http://www.sage42.org/
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNativeBreaker_implements(t *testing.T) {
+	assert.Implements(t, (*CircuitBreaker)(nil), &NativeBreaker{})
+	assert.Implements(t, (*CircuitBreaker)(nil), NoopBreaker{})
+}
+
+func TestNativeBreaker(t *testing.T) {
+	ctx := context.Background()
+	fnErr := errors.New("something failed")
+
+	breaker := &NativeBreaker{
+		FailureThreshold: 2,
+		OpenDuration:     50 * time.Millisecond,
+	}
+
+	calls := 0
+	fail := func() error {
+		calls++
+		return fnErr
+	}
+	succeed := func() error {
+		calls++
+		return nil
+	}
+
+	// failures below the threshold are returned
+	assert.Equal(t, fnErr, breaker.Do(ctx, fail))
+	assert.Equal(t, nil, breaker.Do(ctx, succeed))
+	assert.Equal(t, fnErr, breaker.Do(ctx, fail))
+	assert.Equal(t, 3, calls)
+
+	// consecutive failures open the circuit
+	assert.Equal(t, fnErr, breaker.Do(ctx, fail))
+	assert.Equal(t, ErrCircuitOpen, breaker.Do(ctx, succeed))
+	assert.Equal(t, 4, calls)
+
+	// a failed trial re-opens the circuit
+	<-time.After(60 * time.Millisecond)
+	assert.Equal(t, fnErr, breaker.Do(ctx, fail))
+	assert.Equal(t, ErrCircuitOpen, breaker.Do(ctx, succeed))
+	assert.Equal(t, 5, calls)
+
+	// a successful trial closes the circuit
+	<-time.After(60 * time.Millisecond)
+	assert.Equal(t, nil, breaker.Do(ctx, succeed))
+	assert.Equal(t, nil, breaker.Do(ctx, succeed))
+	assert.Equal(t, 7, calls)
+}
+
+func TestNativeBreaker_onlyTrialResolvesHalfOpen(t *testing.T) {
+	ctx := context.Background()
+	fnErr := errors.New("something failed")
+
+	breaker := &NativeBreaker{
+		FailureThreshold: 1,
+		OpenDuration:     50 * time.Millisecond,
+	}
+
+	// a slow call that is allowed while the circuit is closed
+	releaseSlow := make(chan struct{})
+	slowDone := make(chan error, 1)
+	go func() {
+		slowDone <- breaker.Do(ctx, func() error {
+			<-releaseSlow
+			return nil
+		})
+	}()
+
+	// wait until the slow call has been allowed, then open the circuit
+	<-time.After(10 * time.Millisecond)
+	assert.Equal(t, fnErr, breaker.Do(ctx, func() error {
+		return fnErr
+	}))
+
+	// start the trial call
+	<-time.After(60 * time.Millisecond)
+	releaseTrial := make(chan struct{})
+	trialDone := make(chan error, 1)
+	go func() {
+		trialDone <- breaker.Do(ctx, func() error {
+			<-releaseTrial
+			return fnErr
+		})
+	}()
+	<-time.After(10 * time.Millisecond)
+
+	// the slow call completes; it does not close the circuit or end the trial
+	close(releaseSlow)
+	assert.Nil(t, <-slowDone)
+	assert.Equal(t, ErrCircuitOpen, breaker.Do(ctx, func() error {
+		return nil
+	}))
+
+	// the failed trial re-opens the circuit
+	close(releaseTrial)
+	assert.Equal(t, fnErr, <-trialDone)
+	assert.Equal(t, ErrCircuitOpen, breaker.Do(ctx, func() error {
+		return nil
+	}))
+}
+
+func TestNativeBreaker_canceled(t *testing.T) {
+	breaker := &NativeBreaker{FailureThreshold: 1}
+
+	// make the call
+	resultErr := breaker.Do(context.Background(), func() error {
+		return context.Canceled
+	})
+
+	// validate
+	assert.Equal(t, context.Canceled, resultErr)
+	assert.Nil(t, breaker.Do(context.Background(), func() error {
+		return nil
+	}))
+}
+
+func TestRedisStorage_breakerPerInstance(t *testing.T) {
+	ctx := context.Background()
+	newPool := func() *redis.Pool {
+		return &redis.Pool{
+			Dial: func() (redis.Conn, error) {
+				return nil, errors.New("connection refused")
+			},
+		}
+	}
+
+	storageA := &RedisStorage{Pool: newPool(), TTL: 60 * time.Second}
+	storageB := &RedisStorage{Pool: newPool(), TTL: 60 * time.Second}
+
+	// open the circuit of storage A
+	for x := 0; x < defaultBreakerFailureThreshold; x++ {
+		_, err := storageA.Get(ctx, "foo")
+		assert.NotEqual(t, ErrCircuitOpen, err)
+	}
+
+	_, resultErr := storageA.Get(ctx, "foo")
+	assert.Equal(t, ErrCircuitOpen, resultErr)
+
+	// storage B is not affected
+	_, resultErr = storageB.Get(ctx, "foo")
+	assert.NotNil(t, resultErr)
+	assert.NotEqual(t, ErrCircuitOpen, resultErr)
+}
+
+func TestDynamoDbStorage_Breaker(t *testing.T) {
+	ctx := context.Background()
+	breaker := &testBreaker{err: ErrCircuitOpen}
+
+	storage := &DynamoDbStorage{
+		Service:   newFakeDynamoDb(),
+		TableName: "cachetest",
+		TTL:       60 * time.Second,
+		Breaker:   breaker,
+	}
+
+	// make the calls
+	_, resultErr := storage.Get(ctx, "foo")
+	assert.Equal(t, ErrCircuitOpen, resultErr)
+
+	resultErr = storage.Set(ctx, "foo", []byte(`bar`))
+	assert.Equal(t, ErrCircuitOpen, resultErr)
+
+	// validate
+	assert.Equal(t, 2, breaker.calls)
+}
+
+func TestDynamoDbStorage_NoopBreaker(t *testing.T) {
+	ctx := context.Background()
+
+	storage := &DynamoDbStorage{
+		Service:   newFakeDynamoDb(),
+		TableName: "cachetest",
+		TTL:       60 * time.Second,
+		Breaker:   NoopBreaker{},
+	}
+
+	// make the calls
+	assert.Nil(t, storage.Set(ctx, "foo", []byte(`bar`)))
+
+	result, resultErr := storage.Get(ctx, "foo")
+	assert.Nil(t, resultErr)
+	assert.Equal(t, []byte(`bar`), result)
+}
+
+// breaker that rejects every call with err
+type testBreaker struct {
+	err   error
+	calls int
+}
+
+func (t *testBreaker) Do(_ context.Context, _ func() error) error {
+	t.calls++
+	return t.err
+}
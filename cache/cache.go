@@ -17,15 +17,19 @@ package cache
 import (
 	"context"
 	"encoding"
-	"sync/atomic"
+	"errors"
+	"reflect"
 	"time"
+
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Client defines a cache instance.
 //
 // This can represent the cache for the entire system or for a particular use-case/type.
 //
-// If a cache is used for multiple purposes, then care must be taken to ensure uniqueness of cache keys.
+// If a cache is used for multiple purposes, then care must be taken to ensure uniqueness of cache keys; setting a
+// unique Namespace for each use is the simplest way to do so.
 //
 // It is not recommended to change this struct's member data after creation as a data race will likely ensue.
 type Client struct {
@@ -33,57 +37,253 @@ type Client struct {
 	Storage Storage
 
 	// Logger defines a logger to used for errors during async cache writes (optional)
+	//
+	// Loggers that implement StructuredLogger (e.g. SlogLogger) receive the level and fields of each entry.
 	Logger Logger
 
+	// Name identifies this cache in the observations reported to ExtendedMetrics (optional)
+	Name string
+
 	// Metrics allow for tracking cache events (hit/miss/etc) (optional)
+	//
+	// When Metrics also implements ExtendedMetrics, the details (duration, size, etc) of every storage operation and
+	// builder run are reported as well.
 	Metrics Metrics
 
+	// TracerProvider enables OpenTelemetry spans for Get, Set, Invalidate, builder runs and asynchronous writes
+	// (optional - default disabled)
+	TracerProvider trace.TracerProvider
+
 	// WriteTimeout is the max time spent waiting for cache writes to complete (optional - default 3 seconds)
 	WriteTimeout time.Duration
 
+	// SoftTTL is the age after which cached values are considered stale (optional - default disabled)
+	//
+	// Stale values are returned immediately while a fresh value is built in the background.  Callers only block on
+	// the builder once the storage TTL (the "hard" TTL) has passed; as such SoftTTL should be less than the
+	// storage TTL.
+	SoftTTL time.Duration
+
+	// TTL is the default TTL for the values written by this client (optional - default the storage's default TTL)
+	//
+	// Values with a TTL set by the client (this TTL, a TTLBuilder or SetWithTTL) record their expiry; this is
+	// required for EarlyExpiryBeta.
+	TTL time.Duration
+
+	// EarlyExpiryBeta enables probabilistic early expiration (the XFetch algorithm) (optional - default disabled)
+	//
+	// Each value records how long it took to build and, as its expiry approaches, Get will randomly treat it as a
+	// miss (tracked as a CacheEarlyExpiry event) with increasing probability; this spreads out the rebuilds of values
+	// that were written at the same time.  1.0 is a good default; values > 1.0 favor earlier rebuilds.
+	// Only values with a known expiry (see TTL) are expired early.
+	EarlyExpiryBeta float64
+
+	// NegativeTTL is how long a builder result of ErrNotFound is cached for (optional - default disabled)
+	//
+	// While cached, calls to Get will return ErrNegativeHit without calling the builder.
+	NegativeTTL time.Duration
+
+	// AsyncWriteWorkers is the number of goroutines used to perform asynchronous cache writes
+	// (optional - default each write uses a new goroutine)
+	AsyncWriteWorkers int
+
+	// AsyncWriteQueueSize is the max number of asynchronous cache writes waiting for a worker
+	// (optional - default 100; only used when AsyncWriteWorkers is set)
+	AsyncWriteQueueSize int
+
+	// AsyncWriteOverflow controls what happens to asynchronous cache writes when the queue is full
+	// (optional - default OverflowDropNewest; only used when AsyncWriteWorkers is set)
+	AsyncWriteOverflow OverflowPolicy
+
+	// Compression is the codec used to compress values before they are saved into storage (optional - default disabled)
+	//
+	// Values compressed by any of the built-in codecs can always be read, regardless of this setting.
+	Compression Codec
+
+	// CompressionThreshold is the min size (in bytes) of a value before it is compressed
+	// (optional - default 1KB; only used when Compression is set)
+	CompressionThreshold int
+
+	// Namespace is prefixed to every key along with the namespace version (optional - default keys are used as is)
+	//
+	// The namespace version is stored in Storage; calling BumpNamespace changes the version and thereby logically
+	// invalidates every key in the namespace.
+	Namespace string
+
+	// NamespaceRefreshInterval is how often the namespace version is re-read from Storage
+	// (optional - default 10 seconds; only used when Namespace is set)
+	NamespaceRefreshInterval time.Duration
+
+	// the locally cached namespace version
+	namespace namespaceVersion
+
+	// running totals of the compression results
+	compressionStats CompressionStats
+
 	// track pending cache writes
 	pendingWrites int64
+
+	// set to 1 when the client is closed
+	closed int32
+
+	// coalesces concurrent builds of the same key
+	inflight coalescer
+
+	// workers for the async writes (when enabled)
+	writePool writePool
 }
 
 // Get attempts to retrieve the value from cache and when it misses will run the builder func to create the value.
 //
-// It will asynchronously update/save the value in the cache on after a successful builder run
-func (c *Client) Get(ctx context.Context, key string, dest BinaryEncoder, builder Builder) error {
-	bytes, err := c.Storage.Get(ctx, key)
+// It will asynchronously update/save the value in the cache on after a successful builder run.
+//
+// Concurrent misses for the same key will share a single builder run.
+func (c *Client) Get(ctx context.Context, key string, dest BinaryEncoder, builder Builder) (err error) {
+	ctx, span := c.startSpan(ctx, "cache.Get", attrCacheKey.String(key))
+	defer func() {
+		endSpan(span, 0, err)
+	}()
+
+	bytes, err := c.storage().Get(ctx, key)
 	if err != nil {
 		if err == ErrCacheMiss {
 			c.getMetrics().Track(CacheMiss)
+			setSpanHit(ctx, false, 0)
 			return c.onCacheMiss(ctx, key, dest, builder)
 		}
 
-		c.getLogger().Log("cache get error. key: '%s' error: %s", key, err)
+		c.log(LevelError, OpGet, "cache get error", Field{FieldKey, key}, Field{FieldError, err})
 		c.getMetrics().Track(CacheGetError)
 		return err
 	}
 
-	return c.onCacheHit(ctx, key, dest, bytes)
+	return c.onCacheHit(ctx, key, dest, builder, bytes)
 }
 
+// concurrent misses for the same key are coalesced so that only 1 builder is run; all other callers receive a copy
+// of the result.
+//
+// The shared build runs with the context of the caller that started it; when that context is cancelled the waiting
+// callers (whose contexts are still live) retry rather than receiving the cancellation.
 func (c *Client) onCacheMiss(ctx context.Context, key string, dest BinaryEncoder, builder Builder) error {
-	err := builder.Build(ctx, key, dest)
+	bytes, shared, err := c.inflight.do(ctx, key, func() ([]byte, error) {
+		return c.build(ctx, key, dest, builder)
+	})
+	for shared && ctx.Err() == nil && isAbandonedBuild(err) {
+		bytes, shared, err = c.inflight.do(ctx, key, func() ([]byte, error) {
+			return c.build(ctx, key, dest, builder)
+		})
+	}
+
+	if !shared {
+		// dest was populated directly by the builder
+		if _, isLambdaErr := err.(*LambdaError); isLambdaErr {
+			return err
+		}
+
+		return nil
+	}
+
+	c.getMetrics().Track(CacheCoalesced)
 	if err != nil {
-		c.getLogger().Log("cache miss build error. key: '%s' error: %s", key, err)
+		return err
+	}
+
+	err = dest.UnmarshalBinary(bytes)
+	if err != nil {
+		c.log(LevelError, OpGet, "cache coalesced unmarshal error", Field{FieldKey, key}, Field{FieldError, err})
+		c.getMetrics().Track(CacheUnmarshalError)
+		return err
+	}
+
+	return nil
+}
+
+// run the builder and asynchronously save the result to the cache
+func (c *Client) build(ctx context.Context, key string, dest BinaryEncoder, builder Builder) ([]byte, error) {
+	start := time.Now()
+	ctx = WithVersion(ctx, start.UnixNano())
+	ctx, span := c.startSpan(ctx, "cache.Build", attrCacheKey.String(key))
+
+	ttl, err := runBuilder(ctx, key, dest, builder)
+	if err != nil {
+		endSpan(span, 0, err)
+		c.observeBuild(start, 1, 0, err)
+		c.log(LevelWarn, OpBuild, "cache miss build error", Field{FieldKey, key}, Field{FieldError, err})
 		c.getMetrics().Track(CacheLambdaError)
-		return &LambdaError{
+
+		if c.NegativeTTL > 0 && errors.Is(err, ErrNotFound) {
+			c.setAsync(ctx, key, c.newNegativeEnvelope())
+		}
+
+		return nil, &LambdaError{
 			Cause: err,
 		}
 	}
 
-	atomic.AddInt64(&c.pendingWrites, 1)
-	go c.Set(context.Background(), key, dest)
+	bytes, err := dest.MarshalBinary()
+	endSpan(span, len(bytes), err)
+	c.observeBuild(start, 1, len(bytes), err)
+	if err != nil {
+		c.log(LevelError, OpBuild, "cache update marshal error", Field{FieldKey, key}, Field{FieldError, err})
+		c.getMetrics().Track(CacheMarshalError)
+		return nil, err
+	}
 
-	return nil
+	entry := c.newEnvelope(bytes, ttl)
+	entry.tags = tagsOf(dest)
+	entry.buildDuration = c.buildDurationSince(start)
+
+	c.setAsync(ctx, key, entry)
+
+	return bytes, nil
+}
+
+// returns true when the build failed because the context of the caller that started it was cancelled (or timed out)
+func isAbandonedBuild(err error) bool {
+	lambdaErr, ok := err.(*LambdaError)
+	if !ok {
+		return false
+	}
+
+	return errors.Is(lambdaErr.Cause, context.Canceled) || errors.Is(lambdaErr.Cause, context.DeadlineExceeded)
 }
 
-func (c *Client) onCacheHit(ctx context.Context, key string, dest encoding.BinaryUnmarshaler, bytes []byte) error {
-	err := dest.UnmarshalBinary(bytes)
+// run the builder; returning the TTL requested by the builder (if any)
+func runBuilder(ctx context.Context, key string, dest BinaryEncoder, builder Builder) (time.Duration, error) {
+	if ttlBuilder, ok := builder.(TTLBuilder); ok {
+		return ttlBuilder.BuildWithTTL(ctx, key, dest)
+	}
+
+	return 0, builder.Build(ctx, key, dest)
+}
+
+func (c *Client) onCacheHit(ctx context.Context, key string, dest BinaryEncoder, builder Builder, bytes []byte) error {
+	entry, err := c.decodeEntry(bytes)
+	if err == nil {
+		if entry.isExpired(time.Now()) {
+			c.getMetrics().Track(CacheMiss)
+			setSpanHit(ctx, false, 0)
+			return c.onCacheMiss(ctx, key, dest, builder)
+		}
+
+		if entry.negative {
+			c.getMetrics().Track(CacheNegativeHit)
+			setSpanHit(ctx, true, 0)
+			return ErrNegativeHit
+		}
+
+		if c.isEarlyExpired(entry, time.Now()) {
+			c.getMetrics().Track(CacheEarlyExpiry)
+			c.getMetrics().Track(CacheMiss)
+			setSpanHit(ctx, false, 0)
+			return c.onCacheMiss(ctx, key, dest, builder)
+		}
+
+		err = dest.UnmarshalBinary(entry.payload)
+	}
 	if err != nil {
-		c.getLogger().Log("cache hit unmarshal error. key: '%s' error: %s", key, err)
+		c.log(LevelError, OpGet, "cache hit unmarshal error", Field{FieldKey, key}, Field{FieldError, err})
 		c.getMetrics().Track(CacheUnmarshalError)
 
 		// invalidate to remove "bad" data
@@ -93,40 +293,156 @@ func (c *Client) onCacheHit(ctx context.Context, key string, dest encoding.Binar
 	}
 
 	c.getMetrics().Track(CacheHit)
+	setSpanHit(ctx, true, len(entry.payload))
+
+	if entry.isStale(time.Now()) {
+		c.onStaleHit(ctx, key, dest, builder)
+	}
 	return nil
 }
 
+// start a background refresh of the stale value (unless one is already in-flight)
+func (c *Client) onStaleHit(ctx context.Context, key string, dest BinaryEncoder, builder Builder) {
+	c.getMetrics().Track(CacheStaleHit)
+
+	// the caller owns dest so we must build into a new instance
+	refreshDest, ok := newDestLike(dest)
+	if !ok {
+		c.log(LevelWarn, OpBuild, "cache stale refresh skipped, dest must be a pointer", Field{FieldKey, key})
+		return
+	}
+
+	if !c.startRefresh() {
+		// the refreshed value could not be saved
+		return
+	}
+
+	started := c.inflight.doAsync(key, func() ([]byte, error) {
+		defer c.finishAsyncWrite()
+
+		ctx, span := c.startAsyncSpan(withRefresh(ctx), "cache.Refresh", attrCacheKey.String(key))
+		defer span.End()
+
+		return c.build(ctx, key, refreshDest, builder)
+	})
+	if !started {
+		c.finishAsyncWrite()
+	}
+}
+
 // Set will update the cache with the supplied key/value pair
 // NOTE: generally this need not be called is it is called implicitly by Get
 func (c *Client) Set(ctx context.Context, key string, val encoding.BinaryMarshaler) {
-	defer func() {
-		// update tracking
-		atomic.AddInt64(&c.pendingWrites, -1)
-	}()
+	c.SetWithTTL(ctx, key, val, 0)
+}
 
-	// use independent context so we don't miss cache updated
-	ctx, cancelFn := context.WithTimeout(ctx, c.getWriteTimeout())
-	defer cancelFn()
+// SetWithTTL will update the cache with the supplied key/value pair which will expire after the supplied TTL.
+//
+// A TTL <= 0 denotes the storage's default TTL.  When the storage does not implement TTLStorage, the item will be
+// kept for the storage's default TTL but will be treated as a miss once the supplied TTL has passed.
+func (c *Client) SetWithTTL(ctx context.Context, key string, val encoding.BinaryMarshaler, ttl time.Duration) {
+	c.set(ctx, key, val, ttl, nil)
+}
+
+// marshal and save the value along with any tags
+func (c *Client) set(ctx context.Context, key string, val encoding.BinaryMarshaler, ttl time.Duration, tags []string) {
+	ctx, span := c.startSpan(ctx, "cache.Set", attrCacheKey.String(key))
 
 	bytes, err := val.MarshalBinary()
+	defer func() {
+		endSpan(span, len(bytes), err)
+	}()
+
 	if err != nil {
-		c.getLogger().Log("cache update marshal error. key: '%s' error: %s", key, err)
+		c.log(LevelError, OpSet, "cache update marshal error", Field{FieldKey, key}, Field{FieldError, err})
 		c.getMetrics().Track(CacheMarshalError)
 		return
 	}
 
-	err = c.Storage.Set(ctx, key, bytes)
+	entry := c.newEnvelope(bytes, ttl)
+	entry.tags = append(append([]string{}, tagsOf(val)...), tags...)
+
+	c.setEnvelope(ctx, key, entry)
+}
+
+// wrap the supplied payload with the metadata required by this client
+func (c *Client) newEnvelope(bytes []byte, ttl time.Duration) *envelope {
+	out := &envelope{
+		payload: bytes,
+	}
+
+	if ttl <= 0 {
+		ttl = c.TTL
+	}
+
+	now := time.Now()
+	if c.SoftTTL > 0 {
+		out.softExpiry = now.Add(c.SoftTTL)
+	}
+
+	if ttl > 0 {
+		out.expiry = now.Add(ttl)
+		out.ttl = ttl
+	}
+
+	return out
+}
+
+// build a "tombstone" that denotes the key does not exist
+func (c *Client) newNegativeEnvelope() *envelope {
+	return &envelope{
+		expiry:   time.Now().Add(c.NegativeTTL),
+		negative: true,
+		payload:  []byte{},
+		ttl:      c.NegativeTTL,
+	}
+}
+
+// asynchronously save the envelope into storage
+func (c *Client) setAsync(ctx context.Context, key string, entry *envelope) {
+	if !c.startAsyncWrite(ctx) {
+		c.log(LevelWarn, OpSet, "cache update skipped as client is closed", Field{FieldKey, key})
+		return
+	}
+
+	c.runAsync(func() {
+		ctx, span := c.startAsyncSpan(ctx, "cache.AsyncSet", attrCacheKey.String(key))
+		defer func() {
+			endSpan(span, len(entry.payload), nil)
+		}()
+
+		c.setEnvelope(ctx, key, entry)
+	})
+}
+
+// save the envelope into storage
+func (c *Client) setEnvelope(ctx context.Context, key string, entry *envelope) {
+	// use independent context so we don't miss cache updated
+	ctx, cancelFn := context.WithTimeout(ctx, c.getWriteTimeout())
+	defer cancelFn()
+
+	c.compress(key, entry)
+
+	err := setWithTTL(ctx, c.storage(), key, entry.encode(), entry.ttl)
 	if err != nil {
-		c.getLogger().Log("cache update set error. key: '%s' error: %s", key, err)
+		c.log(LevelError, OpSet, "cache update set error", Field{FieldKey, key}, Field{FieldError, err})
 		c.getMetrics().Track(CacheSetError)
+		return
 	}
+
+	c.tag(ctx, key, entry)
 }
 
 // Invalidate will force invalidate any matching key in the cache
-func (c *Client) Invalidate(ctx context.Context, key string) error {
-	err := c.Storage.Invalidate(ctx, key)
+func (c *Client) Invalidate(ctx context.Context, key string) (err error) {
+	ctx, span := c.startSpan(ctx, "cache.Invalidate", attrCacheKey.String(key))
+	defer func() {
+		endSpan(span, 0, err)
+	}()
+
+	err = c.storage().Invalidate(ctx, key)
 	if err != nil {
-		c.getLogger().Log("cache invalidate error. key: '%s' error: %s", key, err)
+		c.log(LevelError, OpInvalidate, "cache invalidate error", Field{FieldKey, key}, Field{FieldError, err})
 		c.getMetrics().Track(CacheInvalidateError)
 		return err
 	}
@@ -134,6 +450,13 @@ func (c *Client) Invalidate(ctx context.Context, key string) error {
 	return nil
 }
 
+// log the message with the supplied fields along with the operation and storage
+func (c *Client) log(level Level, op string, message string, fields ...Field) {
+	fields = append(fields, Field{FieldOp, op}, Field{FieldStorage, storageName(c.Storage)})
+
+	logFields(c.getLogger(), level, message, fields...)
+}
+
 // return the supplied logger or a no-op implementation
 func (c *Client) getLogger() Logger {
 	if c.Logger != nil {
@@ -161,6 +484,26 @@ func (c *Client) getWriteTimeout() time.Duration {
 	return 3 * time.Second
 }
 
+// optional extension of BinaryEncoder for dests that cannot be copied with reflection (e.g. Typed values)
+type destCloner interface {
+	newLike() BinaryEncoder
+}
+
+// return a new, empty instance of the same type as dest (only possible when dest is a pointer)
+func newDestLike(dest BinaryEncoder) (BinaryEncoder, bool) {
+	if cloner, ok := dest.(destCloner); ok {
+		return cloner.newLike(), true
+	}
+
+	destType := reflect.TypeOf(dest)
+	if destType.Kind() != reflect.Ptr {
+		return nil, false
+	}
+
+	out, ok := reflect.New(destType.Elem()).Interface().(BinaryEncoder)
+	return out, ok
+}
+
 // Builder builds the data for a key
 type Builder interface {
 	// Build returns the data for the supplied key by populating dest
@@ -175,6 +518,29 @@ func (b BuilderFunc) Build(ctx context.Context, key string, dest BinaryEncoder)
 	return b(ctx, key, dest)
 }
 
+// TTLBuilder is an optional extension of Builder for builders that also decide how long the value is cached for
+type TTLBuilder interface {
+	Builder
+
+	// BuildWithTTL returns the data for the supplied key by populating dest and the TTL for the value.
+	// A TTL <= 0 denotes the storage's default TTL.
+	BuildWithTTL(ctx context.Context, key string, dest BinaryEncoder) (time.Duration, error)
+}
+
+// TTLBuilderFunc implements TTLBuilder as a function
+type TTLBuilderFunc func(ctx context.Context, key string, dest BinaryEncoder) (time.Duration, error)
+
+// Build implements Builder
+func (b TTLBuilderFunc) Build(ctx context.Context, key string, dest BinaryEncoder) error {
+	_, err := b(ctx, key, dest)
+	return err
+}
+
+// BuildWithTTL implements TTLBuilder
+func (b TTLBuilderFunc) BuildWithTTL(ctx context.Context, key string, dest BinaryEncoder) (time.Duration, error) {
+	return b(ctx, key, dest)
+}
+
 // BinaryEncoder encodes/decodes the receiver to and from binary form
 type BinaryEncoder interface {
 	encoding.BinaryMarshaler
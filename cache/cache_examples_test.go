@@ -39,7 +39,7 @@ func ExampleClient_normalUsage() {
 			Pool: &redis.Pool{
 				MaxIdle:     3,
 				IdleTimeout: 240 * time.Second,
-				Dial:        func() (redis.Conn, error) { return redis.Dial("tcp", ":6379") },
+				Dial:        func() (redis.Conn, error) { return cache.RedisDial("tcp", ":6379") },
 			},
 			TTL: 60 * time.Second,
 		},
@@ -72,7 +72,7 @@ func ExampleClient_httpHandler() {
 			Pool: &redis.Pool{
 				MaxIdle:     3,
 				IdleTimeout: 240 * time.Second,
-				Dial:        func() (redis.Conn, error) { return redis.Dial("tcp", ":6379") },
+				Dial:        func() (redis.Conn, error) { return cache.RedisDial("tcp", ":6379") },
 			},
 			TTL: 60 * time.Second,
 		},
@@ -0,0 +1,322 @@
+// Copyright 2017 Corey Scott http://www.sage42.org/
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"encoding"
+	"time"
+)
+
+// GetMulti attempts to retrieve many values from cache and will run the batch builder once for all the keys that
+// missed.
+//
+// dests is a map of cache key to destination.  On successful return, dests will only contain the keys that exist;
+// keys removed by the builder (or cached as ErrNotFound) are removed.
+//
+// When the Storage implements MultiStorage, the values are read and written in batches; otherwise 1 call per key is
+// made.
+func (c *Client) GetMulti(ctx context.Context, dests map[string]BinaryEncoder, builder BatchBuilder) (err error) {
+	ctx, span := c.startSpan(ctx, "cache.GetMulti", attrCacheKeys.Int(len(dests)))
+	defer func() {
+		endSpan(span, 0, err)
+	}()
+
+	keys := make([]string, 0, len(dests))
+	for key := range dests {
+		keys = append(keys, key)
+	}
+
+	found, err := getMulti(ctx, c.storage(), keys)
+	if err != nil {
+		c.log(LevelError, OpGetMulti, "cache get multi error", Field{FieldKeys, len(keys)}, Field{FieldError, err})
+		c.getMetrics().Track(CacheGetError)
+		return err
+	}
+
+	now := time.Now()
+	missing := map[string]BinaryEncoder{}
+	stale := map[string]BinaryEncoder{}
+
+	for _, key := range keys {
+		dest := dests[key]
+
+		bytes, isHit := found[key]
+		if !isHit {
+			c.getMetrics().Track(CacheMiss)
+			missing[key] = dest
+			continue
+		}
+
+		entry, err := c.decodeEntry(bytes)
+		if err == nil {
+			if entry.isExpired(now) {
+				c.getMetrics().Track(CacheMiss)
+				missing[key] = dest
+				continue
+			}
+
+			if entry.negative {
+				c.getMetrics().Track(CacheNegativeHit)
+				delete(dests, key)
+				continue
+			}
+
+			if c.isEarlyExpired(entry, now) {
+				c.getMetrics().Track(CacheEarlyExpiry)
+				c.getMetrics().Track(CacheMiss)
+				missing[key] = dest
+				continue
+			}
+
+			err = dest.UnmarshalBinary(entry.payload)
+		}
+		if err != nil {
+			// treat as a miss so that the "bad" data is replaced
+			c.log(LevelError, OpGetMulti, "cache hit unmarshal error", Field{FieldKey, key}, Field{FieldError, err})
+			c.getMetrics().Track(CacheUnmarshalError)
+			missing[key] = dest
+			continue
+		}
+
+		c.getMetrics().Track(CacheHit)
+
+		if entry.isStale(now) {
+			c.getMetrics().Track(CacheStaleHit)
+			stale[key] = dest
+		}
+	}
+
+	if len(stale) > 0 {
+		c.refreshMulti(ctx, stale, builder)
+	}
+
+	if len(missing) == 0 {
+		return nil
+	}
+
+	missingKeys := make([]string, 0, len(missing))
+	for key := range missing {
+		missingKeys = append(missingKeys, key)
+	}
+
+	_, err = c.buildMulti(ctx, missing, builder)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range missingKeys {
+		if _, exists := missing[key]; !exists {
+			// removed by the builder
+			delete(dests, key)
+		}
+	}
+
+	return nil
+}
+
+// run the batch builder and asynchronously save the results to the cache; returns the marshalled values (by key)
+func (c *Client) buildMulti(ctx context.Context, dests map[string]BinaryEncoder, builder BatchBuilder) (map[string][]byte, error) {
+	requested := make([]string, 0, len(dests))
+	for key := range dests {
+		requested = append(requested, key)
+	}
+
+	start := time.Now()
+	ctx = WithVersion(ctx, start.UnixNano())
+	ctx, span := c.startSpan(ctx, "cache.BuildMulti", attrCacheKeys.Int(len(requested)))
+
+	err := builder.BuildMulti(ctx, dests)
+	if err != nil {
+		endSpan(span, 0, err)
+		c.observeBuild(start, len(requested), 0, err)
+		c.log(LevelWarn, OpBuild, "cache miss build multi error", Field{FieldKeys, len(requested)}, Field{FieldError, err})
+		c.getMetrics().Track(CacheLambdaError)
+		return nil, &LambdaError{
+			Cause: err,
+		}
+	}
+
+	buildDuration := c.buildDurationSince(start)
+
+	size := 0
+	out := make(map[string][]byte, len(requested))
+	entries := make(map[string]*envelope, len(requested))
+	for _, key := range requested {
+		dest, found := dests[key]
+		if !found {
+			if c.NegativeTTL > 0 {
+				entries[key] = c.newNegativeEnvelope()
+			}
+			continue
+		}
+
+		bytes, err := dest.MarshalBinary()
+		if err != nil {
+			c.log(LevelError, OpBuild, "cache update marshal error", Field{FieldKey, key}, Field{FieldError, err})
+			c.getMetrics().Track(CacheMarshalError)
+			continue
+		}
+
+		entries[key] = c.newEnvelope(bytes, 0)
+		entries[key].tags = tagsOf(dest)
+		entries[key].buildDuration = buildDuration
+
+		out[key] = bytes
+		size += len(bytes)
+	}
+
+	endSpan(span, size, nil)
+	c.observeBuild(start, len(requested), size, nil)
+
+	if len(entries) > 0 {
+		c.setMultiAsync(ctx, entries)
+	}
+
+	return out, nil
+}
+
+// build fresh copies of the stale values in the background; keys that already have a build in-flight (from Get or
+// another GetMulti) are skipped
+func (c *Client) refreshMulti(ctx context.Context, stale map[string]BinaryEncoder, builder BatchBuilder) {
+	refreshDests := make(map[string]BinaryEncoder, len(stale))
+	for key, dest := range stale {
+		refreshDest, ok := newDestLike(dest)
+		if !ok {
+			c.log(LevelWarn, OpBuild, "cache stale refresh skipped, dest must be a pointer", Field{FieldKey, key})
+			continue
+		}
+
+		refreshDests[key] = refreshDest
+	}
+
+	if len(refreshDests) == 0 || !c.startRefresh() {
+		return
+	}
+
+	keys := make([]string, 0, len(refreshDests))
+	for key := range refreshDests {
+		keys = append(keys, key)
+	}
+
+	started := c.inflight.doAsyncMulti(keys, func(keys []string) (map[string][]byte, error) {
+		defer c.finishAsyncWrite()
+
+		dests := make(map[string]BinaryEncoder, len(keys))
+		for _, key := range keys {
+			dests[key] = refreshDests[key]
+		}
+
+		ctx, span := c.startAsyncSpan(withRefresh(ctx), "cache.RefreshMulti", attrCacheKeys.Int(len(dests)))
+		defer span.End()
+
+		return c.buildMulti(ctx, dests, builder)
+	})
+	if len(started) == 0 {
+		c.finishAsyncWrite()
+	}
+}
+
+// SetMulti will update the cache with the supplied key/value pairs
+// NOTE: generally this need not be called is it is called implicitly by GetMulti
+func (c *Client) SetMulti(ctx context.Context, vals map[string]encoding.BinaryMarshaler) {
+	entries := make(map[string]*envelope, len(vals))
+	for key, val := range vals {
+		bytes, err := val.MarshalBinary()
+		if err != nil {
+			c.log(LevelError, OpSetMulti, "cache update marshal error", Field{FieldKey, key}, Field{FieldError, err})
+			c.getMetrics().Track(CacheMarshalError)
+			continue
+		}
+
+		entries[key] = c.newEnvelope(bytes, 0)
+		entries[key].tags = tagsOf(val)
+	}
+
+	c.setEnvelopes(ctx, entries)
+}
+
+// asynchronously save the envelopes into storage
+func (c *Client) setMultiAsync(ctx context.Context, entries map[string]*envelope) {
+	if !c.startAsyncWrite(ctx) {
+		c.log(LevelWarn, OpSetMulti, "cache update skipped as client is closed", Field{FieldKeys, len(entries)})
+		return
+	}
+
+	c.runAsync(func() {
+		ctx, span := c.startAsyncSpan(ctx, "cache.AsyncSetMulti", attrCacheKeys.Int(len(entries)))
+		defer span.End()
+
+		c.setEnvelopes(ctx, entries)
+	})
+}
+
+// save the envelopes into storage
+func (c *Client) setEnvelopes(ctx context.Context, entries map[string]*envelope) {
+	// use independent context so we don't miss cache updated
+	ctx, cancelFn := context.WithTimeout(ctx, c.getWriteTimeout())
+	defer cancelFn()
+
+	items := make(map[string][]byte, len(entries))
+	for key, entry := range entries {
+		c.compress(key, entry)
+
+		if entry.ttl > 0 {
+			// items with their own TTL cannot be batched
+			err := setWithTTL(ctx, c.storage(), key, entry.encode(), entry.ttl)
+			if err != nil {
+				c.log(LevelError, OpSetMulti, "cache update set error", Field{FieldKey, key}, Field{FieldError, err})
+				c.getMetrics().Track(CacheSetError)
+				continue
+			}
+
+			c.tag(ctx, key, entry)
+			continue
+		}
+
+		items[key] = entry.encode()
+	}
+
+	if len(items) == 0 {
+		return
+	}
+
+	err := setMulti(ctx, c.storage(), items)
+	if err != nil {
+		c.log(LevelError, OpSetMulti, "cache update set multi error", Field{FieldKeys, len(items)}, Field{FieldError, err})
+		c.getMetrics().Track(CacheSetError)
+		return
+	}
+
+	for key := range items {
+		c.tag(ctx, key, entries[key])
+	}
+}
+
+// BatchBuilder builds the data for many keys at once
+type BatchBuilder interface {
+	// BuildMulti populates the supplied destinations (a map of cache key to destination).
+	//
+	// It is only called with the keys that missed.  Keys that do not exist should be deleted from dests.
+	BuildMulti(ctx context.Context, dests map[string]BinaryEncoder) error
+}
+
+// BatchBuilderFunc implements BatchBuilder as a function
+type BatchBuilderFunc func(ctx context.Context, dests map[string]BinaryEncoder) error
+
+// BuildMulti implements BatchBuilder
+func (b BatchBuilderFunc) BuildMulti(ctx context.Context, dests map[string]BinaryEncoder) error {
+	return b(ctx, dests)
+}
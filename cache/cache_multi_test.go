@@ -0,0 +1,224 @@
+// Copyright 2017 Corey Scott http://www.sage42.org/
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"encoding"
+	"errors"
+	"sort"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClient_GetMulti(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	keyHit := getTestKey() + ".hit"
+	keyMiss := getTestKey() + ".miss"
+	keyNotFound := getTestKey() + ".notfound"
+
+	storage := &MemoryStorage{}
+	assert.Nil(t, storage.Set(ctx, keyHit, []byte(`{"name": "cached"}`)))
+
+	metrics := &MockMetrics{}
+	metrics.On("Track", CacheHit).Once()
+	metrics.On("Track", CacheMiss).Twice()
+
+	client := &Client{
+		Storage: storage,
+		Metrics: metrics,
+	}
+
+	dests := map[string]BinaryEncoder{
+		keyHit:      &myDTO{},
+		keyMiss:     &myDTO{},
+		keyNotFound: &myDTO{},
+	}
+
+	// make the call
+	var builderKeys []string
+	resultErr := client.GetMulti(ctx, dests, BatchBuilderFunc(func(ctx context.Context, dests map[string]BinaryEncoder) error {
+		for key, dest := range dests {
+			builderKeys = append(builderKeys, key)
+
+			if key == keyNotFound {
+				delete(dests, key)
+				continue
+			}
+
+			dest.(*myDTO).Name = "built"
+		}
+		return nil
+	}))
+	assert.Nil(t, resultErr)
+
+	// validate
+	sort.Strings(builderKeys)
+	assert.Equal(t, []string{keyMiss, keyNotFound}, builderKeys)
+
+	assert.Equal(t, 2, len(dests))
+	assert.Equal(t, "cached", dests[keyHit].(*myDTO).Name)
+	assert.Equal(t, "built", dests[keyMiss].(*myDTO).Name)
+
+	err := client.waitForPending(1 * time.Second)
+	assert.Nil(t, err)
+
+	result, resultErr := storage.Get(ctx, keyMiss)
+	assert.Nil(t, resultErr)
+	assert.Equal(t, `{"Name":"built","Email":""}`, string(result))
+
+	assert.True(t, metrics.AssertExpectations(t))
+}
+
+func TestClient_GetMultiNegative(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	key := getTestKey()
+
+	client := &Client{
+		Storage:     &MemoryStorage{},
+		NegativeTTL: 1 * time.Minute,
+	}
+
+	builds := 0
+	builder := BatchBuilderFunc(func(ctx context.Context, dests map[string]BinaryEncoder) error {
+		builds++
+		delete(dests, key)
+		return nil
+	})
+
+	// first call runs the builder
+	dests := map[string]BinaryEncoder{key: &myDTO{}}
+	resultErr := client.GetMulti(ctx, dests, builder)
+	assert.Nil(t, resultErr)
+	assert.Equal(t, 0, len(dests))
+
+	err := client.waitForPending(1 * time.Second)
+	assert.Nil(t, err)
+
+	// second call is served from cache
+	dests = map[string]BinaryEncoder{key: &myDTO{}}
+	resultErr = client.GetMulti(ctx, dests, builder)
+	assert.Nil(t, resultErr)
+	assert.Equal(t, 0, len(dests))
+
+	assert.Equal(t, 1, builds)
+}
+
+func TestClient_GetMultiStaleCoalesced(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	keyA := getTestKey() + ".a"
+	keyB := getTestKey() + ".b"
+	totalCallers := 10
+
+	storage := &MemoryStorage{}
+	client := &Client{
+		Storage: storage,
+		SoftTTL: 1 * time.Millisecond,
+	}
+
+	client.Set(ctx, keyA, &myDTO{Name: "cached"})
+	client.Set(ctx, keyB, &myDTO{Name: "cached"})
+	<-time.After(5 * time.Millisecond)
+
+	var builds int64
+	release := make(chan struct{})
+	builder := BatchBuilderFunc(func(ctx context.Context, dests map[string]BinaryEncoder) error {
+		atomic.AddInt64(&builds, 1)
+		<-release
+
+		for _, dest := range dests {
+			dest.(*myDTO).Name = "built"
+		}
+		return nil
+	})
+
+	// make the calls; the stale values are returned while 1 refresh is in-flight
+	for x := 0; x < totalCallers; x++ {
+		dests := map[string]BinaryEncoder{keyA: &myDTO{}, keyB: &myDTO{}}
+		resultErr := client.GetMulti(ctx, dests, builder)
+		assert.Nil(t, resultErr)
+		assert.Equal(t, "cached", dests[keyA].(*myDTO).Name)
+	}
+
+	// a single key refresh joins the in-flight refresh
+	dest := &myDTO{}
+	resultErr := client.Get(ctx, keyA, dest, BuilderFunc(func(ctx context.Context, key string, dest BinaryEncoder) error {
+		atomic.AddInt64(&builds, 1)
+		return nil
+	}))
+	assert.Nil(t, resultErr)
+
+	close(release)
+
+	// validate
+	assert.Eventually(t, func() bool {
+		result, err := storage.Get(ctx, keyB)
+		if err != nil {
+			return false
+		}
+
+		entry, err := decodeEnvelope(result)
+		return err == nil && string(entry.payload) == `{"Name":"built","Email":""}`
+	}, 1*time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, int64(1), atomic.LoadInt64(&builds))
+}
+
+func TestClient_GetMultiLambdaError(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+
+	client := &Client{
+		Storage: &MemoryStorage{},
+	}
+
+	// make the call
+	resultErr := client.GetMulti(ctx, map[string]BinaryEncoder{getTestKey(): &myDTO{}}, BatchBuilderFunc(func(ctx context.Context, dests map[string]BinaryEncoder) error {
+		return errors.New("something failed")
+	}))
+	assert.IsType(t, &LambdaError{}, resultErr)
+}
+
+func TestClient_SetMulti(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	key := getTestKey()
+
+	storage := &MemoryStorage{}
+	client := &Client{
+		Storage: storage,
+	}
+
+	// make the call
+	client.SetMulti(ctx, map[string]encoding.BinaryMarshaler{
+		key: &myDTO{Name: "bob"},
+	})
+
+	// validate
+	result, resultErr := storage.Get(ctx, key)
+	assert.Nil(t, resultErr)
+	assert.Equal(t, `{"Name":"bob","Email":""}`, string(result))
+}
@@ -0,0 +1,310 @@
+// Copyright 2017 Corey Scott http://www.sage42.org/
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// default for how often the namespace version is re-read from storage
+const defaultNamespaceRefreshInterval = 10 * time.Second
+
+// the TTL of the namespace version in storage; it must outlive the values in the namespace as a new version is created
+// (and the namespace is thereby flushed) when the version is no longer found.  For the same reason the version is
+// pinned (see MemoryStorage) so that it is not evicted to make space for the values.
+const namespaceVersionTTL = 365 * 24 * time.Hour
+
+// the locally cached copy of the namespace version
+type namespaceVersion struct {
+	mutex     sync.Mutex
+	version   string
+	refreshed time.Time
+
+	// incremented by every BumpNamespace; a refresh that started before a bump must not replace the bumped version
+	bumps uint64
+
+	// coalesces concurrent refreshes of the version
+	refresh coalescer
+}
+
+// return the local copy of the version and whether it is still fresh; along with the number of bumps so far
+func (n *namespaceVersion) get(refreshInterval time.Duration) (string, bool, uint64) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	fresh := n.version != "" && time.Since(n.refreshed) < refreshInterval
+	return n.version, fresh, n.bumps
+}
+
+// update the local copy of the version unless it has been bumped since the supplied number of bumps
+func (n *namespaceVersion) set(version string, bumps uint64) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if n.bumps != bumps {
+		return
+	}
+
+	n.version = version
+	n.refreshed = time.Now()
+}
+
+// BumpNamespace logically invalidates every key in the client's namespace by writing a new namespace version into
+// storage.
+//
+// This client uses the new version immediately; other clients will use it after their next refresh
+// (see NamespaceRefreshInterval).  The old values are not removed and are left to expire via the storage TTL.
+func (c *Client) BumpNamespace(ctx context.Context) error {
+	if c.Namespace == "" {
+		return nil
+	}
+
+	version := newNamespaceVersion()
+
+	err := setWithTTL(withPinned(ctx), c.Storage, c.namespaceVersionKey(), []byte(version), namespaceVersionTTL)
+	if err != nil {
+		c.log(LevelError, OpSet, "cache namespace version set error", Field{FieldNamespace, c.Namespace}, Field{FieldError, err})
+		c.getMetrics().Track(CacheSetError)
+		return err
+	}
+
+	c.namespace.mutex.Lock()
+	defer c.namespace.mutex.Unlock()
+
+	c.namespace.bumps++
+	c.namespace.version = version
+	c.namespace.refreshed = time.Now()
+	return nil
+}
+
+// return the storage used by the client; when a namespace is set the keys are prefixed with the namespace and
+// version
+func (c *Client) storage() Storage {
+	storage := instrument(c.Storage, c.Metrics, c.Name, "")
+	if c.Namespace == "" {
+		return storage
+	}
+
+	return namespacedStorage{client: c, storage: storage}
+}
+
+// return the current namespace version (from the local copy when it is fresh).
+//
+// Concurrent refreshes are coalesced and made without holding the mutex, so callers only wait for storage when the
+// local copy is stale.
+func (c *Client) getNamespaceVersion(ctx context.Context) (string, error) {
+	previous, fresh, bumps := c.namespace.get(c.getNamespaceRefreshInterval())
+	if fresh {
+		return previous, nil
+	}
+
+	refresh := func() ([]byte, error) {
+		version, err := c.readNamespaceVersion(ctx)
+		return []byte(version), err
+	}
+
+	bytes, shared, err := c.namespace.refresh.do(ctx, "", refresh)
+	for shared && ctx.Err() == nil && (errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)) {
+		// the caller that started the refresh gave up; try again with this context
+		bytes, shared, err = c.namespace.refresh.do(ctx, "", refresh)
+	}
+
+	if err != nil {
+		if previous == "" {
+			return "", err
+		}
+
+		// continue with the previous version
+		c.log(LevelWarn, OpGet, "cache namespace version refresh error", Field{FieldNamespace, c.Namespace}, Field{FieldError, err})
+		return previous, nil
+	}
+
+	version := string(bytes)
+	c.namespace.set(version, bumps)
+	return version, nil
+}
+
+// read the namespace version from storage; when there is no version, a new version is added unless another client
+// adds one first (in which case that version is used)
+func (c *Client) readNamespaceVersion(ctx context.Context) (string, error) {
+	bytes, err := c.Storage.Get(ctx, c.namespaceVersionKey())
+	if err != ErrCacheMiss {
+		return string(bytes), err
+	}
+
+	// new namespace (or the version has expired from storage)
+	version := newNamespaceVersion()
+
+	added, err := add(withPinned(ctx), c.Storage, c.namespaceVersionKey(), []byte(version), namespaceVersionTTL)
+	if err != nil {
+		c.log(LevelError, OpSet, "cache namespace version set error", Field{FieldNamespace, c.Namespace}, Field{FieldError, err})
+		c.getMetrics().Track(CacheSetError)
+		return "", err
+	}
+
+	if added {
+		return version, nil
+	}
+
+	// lost the race to another client
+	bytes, err = c.Storage.Get(ctx, c.namespaceVersionKey())
+	return string(bytes), err
+}
+
+// generate a new namespace version
+func newNamespaceVersion() string {
+	return strconv.FormatInt(time.Now().UnixNano(), 36)
+}
+
+// the storage key of the namespace version
+func (c *Client) namespaceVersionKey() string {
+	return c.Namespace + ":version"
+}
+
+// return the interval between reads of the namespace version
+func (c *Client) getNamespaceRefreshInterval() time.Duration {
+	if c.NamespaceRefreshInterval > 0 {
+		return c.NamespaceRefreshInterval
+	}
+
+	return defaultNamespaceRefreshInterval
+}
+
+// namespacedStorage implements Storage by adding the client's namespace and namespace version to every key
+type namespacedStorage struct {
+	client  *Client
+	storage Storage
+}
+
+// Get implements Storage
+func (n namespacedStorage) Get(ctx context.Context, key string) ([]byte, error) {
+	prefix, err := n.prefix(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return n.storage.Get(ctx, prefix+key)
+}
+
+// Set implements Storage
+func (n namespacedStorage) Set(ctx context.Context, key string, bytes []byte) error {
+	prefix, err := n.prefix(ctx)
+	if err != nil {
+		return err
+	}
+
+	return n.storage.Set(ctx, prefix+key, bytes)
+}
+
+// SetWithTTL implements TTLStorage
+func (n namespacedStorage) SetWithTTL(ctx context.Context, key string, bytes []byte, ttl time.Duration) error {
+	prefix, err := n.prefix(ctx)
+	if err != nil {
+		return err
+	}
+
+	return setWithTTL(ctx, n.storage, prefix+key, bytes, ttl)
+}
+
+// Invalidate implements Storage
+func (n namespacedStorage) Invalidate(ctx context.Context, key string) error {
+	prefix, err := n.prefix(ctx)
+	if err != nil {
+		return err
+	}
+
+	return n.storage.Invalidate(ctx, prefix+key)
+}
+
+// GetMulti implements MultiStorage
+func (n namespacedStorage) GetMulti(ctx context.Context, keys []string) (map[string][]byte, error) {
+	prefix, err := n.prefix(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	prefixedKeys := make([]string, 0, len(keys))
+	for _, key := range keys {
+		prefixedKeys = append(prefixedKeys, prefix+key)
+	}
+
+	found, err := getMulti(ctx, n.storage, prefixedKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(map[string][]byte, len(found))
+	for _, key := range keys {
+		if bytes, isHit := found[prefix+key]; isHit {
+			out[key] = bytes
+		}
+	}
+
+	return out, nil
+}
+
+// SetMulti implements MultiStorage
+func (n namespacedStorage) SetMulti(ctx context.Context, items map[string][]byte) error {
+	prefix, err := n.prefix(ctx)
+	if err != nil {
+		return err
+	}
+
+	prefixedItems := make(map[string][]byte, len(items))
+	for key, bytes := range items {
+		prefixedItems[prefix+key] = bytes
+	}
+
+	return setMulti(ctx, n.storage, prefixedItems)
+}
+
+// Tag implements TagStorage; the tags are also namespaced
+func (n namespacedStorage) Tag(ctx context.Context, key string, tags []string, ttl time.Duration) error {
+	prefix, err := n.prefix(ctx)
+	if err != nil {
+		return err
+	}
+
+	prefixedTags := make([]string, 0, len(tags))
+	for _, name := range tags {
+		prefixedTags = append(prefixedTags, prefix+name)
+	}
+
+	return tag(ctx, n.storage, prefix+key, prefixedTags, ttl)
+}
+
+// InvalidateTag implements TagStorage; the tag is also namespaced
+func (n namespacedStorage) InvalidateTag(ctx context.Context, tag string) error {
+	prefix, err := n.prefix(ctx)
+	if err != nil {
+		return err
+	}
+
+	return invalidateTag(ctx, n.storage, prefix+tag)
+}
+
+// return the prefix for the keys in the current namespace version
+func (n namespacedStorage) prefix(ctx context.Context) (string, error) {
+	version, err := n.client.getNamespaceVersion(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return n.client.Namespace + ":" + version + ":", nil
+}
@@ -0,0 +1,340 @@
+// Copyright 2017 Corey Scott http://www.sage42.org/
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_namespace(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	key := getTestKey()
+
+	storage := &MemoryStorage{}
+	client := &Client{
+		Storage:   storage,
+		Namespace: "users",
+	}
+
+	// make the call
+	client.Set(ctx, key, &myDTO{Name: "bob"})
+
+	// validate the storage key has the namespace and version
+	version, resultErr := storage.Get(ctx, "users:version")
+	assert.Nil(t, resultErr)
+
+	result, resultErr := storage.Get(ctx, "users:"+string(version)+":"+key)
+	assert.Nil(t, resultErr)
+	assert.Equal(t, `{"Name":"bob","Email":""}`, string(result))
+
+	// validate the same key in another namespace is independent
+	other := &Client{
+		Storage:   storage,
+		Namespace: "orders",
+	}
+
+	builderCalls := 0
+	resultErr = other.Get(ctx, key, &myDTO{}, BuilderFunc(func(ctx context.Context, key string, dest BinaryEncoder) error {
+		builderCalls++
+		return nil
+	}))
+	assert.Nil(t, resultErr)
+	assert.Equal(t, 1, builderCalls)
+}
+
+func TestClient_BumpNamespace(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	key := getTestKey()
+
+	storage := &MemoryStorage{}
+	client := &Client{
+		Storage:   storage,
+		Namespace: "users",
+	}
+	otherInstance := &Client{
+		Storage:                  storage,
+		Namespace:                "users",
+		NamespaceRefreshInterval: 10 * time.Millisecond,
+	}
+
+	builderCalls := 0
+	builder := BuilderFunc(func(ctx context.Context, key string, dest BinaryEncoder) error {
+		builderCalls++
+		dest.(*myDTO).Name = "bob"
+		return nil
+	})
+
+	// populate the cache
+	client.Set(ctx, key, &myDTO{Name: "bob"})
+
+	resultErr := otherInstance.Get(ctx, key, &myDTO{}, builder)
+	assert.Nil(t, resultErr)
+	assert.Equal(t, 0, builderCalls)
+
+	// bump and then wait for the other instance to refresh
+	resultErr = client.BumpNamespace(ctx)
+	assert.Nil(t, resultErr)
+
+	<-time.After(20 * time.Millisecond)
+
+	// validate both clients miss
+	resultErr = client.Get(ctx, key, &myDTO{}, builder)
+	assert.Nil(t, resultErr)
+	assert.Equal(t, 1, builderCalls)
+
+	resultErr = otherInstance.Get(ctx, key, &myDTO{}, builder)
+	assert.Nil(t, resultErr)
+	assert.Equal(t, 2, builderCalls)
+
+	assert.Nil(t, client.waitForPending(1*time.Second))
+	assert.Nil(t, otherInstance.waitForPending(1*time.Second))
+}
+
+func TestClient_namespaceMulti(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	keyA := getTestKey() + ".a"
+	keyB := getTestKey() + ".b"
+
+	storage := &MemoryStorage{}
+	client := &Client{
+		Storage:   storage,
+		Namespace: "users",
+	}
+
+	// populate one of the keys
+	client.Set(ctx, keyA, &myDTO{Name: "A"})
+
+	// make the call
+	dests := map[string]BinaryEncoder{
+		keyA: &myDTO{},
+		keyB: &myDTO{},
+	}
+	resultErr := client.GetMulti(ctx, dests, BatchBuilderFunc(func(ctx context.Context, dests map[string]BinaryEncoder) error {
+		assert.Len(t, dests, 1)
+		dests[keyB].(*myDTO).Name = "B"
+		return nil
+	}))
+	assert.Nil(t, resultErr)
+	assert.Equal(t, "A", dests[keyA].(*myDTO).Name)
+	assert.Equal(t, "B", dests[keyB].(*myDTO).Name)
+
+	// validate the built value was saved in the namespace
+	assert.Nil(t, client.waitForPending(1*time.Second))
+
+	version, resultErr := storage.Get(ctx, "users:version")
+	assert.Nil(t, resultErr)
+
+	_, resultErr = storage.Get(ctx, strings.Join([]string{"users", string(version), keyB}, ":"))
+	assert.Nil(t, resultErr)
+}
+
+func TestClient_namespaceVersionError(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	key := getTestKey()
+
+	storage := &MockStorage{}
+	storage.On("Get", mock.Anything, "users:version").Return(nil, errors.New("something failed"))
+
+	metrics := &MockMetrics{}
+	metrics.On("Track", CacheGetError).Once()
+
+	client := &Client{
+		Storage:   storage,
+		Metrics:   metrics,
+		Namespace: "users",
+	}
+
+	// make the call
+	resultErr := client.Get(ctx, key, &myDTO{}, BuilderFunc(func(ctx context.Context, key string, dest BinaryEncoder) error {
+		assert.Fail(t, "builder should not be called")
+		return nil
+	}))
+	assert.NotNil(t, resultErr)
+
+	assert.True(t, storage.AssertExpectations(t))
+	assert.True(t, metrics.AssertExpectations(t))
+}
+
+func TestClient_namespaceVersionTTL(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+
+	now := time.Now()
+	storage := &MemoryStorage{
+		TTL: 1 * time.Hour,
+		now: func() time.Time {
+			return now
+		},
+	}
+	client := &Client{
+		Storage:   storage,
+		Namespace: "users",
+	}
+
+	// make the call
+	version, resultErr := client.getNamespaceVersion(ctx)
+	assert.Nil(t, resultErr)
+
+	// validate the version outlives the values in the namespace (and is not limited by the storage TTL)
+	now = now.Add(30 * 24 * time.Hour)
+
+	result, resultErr := storage.Get(ctx, "users:version")
+	assert.Nil(t, resultErr)
+	assert.Equal(t, version, string(result))
+}
+
+func TestClient_namespaceVersionNotEvicted(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+
+	storage := &MemoryStorage{MaxEntries: 10}
+	client := &Client{
+		Storage:                  storage,
+		Namespace:                "users",
+		NamespaceRefreshInterval: 1 * time.Millisecond,
+	}
+
+	version, resultErr := client.getNamespaceVersion(ctx)
+	require.NoError(t, resultErr)
+
+	// make the calls; filling the storage many times over and refreshing the version
+	for index := 0; index < 20; index++ {
+		key := "key-" + strconv.Itoa(index)
+
+		resultErr = client.Get(ctx, key, &myDTO{}, BuilderFunc(func(ctx context.Context, key string, dest BinaryEncoder) error {
+			dest.(*myDTO).Name = key
+			return nil
+		}))
+		require.NoError(t, resultErr)
+		require.NoError(t, client.waitForPending(1*time.Second))
+
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	// validate the version was not evicted (which would have flushed the namespace)
+	result, resultErr := client.getNamespaceVersion(ctx)
+	assert.Nil(t, resultErr)
+	assert.Equal(t, version, result)
+	assert.Equal(t, 11, storage.Len())
+}
+
+func TestClient_namespaceVersionLostRace(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+
+	// another instance creates the version between this client's read and write
+	storage := &slowNamespaceStorage{MemoryStorage: &MemoryStorage{}, missFirst: true}
+	assert.Nil(t, storage.Set(ctx, "users:version", []byte(`existing`)))
+
+	client := &Client{
+		Storage:   storage,
+		Namespace: "users",
+	}
+
+	// make the call
+	version, resultErr := client.getNamespaceVersion(ctx)
+
+	// validate
+	assert.Nil(t, resultErr)
+	assert.Equal(t, "existing", version)
+}
+
+func TestClient_namespaceVersionCoalesced(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	totalCallers := 10
+
+	storage := &slowNamespaceStorage{MemoryStorage: &MemoryStorage{}, delay: 20 * time.Millisecond}
+	clients := []*Client{
+		{Storage: storage, Namespace: "users"},
+		{Storage: storage, Namespace: "users"},
+	}
+
+	// make the calls
+	versions := make(chan string, totalCallers*len(clients))
+
+	wg := &sync.WaitGroup{}
+	for x := 0; x < totalCallers; x++ {
+		for _, client := range clients {
+			wg.Add(1)
+
+			go func(client *Client) {
+				defer wg.Done()
+
+				version, err := client.getNamespaceVersion(ctx)
+				assert.Nil(t, err)
+
+				versions <- version
+			}(client)
+		}
+	}
+	wg.Wait()
+	close(versions)
+
+	// validate every caller (in both clients) uses the same version and each client read it once (plus a re-read by
+	// the client that lost the race to add the version)
+	expected := <-versions
+	for version := range versions {
+		assert.Equal(t, expected, version)
+	}
+
+	assert.True(t, atomic.LoadInt64(&storage.gets) <= int64(len(clients)+1))
+}
+
+// wraps MemoryStorage to delay reads of the namespace version (and optionally report the first read as a miss)
+type slowNamespaceStorage struct {
+	*MemoryStorage
+
+	delay     time.Duration
+	missFirst bool
+	gets      int64
+}
+
+func (s *slowNamespaceStorage) Get(ctx context.Context, key string) ([]byte, error) {
+	if !strings.HasSuffix(key, ":version") {
+		return s.MemoryStorage.Get(ctx, key)
+	}
+
+	<-time.After(s.delay)
+
+	if atomic.AddInt64(&s.gets, 1) == 1 && s.missFirst {
+		return nil, ErrCacheMiss
+	}
+
+	return s.MemoryStorage.Get(ctx, key)
+}
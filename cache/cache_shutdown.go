@@ -0,0 +1,115 @@
+// Copyright 2017 Corey Scott http://www.sage42.org/
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// how often Flush checks for completion of the pending writes
+const flushPollInterval = 10 * time.Millisecond
+
+// PendingWrites returns the number of asynchronous cache writes (including background refreshes of stale values) that
+// have not yet completed
+func (c *Client) PendingWrites() int64 {
+	return atomic.LoadInt64(&c.pendingWrites)
+}
+
+// Flush will wait for all pending asynchronous cache writes to complete or for the context to be done.
+//
+// Writes started during the flush are also waited for, as are background refreshes of stale values (and the writes of
+// their results).
+func (c *Client) Flush(ctx context.Context) error {
+	ticker := time.NewTicker(flushPollInterval)
+	defer ticker.Stop()
+
+	for c.PendingWrites() > 0 {
+		select {
+		case <-ticker.C:
+			// check again
+
+		case <-ctx.Done():
+			// timeout/context cancelled
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
+
+// Close will stop the client from starting any new asynchronous cache writes and wait for the pending writes to
+// complete or for the context to be done.
+//
+// After Close, Get will continue to work but values built after a cache miss are no longer saved into the cache and
+// stale values are no longer refreshed.  Refreshes that started before Close are completed and saved.
+// This is intended to be called during graceful shutdown.
+func (c *Client) Close(ctx context.Context) error {
+	atomic.StoreInt32(&c.closed, 1)
+
+	err := c.Flush(ctx)
+	if err != nil {
+		return err
+	}
+
+	// no more writes can arrive; release the workers
+	c.writePool.shutdown()
+	return nil
+}
+
+// returns true when Close has been called
+func (c *Client) isClosed() bool {
+	return atomic.LoadInt32(&c.closed) == 1
+}
+
+// register the start of an async write; returns false when the client is closed and the write should be skipped.
+//
+// Writes made by a background refresh (see withRefresh) are never skipped as the refresh was registered before Close.
+func (c *Client) startAsyncWrite(ctx context.Context) bool {
+	// increment before checking so that Close will either wait for this write or this write will see closed
+	atomic.AddInt64(&c.pendingWrites, 1)
+
+	if c.isClosed() && !isRefresh(ctx) {
+		atomic.AddInt64(&c.pendingWrites, -1)
+		return false
+	}
+
+	return true
+}
+
+// register the completion of an async write
+func (c *Client) finishAsyncWrite() {
+	atomic.AddInt64(&c.pendingWrites, -1)
+}
+
+// register the start of a background refresh; the refresh is tracked as a pending write until finishAsyncWrite is
+// called.  Returns false when the client is closed and the refresh should be skipped
+func (c *Client) startRefresh() bool {
+	return c.startAsyncWrite(context.Background())
+}
+
+type refreshKey struct{}
+
+// mark the context as belonging to a background refresh
+func withRefresh(ctx context.Context) context.Context {
+	return context.WithValue(ctx, refreshKey{}, true)
+}
+
+// returns true when the context belongs to a background refresh
+func isRefresh(ctx context.Context) bool {
+	refresh, _ := ctx.Value(refreshKey{}).(bool)
+	return refresh
+}
@@ -0,0 +1,177 @@
+// Copyright 2017 Corey Scott http://www.sage42.org/
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestClient_Flush(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	key := getTestKey()
+
+	// build a client and mock storage with a slow write
+	release := make(chan struct{})
+	storage := &MockStorage{}
+	storage.On("Get", mock.Anything, key).Return(nil, ErrCacheMiss)
+	storage.On("Set", mock.Anything, key, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		<-release
+	})
+
+	client := &Client{
+		Storage: storage,
+	}
+
+	// make the call
+	resultErr := client.Get(ctx, key, &myDTO{}, BuilderFunc(func(ctx context.Context, key string, dest BinaryEncoder) error {
+		return nil
+	}))
+	assert.Nil(t, resultErr)
+	assert.Equal(t, int64(1), client.PendingWrites())
+
+	// flush times out while the write is pending
+	flushCtx, flushCancelFn := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer flushCancelFn()
+
+	resultErr = client.Flush(flushCtx)
+	assert.Equal(t, context.DeadlineExceeded, resultErr)
+
+	// flush completes once the write is done
+	close(release)
+
+	resultErr = client.Flush(ctx)
+	assert.Nil(t, resultErr)
+	assert.Equal(t, int64(0), client.PendingWrites())
+
+	assert.True(t, storage.AssertExpectations(t))
+}
+
+func TestClient_Close(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	key := getTestKey()
+	dest := &myDTO{}
+
+	// build a client and mock storage (no writes expected)
+	storage := &MockStorage{}
+	storage.On("Get", mock.Anything, key).Return(nil, ErrCacheMiss)
+
+	client := &Client{
+		Storage: storage,
+	}
+
+	resultErr := client.Close(ctx)
+	assert.Nil(t, resultErr)
+
+	// gets continue to work but values are not saved
+	resultErr = client.Get(ctx, key, dest, BuilderFunc(func(ctx context.Context, key string, dest BinaryEncoder) error {
+		dest.(*myDTO).Name = "bob"
+		return nil
+	}))
+	assert.Nil(t, resultErr)
+	assert.Equal(t, "bob", dest.Name)
+	assert.Equal(t, int64(0), client.PendingWrites())
+
+	assert.True(t, storage.AssertExpectations(t))
+}
+
+func TestClient_CloseWaitsForRefresh(t *testing.T) {
+	scenarios := []struct {
+		desc string
+		get  func(ctx context.Context, client *Client, key string, release chan struct{}) error
+	}{
+		{
+			desc: "Get",
+			get: func(ctx context.Context, client *Client, key string, release chan struct{}) error {
+				return client.Get(ctx, key, &myDTO{}, BuilderFunc(func(ctx context.Context, key string, dest BinaryEncoder) error {
+					<-release
+
+					dest.(*myDTO).Name = "refreshed"
+					return nil
+				}))
+			},
+		},
+		{
+			desc: "GetMulti",
+			get: func(ctx context.Context, client *Client, key string, release chan struct{}) error {
+				dests := map[string]BinaryEncoder{key: &myDTO{}}
+				return client.GetMulti(ctx, dests, BatchBuilderFunc(func(ctx context.Context, dests map[string]BinaryEncoder) error {
+					<-release
+
+					dests[key].(*myDTO).Name = "refreshed"
+					return nil
+				}))
+			},
+		},
+	}
+
+	for _, s := range scenarios {
+		scenario := s
+		t.Run(scenario.desc, func(t *testing.T) {
+			// inputs
+			ctx, cancelFn := context.WithCancel(context.Background())
+			defer cancelFn()
+			key := getTestKey()
+
+			storage := &MemoryStorage{}
+			client := &Client{
+				Storage: storage,
+				SoftTTL: 1 * time.Millisecond,
+			}
+
+			client.Set(ctx, key, &myDTO{Name: "stale"})
+			<-time.After(5 * time.Millisecond)
+
+			// start a refresh
+			release := make(chan struct{})
+			resultErr := scenario.get(ctx, client, key, release)
+			assert.Nil(t, resultErr)
+			assert.Equal(t, int64(1), client.PendingWrites())
+
+			// close waits for the refresh
+			closeErr := make(chan error, 1)
+			go func() {
+				closeErr <- client.Close(ctx)
+			}()
+
+			select {
+			case <-closeErr:
+				assert.Fail(t, "close returned while the refresh was in-flight")
+
+			case <-time.After(20 * time.Millisecond):
+			}
+
+			close(release)
+			assert.Nil(t, <-closeErr)
+			assert.Equal(t, int64(0), client.PendingWrites())
+
+			// the refreshed value was saved
+			result, resultErr := storage.Get(ctx, key)
+			assert.Nil(t, resultErr)
+
+			entry, resultErr := decodeEnvelope(result)
+			assert.Nil(t, resultErr)
+			assert.Equal(t, `{"Name":"refreshed","Email":""}`, string(entry.payload))
+		})
+	}
+}
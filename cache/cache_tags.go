@@ -0,0 +1,74 @@
+// Copyright 2017 Corey Scott http://www.sage42.org/
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"encoding"
+)
+
+// Tagged is an optional extension of the cached values (BinaryEncoder) for values that should be tagged when they
+// are saved.
+//
+// This allows values created by a Builder to be tagged; e.g. a view of a user could return "user:<id>" so that all
+// views of the user can be removed with a single call to Client.InvalidateTag.
+type Tagged interface {
+	// CacheTags returns the tags for this value
+	CacheTags() []string
+}
+
+// SetWithTags will update the cache with the supplied key/value pair and associate the key with the supplied tags
+// (in addition to any returned by the value when it implements Tagged).
+//
+// The Storage must implement TagStorage; otherwise the value is saved but not tagged and the error is logged.
+func (c *Client) SetWithTags(ctx context.Context, key string, val encoding.BinaryMarshaler, tags ...string) {
+	c.set(ctx, key, val, 0, tags)
+}
+
+// InvalidateTag will force invalidate all keys in the cache that are associated with the tag.
+//
+// Returns ErrTagsNotSupported when the Storage does not implement TagStorage.
+func (c *Client) InvalidateTag(ctx context.Context, tag string) error {
+	err := invalidateTag(ctx, c.storage(), tag)
+	if err != nil {
+		c.log(LevelError, OpInvalidateTag, "cache invalidate tag error", Field{FieldTag, tag}, Field{FieldError, err})
+		c.getMetrics().Track(CacheInvalidateError)
+		return err
+	}
+
+	return nil
+}
+
+// associate the saved key with the envelope's tags (if any)
+func (c *Client) tag(ctx context.Context, key string, entry *envelope) {
+	if len(entry.tags) == 0 {
+		return
+	}
+
+	err := tag(ctx, c.storage(), key, entry.tags, entry.ttl)
+	if err != nil {
+		c.log(LevelError, OpTag, "cache update tag error", Field{FieldKey, key}, Field{FieldError, err})
+		c.getMetrics().Track(CacheSetError)
+	}
+}
+
+// return the tags of the value (when it implements Tagged)
+func tagsOf(val interface{}) []string {
+	if tagged, ok := val.(Tagged); ok {
+		return tagged.CacheTags()
+	}
+
+	return nil
+}
@@ -0,0 +1,149 @@
+// Copyright 2017 Corey Scott http://www.sage42.org/
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestClient_InvalidateTag(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	builtKey := getTestKey() + ".built"
+	setKey := getTestKey() + ".set"
+	otherKey := getTestKey() + ".other"
+
+	storage := &MemoryStorage{}
+	client := &Client{
+		Storage: storage,
+	}
+
+	// populate the cache via a builder (tagged by the value) and via set
+	resultErr := client.Get(ctx, builtKey, &myTaggedDTO{}, BuilderFunc(func(ctx context.Context, key string, dest BinaryEncoder) error {
+		dest.(*myTaggedDTO).ID = "1"
+		return nil
+	}))
+	assert.Nil(t, resultErr)
+	assert.Nil(t, client.waitForPending(1*time.Second))
+
+	client.SetWithTags(ctx, setKey, &myDTO{Name: "bob"}, "user:1")
+	client.SetWithTags(ctx, otherKey, &myDTO{Name: "bob"}, "user:2")
+
+	// make the call
+	resultErr = client.InvalidateTag(ctx, "user:1")
+	assert.Nil(t, resultErr)
+
+	// validate
+	_, resultErr = storage.Get(ctx, builtKey)
+	assert.Equal(t, ErrCacheMiss, resultErr)
+
+	_, resultErr = storage.Get(ctx, setKey)
+	assert.Equal(t, ErrCacheMiss, resultErr)
+
+	_, resultErr = storage.Get(ctx, otherKey)
+	assert.Nil(t, resultErr)
+}
+
+func TestClient_InvalidateTag_notSupported(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	key := getTestKey()
+
+	storage := &MockStorage{}
+	storage.On("Set", mock.Anything, key, mock.Anything).Return(nil)
+
+	metrics := &MockMetrics{}
+	metrics.On("Track", CacheSetError).Once()
+	metrics.On("Track", CacheInvalidateError).Once()
+
+	client := &Client{
+		Storage: storage,
+		Metrics: metrics,
+	}
+
+	// the value is saved but cannot be tagged
+	client.SetWithTags(ctx, key, &myDTO{Name: "bob"}, "user:1")
+
+	// make the call
+	resultErr := client.InvalidateTag(ctx, "user:1")
+	assert.Equal(t, ErrTagsNotSupported, resultErr)
+
+	assert.True(t, storage.AssertExpectations(t))
+	assert.True(t, metrics.AssertExpectations(t))
+}
+
+func TestClient_InvalidateTag_namespaced(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	key := getTestKey()
+
+	storage := &MemoryStorage{}
+	users := &Client{
+		Storage:   storage,
+		Namespace: "users",
+	}
+	orders := &Client{
+		Storage:   storage,
+		Namespace: "orders",
+	}
+
+	users.SetWithTags(ctx, key, &myDTO{Name: "bob"}, "user:1")
+	orders.SetWithTags(ctx, key, &myDTO{Name: "bob"}, "user:1")
+
+	// make the call
+	resultErr := users.InvalidateTag(ctx, "user:1")
+	assert.Nil(t, resultErr)
+
+	// validate tags do not cross namespaces
+	builder := BuilderFunc(func(ctx context.Context, key string, dest BinaryEncoder) error {
+		return nil
+	})
+
+	_, resultErr = users.storage().Get(ctx, key)
+	assert.Equal(t, ErrCacheMiss, resultErr)
+
+	_, resultErr = orders.storage().Get(ctx, key)
+	assert.Nil(t, resultErr)
+
+	assert.Nil(t, orders.Get(ctx, key, &myDTO{}, builder))
+}
+
+type myTaggedDTO struct {
+	ID string
+}
+
+// CacheTags implements cache.Tagged
+func (m *myTaggedDTO) CacheTags() []string {
+	return []string{"user:" + m.ID}
+}
+
+// MarshalBinary implements cache.BinaryEncoder
+func (m *myTaggedDTO) MarshalBinary() (data []byte, err error) {
+	return []byte(m.ID), nil
+}
+
+// UnmarshalBinary implements cache.BinaryEncoder
+func (m *myTaggedDTO) UnmarshalBinary(data []byte) error {
+	m.ID = string(data)
+	return nil
+}
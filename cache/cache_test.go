@@ -257,6 +257,418 @@ func TestClient_cacheUnmarshalError(t *testing.T) {
 	assert.True(t, metrics.AssertExpectations(t))
 }
 
+func TestClient_cacheMissCoalesced(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	key := getTestKey()
+	totalCallers := 10
+
+	// build a client and mock storage
+	storage := &MockStorage{}
+	storage.On("Get", mock.Anything, key).Return(nil, ErrCacheMiss)
+	storage.On("Set", mock.Anything, key, mock.Anything).Return(nil).Once()
+
+	var coalesced int64
+	metrics := MetricsFunc(func(event Event) {
+		if event == CacheCoalesced {
+			atomic.AddInt64(&coalesced, 1)
+		}
+	})
+
+	client := &Client{
+		Storage: storage,
+		Metrics: metrics,
+	}
+
+	var builds int64
+	release := make(chan struct{})
+	builder := BuilderFunc(func(ctx context.Context, key string, dest BinaryEncoder) error {
+		atomic.AddInt64(&builds, 1)
+		<-release
+
+		concrete := dest.(*myDTO)
+		concrete.Name = "bob"
+		return nil
+	})
+
+	// make the calls
+	results := make(chan *myDTO, totalCallers)
+	for x := 0; x < totalCallers; x++ {
+		go func() {
+			dest := &myDTO{}
+			resultErr := client.Get(ctx, key, dest, builder)
+			assert.Nil(t, resultErr)
+
+			results <- dest
+		}()
+	}
+
+	// give the callers time to join the in-flight build
+	<-time.After(50 * time.Millisecond)
+	close(release)
+
+	for x := 0; x < totalCallers; x++ {
+		result := <-results
+		assert.Equal(t, "bob", result.Name)
+	}
+
+	err := client.waitForPending(1 * time.Second)
+	assert.Nil(t, err)
+
+	assert.Equal(t, int64(1), atomic.LoadInt64(&builds))
+	assert.Equal(t, int64(totalCallers-1), atomic.LoadInt64(&coalesced))
+	assert.True(t, storage.AssertExpectations(t))
+}
+
+func TestClient_cacheLambdaErrorCoalesced(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	key := getTestKey()
+
+	// build a client and mock storage
+	storage := &MockStorage{}
+	storage.On("Get", mock.Anything, key).Return(nil, ErrCacheMiss)
+
+	client := &Client{
+		Storage: storage,
+	}
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	builder := BuilderFunc(func(ctx context.Context, key string, dest BinaryEncoder) error {
+		close(started)
+		<-release
+
+		// simulate user lambda error
+		return errors.New("something failed")
+	})
+
+	// make the calls
+	leaderErr := make(chan error, 1)
+	go func() {
+		leaderErr <- client.Get(ctx, key, &myDTO{}, builder)
+	}()
+	<-started
+
+	waiterErr := make(chan error, 1)
+	go func() {
+		waiterErr <- client.Get(ctx, key, &myDTO{}, builder)
+	}()
+
+	// give the waiter time to join the in-flight build
+	<-time.After(50 * time.Millisecond)
+	close(release)
+
+	resultErr := <-leaderErr
+	assert.IsType(t, &LambdaError{}, resultErr)
+	assert.Equal(t, resultErr, <-waiterErr)
+}
+
+func TestClient_cacheMissCoalescedLeaderCancelled(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	key := getTestKey()
+
+	// build a client and mock storage
+	storage := &MockStorage{}
+	storage.On("Get", mock.Anything, key).Return(nil, ErrCacheMiss)
+	storage.On("Set", mock.Anything, key, mock.Anything).Return(nil).Once()
+
+	client := &Client{
+		Storage: storage,
+	}
+
+	var builds int64
+	started := make(chan struct{})
+	builder := BuilderFunc(func(ctx context.Context, key string, dest BinaryEncoder) error {
+		if atomic.AddInt64(&builds, 1) == 1 {
+			// the first build is abandoned by its caller
+			close(started)
+			<-ctx.Done()
+			return ctx.Err()
+		}
+
+		concrete := dest.(*myDTO)
+		concrete.Name = "bob"
+		return nil
+	})
+
+	// make the calls
+	leaderCtx, leaderCancelFn := context.WithCancel(ctx)
+
+	leaderErr := make(chan error, 1)
+	go func() {
+		leaderErr <- client.Get(leaderCtx, key, &myDTO{}, builder)
+	}()
+	<-started
+
+	waiter := &myDTO{}
+	waiterErr := make(chan error, 1)
+	go func() {
+		waiterErr <- client.Get(ctx, key, waiter, builder)
+	}()
+
+	// give the waiter time to join the in-flight build
+	<-time.After(50 * time.Millisecond)
+	leaderCancelFn()
+
+	// validate
+	resultErr := <-leaderErr
+	assert.IsType(t, &LambdaError{}, resultErr)
+	assert.True(t, errors.Is(resultErr, context.Canceled))
+
+	assert.Nil(t, <-waiterErr)
+	assert.Equal(t, "bob", waiter.Name)
+	assert.Equal(t, int64(2), atomic.LoadInt64(&builds))
+
+	err := client.waitForPending(1 * time.Second)
+	assert.Nil(t, err)
+	assert.True(t, storage.AssertExpectations(t))
+}
+
+func TestClient_cacheStaleHit(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	key := getTestKey()
+	dest := &myDTO{}
+
+	// build a client with a stale value already in storage
+	storage := &MemoryStorage{}
+	stale := &envelope{
+		softExpiry: time.Now().Add(-1 * time.Second),
+		payload:    []byte(`{"name": "stale"}`),
+	}
+	assert.Nil(t, storage.Set(ctx, key, stale.encode()))
+
+	metrics := &MockMetrics{}
+	metrics.On("Track", CacheHit)
+	metrics.On("Track", CacheStaleHit).Once()
+
+	client := &Client{
+		Storage: storage,
+		Metrics: metrics,
+		SoftTTL: 1 * time.Minute,
+	}
+
+	built := make(chan struct{})
+	builder := BuilderFunc(func(ctx context.Context, key string, dest BinaryEncoder) error {
+		defer close(built)
+
+		concrete := dest.(*myDTO)
+		concrete.Name = "fresh"
+		return nil
+	})
+
+	// the stale value is returned immediately
+	resultErr := client.Get(ctx, key, dest, builder)
+	assert.Nil(t, resultErr)
+	assert.Equal(t, "stale", dest.Name)
+
+	// and the value is refreshed in the background
+	<-built
+	<-time.After(10 * time.Millisecond)
+	err := client.waitForPending(1 * time.Second)
+	assert.Nil(t, err)
+
+	dest = &myDTO{}
+	resultErr = client.Get(ctx, key, dest, builder)
+	assert.Nil(t, resultErr)
+	assert.Equal(t, "fresh", dest.Name)
+
+	assert.True(t, metrics.AssertExpectations(t))
+}
+
+func TestClient_cacheNegativeHit(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	key := getTestKey()
+
+	// build a client
+	metrics := &MockMetrics{}
+	metrics.On("Track", CacheMiss).Once()
+	metrics.On("Track", CacheLambdaError).Once()
+	metrics.On("Track", CacheNegativeHit).Once()
+
+	client := &Client{
+		Storage:     &MemoryStorage{},
+		Metrics:     metrics,
+		NegativeTTL: 1 * time.Minute,
+	}
+
+	var builds int64
+	builder := BuilderFunc(func(ctx context.Context, key string, dest BinaryEncoder) error {
+		atomic.AddInt64(&builds, 1)
+		return ErrNotFound
+	})
+
+	// first call runs the builder
+	resultErr := client.Get(ctx, key, &myDTO{}, builder)
+	assert.IsType(t, &LambdaError{}, resultErr)
+	assert.True(t, errors.Is(resultErr, ErrNotFound))
+
+	err := client.waitForPending(1 * time.Second)
+	assert.Nil(t, err)
+
+	// second call is served from cache
+	resultErr = client.Get(ctx, key, &myDTO{}, builder)
+	assert.Equal(t, ErrNegativeHit, resultErr)
+	assert.True(t, errors.Is(resultErr, ErrNotFound))
+
+	assert.Equal(t, int64(1), atomic.LoadInt64(&builds))
+	assert.True(t, metrics.AssertExpectations(t))
+}
+
+func TestClient_cacheNegativeExpired(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	key := getTestKey()
+	dest := &myDTO{}
+
+	// build a client with an expired tombstone already in storage
+	storage := &MemoryStorage{}
+	tombstone := &envelope{
+		expiry:   time.Now().Add(-1 * time.Second),
+		negative: true,
+		payload:  []byte{},
+	}
+	assert.Nil(t, storage.Set(ctx, key, tombstone.encode()))
+
+	metrics := &MockMetrics{}
+	metrics.On("Track", CacheMiss).Once()
+
+	client := &Client{
+		Storage:     storage,
+		Metrics:     metrics,
+		NegativeTTL: 1 * time.Minute,
+	}
+
+	// make the call
+	resultErr := client.Get(ctx, key, dest, BuilderFunc(func(ctx context.Context, key string, dest BinaryEncoder) error {
+		concrete := dest.(*myDTO)
+		concrete.Name = "bob"
+		return nil
+	}))
+	assert.Nil(t, resultErr)
+	assert.Equal(t, "bob", dest.Name)
+
+	err := client.waitForPending(1 * time.Second)
+	assert.Nil(t, err)
+
+	assert.True(t, metrics.AssertExpectations(t))
+}
+
+func TestClient_cacheMissWithTTL(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	key := getTestKey()
+
+	storage := &MemoryStorage{}
+	client := &Client{
+		Storage: storage,
+	}
+
+	// make the call
+	resultErr := client.Get(ctx, key, &myDTO{}, TTLBuilderFunc(func(ctx context.Context, key string, dest BinaryEncoder) (time.Duration, error) {
+		concrete := dest.(*myDTO)
+		concrete.Name = "bob"
+
+		return 100 * time.Millisecond, nil
+	}))
+	assert.Nil(t, resultErr)
+
+	err := client.waitForPending(1 * time.Second)
+	assert.Nil(t, err)
+
+	// validate the item was saved and then expires
+	_, resultErr = storage.Get(ctx, key)
+	assert.Nil(t, resultErr)
+
+	<-time.After(150 * time.Millisecond)
+
+	_, resultErr = storage.Get(ctx, key)
+	assert.Equal(t, ErrCacheMiss, resultErr)
+}
+
+func TestClient_SetWithTTLNotSupported(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	key := getTestKey()
+
+	// build a client and mock storage (that does not implement TTLStorage)
+	var saved []byte
+	storage := &MockStorage{}
+	storage.On("Set", mock.Anything, key, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		saved = args.Get(2).([]byte)
+	})
+
+	client := &Client{
+		Storage: storage,
+	}
+
+	// make the call
+	client.SetWithTTL(ctx, key, &myDTO{Name: "bob"}, 1*time.Minute)
+
+	// validate the expiry is recorded so the client can enforce it
+	entry, resultErr := decodeEnvelope(saved)
+	assert.Nil(t, resultErr)
+	assert.False(t, entry.expiry.IsZero())
+	assert.Equal(t, `{"Name":"bob","Email":""}`, string(entry.payload))
+
+	assert.True(t, storage.AssertExpectations(t))
+}
+
+func TestClient_cacheMissVersion(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	key := getTestKey()
+	dest := &myDTO{}
+
+	// build a client and mock storage
+	var version int64
+	storage := &MockStorage{}
+	storage.On("Get", mock.Anything, key).Return(nil, ErrCacheMiss)
+	storage.On("Set", mock.Anything, key, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		version = versionOf(args.Get(0).(context.Context))
+	})
+
+	client := &Client{
+		Storage: storage,
+	}
+
+	// make the call
+	start := time.Now()
+	var end time.Time
+	resultErr := client.Get(ctx, key, dest, BuilderFunc(func(ctx context.Context, key string, dest BinaryEncoder) error {
+		<-time.After(10 * time.Millisecond)
+		end = time.Now()
+
+		return nil
+	}))
+	assert.Nil(t, resultErr)
+
+	err := client.waitForPending(1 * time.Second)
+	assert.Nil(t, err)
+
+	// validate the value is saved with the time the build started as the version
+	assert.True(t, version >= start.UnixNano())
+	assert.True(t, version < end.UnixNano())
+}
+
+func TestNewDestLike(t *testing.T) {
+	result, ok := newDestLike(&myDTO{Name: "bob"})
+	assert.True(t, ok)
+	assert.Equal(t, &myDTO{}, result)
+}
+
 type myDTO struct {
 	Name  string
 	Email string
@@ -274,20 +686,10 @@ func (m *myDTO) UnmarshalBinary(data []byte) error {
 
 // extra method to make tests predictable - wait for pending writes
 func (c *Client) waitForPending(maxWait time.Duration) error {
-	ticker := time.NewTicker(10 * time.Millisecond)
-	for range ticker.C {
-		select {
-		case <-time.After(maxWait):
-			return errors.New("timeout waiting for pending writes")
-
-		default:
-			if atomic.LoadInt64(&c.pendingWrites) == 0 {
-				return nil
-			}
-		}
-	}
+	ctx, cancelFn := context.WithTimeout(context.Background(), maxWait)
+	defer cancelFn()
 
-	return errors.New("cannot happen")
+	return c.Flush(ctx)
 }
 
 func getTestKey() string {
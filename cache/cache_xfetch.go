@@ -0,0 +1,49 @@
+// Copyright 2017 Corey Scott http://www.sage42.org/
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// source of randomness for early expiration; returns a value in the range [0.0, 1.0)
+var earlyExpiryRand = rand.Float64
+
+// returns true when the value should be treated as expired ahead of its expiry.
+//
+// This implements XFetch from "Optimal Probabilistic Cache Stampede Prevention" (Vattani, Chierichetti & Lowenstein);
+// a value is expired early when: now - buildDuration * beta * ln(random) >= expiry
+func (c *Client) isEarlyExpired(entry *envelope, now time.Time) bool {
+	if c.EarlyExpiryBeta <= 0 || entry.buildDuration <= 0 || entry.expiry.IsZero() {
+		return false
+	}
+
+	// use (0.0, 1.0] as ln(0) is -Inf
+	random := 1 - earlyExpiryRand()
+
+	gap := -float64(entry.buildDuration) * c.EarlyExpiryBeta * math.Log(random)
+	return gap >= float64(entry.expiry.Sub(now))
+}
+
+// return the time since start when it is required for early expiration
+func (c *Client) buildDurationSince(start time.Time) time.Duration {
+	if c.EarlyExpiryBeta <= 0 {
+		return 0
+	}
+
+	return time.Since(start)
+}
@@ -0,0 +1,188 @@
+// Copyright 2017 Corey Scott http://www.sage42.org/
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestClient_isEarlyExpired(t *testing.T) {
+	now := time.Now()
+
+	scenarios := []struct {
+		desc     string
+		beta     float64
+		random   float64
+		entry    *envelope
+		expected bool
+	}{
+		{
+			desc:   "disabled",
+			beta:   0,
+			random: 0.99999,
+			entry: &envelope{
+				expiry:        now.Add(1 * time.Millisecond),
+				buildDuration: 1 * time.Second,
+			},
+			expected: false,
+		},
+		{
+			desc:   "no expiry",
+			beta:   1,
+			random: 0.99999,
+			entry: &envelope{
+				buildDuration: 1 * time.Second,
+			},
+			expected: false,
+		},
+		{
+			desc:   "no build duration",
+			beta:   1,
+			random: 0.99999,
+			entry: &envelope{
+				expiry: now.Add(1 * time.Millisecond),
+			},
+			expected: false,
+		},
+		{
+			desc:   "far from expiry",
+			beta:   1,
+			random: 0.99999,
+			entry: &envelope{
+				// gap is ~1.15 seconds
+				expiry:        now.Add(1 * time.Minute),
+				buildDuration: 100 * time.Millisecond,
+			},
+			expected: false,
+		},
+		{
+			desc:   "close to expiry",
+			beta:   1,
+			random: 0.99999,
+			entry: &envelope{
+				expiry:        now.Add(1 * time.Second),
+				buildDuration: 100 * time.Millisecond,
+			},
+			expected: true,
+		},
+		{
+			desc:   "close to expiry but lucky",
+			beta:   1,
+			random: 0.5,
+			entry: &envelope{
+				// gap is ~69ms
+				expiry:        now.Add(1 * time.Second),
+				buildDuration: 100 * time.Millisecond,
+			},
+			expected: false,
+		},
+		{
+			desc:   "larger beta",
+			beta:   20,
+			random: 0.5,
+			entry: &envelope{
+				// gap is ~1.39 seconds
+				expiry:        now.Add(1 * time.Second),
+				buildDuration: 100 * time.Millisecond,
+			},
+			expected: true,
+		},
+	}
+
+	original := earlyExpiryRand
+	defer func() {
+		earlyExpiryRand = original
+	}()
+
+	for _, scenario := range scenarios {
+		t.Run(scenario.desc, func(t *testing.T) {
+			earlyExpiryRand = func() float64 {
+				return scenario.random
+			}
+
+			client := &Client{
+				EarlyExpiryBeta: scenario.beta,
+			}
+
+			result := client.isEarlyExpired(scenario.entry, now)
+			assert.Equal(t, scenario.expected, result)
+		})
+	}
+}
+
+func TestClient_Get_earlyExpiry(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	key := getTestKey()
+
+	metrics := &MockMetrics{}
+	metrics.On("Track", mock.Anything)
+
+	client := &Client{
+		Storage:         &MemoryStorage{},
+		Metrics:         metrics,
+		TTL:             200 * time.Millisecond,
+		EarlyExpiryBeta: 1,
+	}
+
+	builderCalls := 0
+	builder := BuilderFunc(func(ctx context.Context, key string, dest BinaryEncoder) error {
+		builderCalls++
+		<-time.After(10 * time.Millisecond)
+		return nil
+	})
+
+	// populate the cache
+	assert.Nil(t, client.Get(ctx, key, &myDTO{}, builder))
+	assert.Nil(t, client.waitForPending(1*time.Second))
+
+	// validate the expiry and build duration were stored
+	entry, resultErr := client.decodeEntry(testStoredBytes(t, client, key))
+	assert.Nil(t, resultErr)
+	assert.False(t, entry.expiry.IsZero())
+	assert.True(t, entry.buildDuration >= 10*time.Millisecond)
+
+	// a normal random value should hit
+	assert.Nil(t, client.Get(ctx, key, &myDTO{}, builder))
+	assert.Equal(t, 1, builderCalls)
+
+	// an extremely unlucky random value should miss (-ln(~1e-15) * 10ms is longer than the TTL)
+	original := earlyExpiryRand
+	defer func() {
+		earlyExpiryRand = original
+	}()
+	earlyExpiryRand = func() float64 {
+		return 1 - 1e-15
+	}
+
+	assert.Nil(t, client.Get(ctx, key, &myDTO{}, builder))
+	assert.Equal(t, 2, builderCalls)
+	assert.Nil(t, client.waitForPending(1*time.Second))
+
+	metrics.AssertCalled(t, "Track", CacheEarlyExpiry)
+}
+
+func testStoredBytes(t *testing.T, client *Client, key string) []byte {
+	bytes, err := client.storage().Get(context.Background(), key)
+	assert.Nil(t, err)
+
+	return bytes
+}
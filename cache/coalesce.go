@@ -0,0 +1,175 @@
+// Copyright 2017 Corey Scott http://www.sage42.org/
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// returned to waiting callers when the in-flight call did not complete normally (e.g. panicked)
+var errCoalescedCallFailed = errors.New("coalesced call did not complete")
+
+// coalescer ensures that only 1 build per key is in-flight at any one time.
+//
+// The zero value is ready to use.
+type coalescer struct {
+	mutex sync.Mutex
+	calls map[string]*coalescedCall
+}
+
+// a single in-flight build
+type coalescedCall struct {
+	done  chan struct{}
+	bytes []byte
+	err   error
+}
+
+// do will run the supplied function unless there is already a call in-flight for the key, in which case it will
+// wait for and return the result of the in-flight call.
+//
+// The returned bool indicates if the result came from another caller
+func (c *coalescer) do(ctx context.Context, key string, fn func() ([]byte, error)) ([]byte, bool, error) {
+	c.mutex.Lock()
+	if c.calls == nil {
+		c.calls = map[string]*coalescedCall{}
+	}
+
+	if call, found := c.calls[key]; found {
+		c.mutex.Unlock()
+
+		select {
+		case <-call.done:
+			return call.bytes, true, call.err
+
+		case <-ctx.Done():
+			// timeout/context cancelled
+			return nil, true, ctx.Err()
+		}
+	}
+
+	call := &coalescedCall{
+		done: make(chan struct{}),
+		err:  errCoalescedCallFailed,
+	}
+	c.calls[key] = call
+	c.mutex.Unlock()
+
+	defer c.finish(key, call)
+
+	call.bytes, call.err = fn()
+
+	return call.bytes, false, call.err
+}
+
+// doAsync will run the supplied function in a new goroutine unless there is already a call in-flight for the key.
+//
+// The returned bool indicates if the function was started
+func (c *coalescer) doAsync(key string, fn func() ([]byte, error)) bool {
+	c.mutex.Lock()
+	if c.calls == nil {
+		c.calls = map[string]*coalescedCall{}
+	}
+
+	if _, found := c.calls[key]; found {
+		c.mutex.Unlock()
+		return false
+	}
+
+	call := &coalescedCall{
+		done: make(chan struct{}),
+		err:  errCoalescedCallFailed,
+	}
+	c.calls[key] = call
+	c.mutex.Unlock()
+
+	go func() {
+		defer c.finish(key, call)
+
+		call.bytes, call.err = fn()
+	}()
+
+	return true
+}
+
+// doAsyncMulti will run the supplied function in a new goroutine for the keys that do not already have a call
+// in-flight; fn is called with those keys and returns the result for each of them.  Keys without a result are treated
+// as not found.
+//
+// The returned keys are those the function was started for
+func (c *coalescer) doAsyncMulti(keys []string, fn func(keys []string) (map[string][]byte, error)) []string {
+	c.mutex.Lock()
+	if c.calls == nil {
+		c.calls = map[string]*coalescedCall{}
+	}
+
+	calls := make(map[string]*coalescedCall, len(keys))
+	started := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if _, found := c.calls[key]; found {
+			continue
+		}
+
+		call := &coalescedCall{
+			done: make(chan struct{}),
+			err:  errCoalescedCallFailed,
+		}
+		c.calls[key] = call
+
+		calls[key] = call
+		started = append(started, key)
+	}
+	c.mutex.Unlock()
+
+	if len(started) == 0 {
+		return nil
+	}
+
+	go func() {
+		defer func() {
+			for key, call := range calls {
+				c.finish(key, call)
+			}
+		}()
+
+		results, err := fn(started)
+		for key, call := range calls {
+			bytes, found := results[key]
+
+			switch {
+			case err != nil:
+				call.err = err
+
+			case !found:
+				call.err = &LambdaError{Cause: ErrNotFound}
+
+			default:
+				call.bytes, call.err = bytes, nil
+			}
+		}
+	}()
+
+	return started
+}
+
+// remove the call from the in-flight list and release any waiters
+func (c *coalescer) finish(key string, call *coalescedCall) {
+	c.mutex.Lock()
+	delete(c.calls, key)
+	c.mutex.Unlock()
+
+	close(call.done)
+}
@@ -0,0 +1,290 @@
+// Copyright 2017 Corey Scott http://www.sage42.org/
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/golang/snappy"
+)
+
+// ErrUnknownCodec is returned when a cached value was compressed with a codec that this client does not know
+var ErrUnknownCodec = errors.New("unknown compression codec")
+
+// ErrDecompressedTooLarge is returned when a value would decompress to more than the codec's MaxDecompressedSize
+var ErrDecompressedTooLarge = errors.New("decompressed value too large")
+
+// IDs of the built-in codecs.  IDs are stored with every compressed value and must never be reused.
+const (
+	// CodecGzip is the ID of GzipCodec
+	CodecGzip byte = 1
+
+	// CodecSnappy is the ID of SnappyCodec
+	CodecSnappy byte = 2
+)
+
+// default min payload size for compression
+const defaultCompressionThreshold = 1024
+
+// default max size of a decompressed value
+const defaultMaxDecompressedSize = 64 * 1024 * 1024
+
+// return the max decompressed size (or the default when it is not set)
+func getMaxDecompressedSize(maxSize int) int {
+	if maxSize > 0 {
+		return maxSize
+	}
+
+	return defaultMaxDecompressedSize
+}
+
+// Codec compresses cache values before they are saved into storage
+//
+// The codec ID is stored with each compressed value so that values can always be decompressed, even after the
+// client has been configured to use a different codec.
+type Codec interface {
+	// ID uniquely identifies the codec; 0 is reserved and custom codecs should use IDs from 128 upwards
+	ID() byte
+
+	// Compress returns the compressed form of data
+	Compress(data []byte) ([]byte, error)
+
+	// Decompress returns the original data from the output of Compress
+	Decompress(data []byte) ([]byte, error)
+}
+
+// GzipCodec implements Codec using gzip
+type GzipCodec struct {
+	// Level is the gzip compression level (optional - default gzip.DefaultCompression)
+	Level int
+
+	// MaxDecompressedSize is the max size of a decompressed value; larger values return ErrDecompressedTooLarge
+	// (optional - default 64MB)
+	MaxDecompressedSize int
+
+	writers sync.Pool
+}
+
+// ID implements Codec
+func (g *GzipCodec) ID() byte {
+	return CodecGzip
+}
+
+// Compress implements Codec
+func (g *GzipCodec) Compress(data []byte) ([]byte, error) {
+	buffer := &bytes.Buffer{}
+
+	writer, err := g.getWriter(buffer)
+	if err != nil {
+		return nil, err
+	}
+	defer g.writers.Put(writer)
+
+	_, err = writer.Write(data)
+	if err != nil {
+		return nil, err
+	}
+
+	err = writer.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// Decompress implements Codec
+func (g *GzipCodec) Decompress(data []byte) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = reader.Close()
+	}()
+
+	maxSize := getMaxDecompressedSize(g.MaxDecompressedSize)
+
+	// read 1 byte more than the max so that larger values can be detected
+	out, err := io.ReadAll(io.LimitReader(reader, int64(maxSize)+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(out) > maxSize {
+		return nil, ErrDecompressedTooLarge
+	}
+
+	return out, nil
+}
+
+// return a pooled writer (or a new one) that will write to the supplied buffer
+func (g *GzipCodec) getWriter(buffer *bytes.Buffer) (*gzip.Writer, error) {
+	if writer, ok := g.writers.Get().(*gzip.Writer); ok {
+		writer.Reset(buffer)
+		return writer, nil
+	}
+
+	level := g.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+
+	return gzip.NewWriterLevel(buffer, level)
+}
+
+// SnappyCodec implements Codec using snappy; it trades compression ratio for speed
+type SnappyCodec struct {
+	// MaxDecompressedSize is the max size of a decompressed value; larger values return ErrDecompressedTooLarge
+	// (optional - default 64MB)
+	MaxDecompressedSize int
+}
+
+// ID implements Codec
+func (s SnappyCodec) ID() byte {
+	return CodecSnappy
+}
+
+// Compress implements Codec
+func (s SnappyCodec) Compress(data []byte) ([]byte, error) {
+	return snappy.Encode(nil, data), nil
+}
+
+// Decompress implements Codec
+//
+// The decompressed size is read from the snappy header, so larger values are rejected before any memory is allocated.
+func (s SnappyCodec) Decompress(data []byte) ([]byte, error) {
+	size, err := snappy.DecodedLen(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if size > getMaxDecompressedSize(s.MaxDecompressedSize) {
+		return nil, ErrDecompressedTooLarge
+	}
+
+	return snappy.Decode(nil, data)
+}
+
+// codecs that are always available for decompression
+var builtInCodecs = map[byte]Codec{
+	CodecGzip:   &GzipCodec{},
+	CodecSnappy: SnappyCodec{},
+}
+
+// CompressionStats summarizes the values written by a Client with Compression enabled
+type CompressionStats struct {
+	// RawBytes is the total size of the values before compression
+	RawBytes int64
+
+	// StoredBytes is the total size of the values as written to storage (compressed or not)
+	StoredBytes int64
+
+	// Compressed is the number of values that were stored compressed
+	Compressed int64
+
+	// Uncompressed is the number of values stored uncompressed (below the threshold or compression did not help)
+	Uncompressed int64
+}
+
+// CompressionStats returns the running totals for the values written by this client.
+//
+// This is intended to be exported as metrics; e.g. StoredBytes / RawBytes is the compression ratio.
+func (c *Client) CompressionStats() CompressionStats {
+	return CompressionStats{
+		RawBytes:     atomic.LoadInt64(&c.compressionStats.RawBytes),
+		StoredBytes:  atomic.LoadInt64(&c.compressionStats.StoredBytes),
+		Compressed:   atomic.LoadInt64(&c.compressionStats.Compressed),
+		Uncompressed: atomic.LoadInt64(&c.compressionStats.Uncompressed),
+	}
+}
+
+// compress the envelope payload (when enabled and worthwhile)
+func (c *Client) compress(key string, entry *envelope) {
+	if c.Compression == nil || entry.negative {
+		return
+	}
+
+	rawLength := int64(len(entry.payload))
+	atomic.AddInt64(&c.compressionStats.RawBytes, rawLength)
+
+	if len(entry.payload) >= c.getCompressionThreshold() {
+		compressed, err := c.Compression.Compress(entry.payload)
+		if err != nil {
+			// the value is still usable uncompressed
+			c.log(LevelWarn, OpSet, "cache compress error", Field{FieldKey, key}, Field{FieldError, err})
+		} else if len(compressed) < len(entry.payload) {
+			entry.payload = compressed
+			entry.codec = c.Compression.ID()
+
+			atomic.AddInt64(&c.compressionStats.StoredBytes, int64(len(compressed)))
+			atomic.AddInt64(&c.compressionStats.Compressed, 1)
+			return
+		}
+	}
+
+	atomic.AddInt64(&c.compressionStats.StoredBytes, rawLength)
+	atomic.AddInt64(&c.compressionStats.Uncompressed, 1)
+}
+
+// decode the bytes from storage into an envelope with an uncompressed payload
+func (c *Client) decodeEntry(data []byte) (*envelope, error) {
+	entry, err := decodeEnvelope(data)
+	if err != nil || entry.codec == 0 {
+		return entry, err
+	}
+
+	codec, err := c.getCodec(entry.codec)
+	if err != nil {
+		return nil, err
+	}
+
+	entry.payload, err = codec.Decompress(entry.payload)
+	if err != nil {
+		return nil, fmt.Errorf("decompress failed: %w", err)
+	}
+
+	entry.codec = 0
+	return entry, nil
+}
+
+// return the codec with the supplied ID; the configured codec is preferred over the built-in ones
+func (c *Client) getCodec(id byte) (Codec, error) {
+	if c.Compression != nil && c.Compression.ID() == id {
+		return c.Compression, nil
+	}
+
+	codec, found := builtInCodecs[id]
+	if !found {
+		return nil, ErrUnknownCodec
+	}
+
+	return codec, nil
+}
+
+// return the min payload size for compression
+func (c *Client) getCompressionThreshold() int {
+	if c.CompressionThreshold > 0 {
+		return c.CompressionThreshold
+	}
+
+	return defaultCompressionThreshold
+}
@@ -0,0 +1,220 @@
+// Copyright 2017 Corey Scott http://www.sage42.org/
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCodecs_roundTrip(t *testing.T) {
+	data := []byte(strings.Repeat("this is foo ", 100))
+
+	scenarios := []struct {
+		desc  string
+		codec Codec
+	}{
+		{
+			desc:  "gzip",
+			codec: &GzipCodec{},
+		},
+		{
+			desc:  "gzip best speed",
+			codec: &GzipCodec{Level: 1},
+		},
+		{
+			desc:  "snappy",
+			codec: SnappyCodec{},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		t.Run(scenario.desc, func(t *testing.T) {
+			// compress twice to exercise any pooled state
+			for x := 0; x < 2; x++ {
+				compressed, resultErr := scenario.codec.Compress(data)
+				assert.Nil(t, resultErr)
+				assert.True(t, len(compressed) < len(data))
+
+				result, resultErr := scenario.codec.Decompress(compressed)
+				assert.Nil(t, resultErr)
+				assert.Equal(t, data, result)
+			}
+		})
+	}
+}
+
+func TestCodecs_maxDecompressedSize(t *testing.T) {
+	data := []byte(strings.Repeat("this is foo ", 100))
+
+	scenarios := []struct {
+		desc  string
+		codec Codec
+	}{
+		{
+			desc:  "gzip",
+			codec: &GzipCodec{MaxDecompressedSize: len(data) - 1},
+		},
+		{
+			desc:  "snappy",
+			codec: SnappyCodec{MaxDecompressedSize: len(data) - 1},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		t.Run(scenario.desc, func(t *testing.T) {
+			compressed, resultErr := scenario.codec.Compress(data)
+			assert.Nil(t, resultErr)
+
+			result, resultErr := scenario.codec.Decompress(compressed)
+			assert.Nil(t, result)
+			assert.Equal(t, ErrDecompressedTooLarge, resultErr)
+		})
+	}
+
+	// values of exactly the max size are allowed
+	result, resultErr := (&GzipCodec{MaxDecompressedSize: len(data)}).Decompress(mustCompress(t, &GzipCodec{}, data))
+	assert.Nil(t, resultErr)
+	assert.Equal(t, data, result)
+
+	result, resultErr = SnappyCodec{MaxDecompressedSize: len(data)}.Decompress(mustCompress(t, SnappyCodec{}, data))
+	assert.Nil(t, resultErr)
+	assert.Equal(t, data, result)
+}
+
+func mustCompress(t *testing.T, codec Codec, data []byte) []byte {
+	compressed, err := codec.Compress(data)
+	assert.Nil(t, err)
+
+	return compressed
+}
+
+func TestClient_compression(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	smallKey := getTestKey() + ".small"
+	largeKey := getTestKey() + ".large"
+	large := &myDTO{Name: strings.Repeat("bob", 1000)}
+
+	storage := &MemoryStorage{}
+	client := &Client{
+		Storage:              storage,
+		Compression:          SnappyCodec{},
+		CompressionThreshold: 100,
+	}
+
+	// make the calls
+	client.Set(ctx, smallKey, &myDTO{Name: "bob"})
+	client.Set(ctx, largeKey, large)
+
+	// validate only the large value is compressed
+	stored, resultErr := storage.Get(ctx, smallKey)
+	assert.Nil(t, resultErr)
+	assert.Equal(t, `{"Name":"bob","Email":""}`, string(stored))
+
+	stored, resultErr = storage.Get(ctx, largeKey)
+	assert.Nil(t, resultErr)
+	entry, resultErr := decodeEnvelope(stored)
+	assert.Nil(t, resultErr)
+	assert.Equal(t, CodecSnappy, entry.codec)
+
+	// validate both values are readable
+	builder := BuilderFunc(func(ctx context.Context, key string, dest BinaryEncoder) error {
+		assert.Fail(t, "builder should not be called")
+		return nil
+	})
+
+	result := &myDTO{}
+	assert.Nil(t, client.Get(ctx, smallKey, result, builder))
+	assert.Equal(t, "bob", result.Name)
+
+	result = &myDTO{}
+	assert.Nil(t, client.Get(ctx, largeKey, result, builder))
+	assert.Equal(t, large, result)
+
+	// validate the stats
+	stats := client.CompressionStats()
+	assert.Equal(t, int64(1), stats.Compressed)
+	assert.Equal(t, int64(1), stats.Uncompressed)
+	assert.True(t, stats.StoredBytes < stats.RawBytes)
+}
+
+func TestClient_compressionChangedCodec(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	key := getTestKey()
+	data := &myDTO{Name: strings.Repeat("bob", 1000)}
+
+	storage := &MemoryStorage{}
+
+	// write with gzip
+	writer := &Client{
+		Storage:     storage,
+		Compression: &GzipCodec{},
+	}
+	writer.Set(ctx, key, data)
+
+	// read with a client using a different codec (and one with compression disabled)
+	builder := BuilderFunc(func(ctx context.Context, key string, dest BinaryEncoder) error {
+		assert.Fail(t, "builder should not be called")
+		return nil
+	})
+
+	for _, reader := range []*Client{{Storage: storage, Compression: SnappyCodec{}}, {Storage: storage}} {
+		result := &myDTO{}
+		assert.Nil(t, reader.Get(ctx, key, result, builder))
+		assert.Equal(t, data, result)
+	}
+}
+
+func TestClient_compressionUnknownCodec(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	key := getTestKey()
+
+	storage := &MemoryStorage{}
+	entry := &envelope{
+		expiry:  time.Now().Add(time.Minute),
+		codec:   200,
+		payload: []byte(`???`),
+	}
+	assert.Nil(t, storage.Set(ctx, key, entry.encode()))
+
+	metrics := &MockMetrics{}
+	metrics.On("Track", CacheUnmarshalError).Once()
+
+	client := &Client{
+		Storage: storage,
+		Metrics: metrics,
+	}
+
+	// make the call
+	resultErr := client.Get(ctx, key, &myDTO{}, BuilderFunc(func(ctx context.Context, key string, dest BinaryEncoder) error {
+		return nil
+	}))
+	assert.Equal(t, ErrUnknownCodec, resultErr)
+
+	// validate the unreadable value was removed
+	_, resultErr = storage.Get(ctx, key)
+	assert.Equal(t, ErrCacheMiss, resultErr)
+	assert.True(t, metrics.AssertExpectations(t))
+}
@@ -16,10 +16,23 @@ package cache
 
 import (
 	"errors"
+	"fmt"
+	"time"
 )
 
-// ErrCacheMiss is returned when the cache does not contain the requested key
-var ErrCacheMiss = errors.New("cache miss")
+var (
+	// ErrCacheMiss is returned when the cache does not contain the requested key
+	ErrCacheMiss = errors.New("cache miss")
+
+	// ErrNotFound should be returned by a Builder when the requested key authoritatively does not exist.
+	// When Client.NegativeTTL is set, this result will be cached.
+	ErrNotFound = errors.New("not found")
+
+	// ErrNegativeHit is returned by Client.Get when the cache contains a previous ErrNotFound result for the key.
+	//
+	// errors.Is(ErrNegativeHit, ErrNotFound) is true so callers can handle both with the same check.
+	ErrNegativeHit = fmt.Errorf("cached result: %w", ErrNotFound)
+)
 
 // Event denote the cache event type
 type Event int
@@ -54,24 +67,147 @@ const (
 	//
 	// If the BinaryEncoder is implemented correctly, this event should never happen
 	CacheMarshalError
+
+	// CacheCoalesced denotes a cache miss that was satisfied by another in-flight build of the same key.
+	// Each of these events represents 1 builder call that was saved.
+	CacheCoalesced
+
+	// CacheL1Hit denotes the key was found in the local tier of a TieredStorage
+	CacheL1Hit
+
+	// CacheL1Miss denotes the key was not found in the local tier of a TieredStorage
+	CacheL1Miss
+
+	// CacheL2Hit denotes the key was not found in the local tier but was found in the remote tier of a TieredStorage
+	CacheL2Hit
+
+	// CacheL2Miss denotes the key was not found in either tier of a TieredStorage
+	CacheL2Miss
+
+	// CacheStaleHit denotes the key was found in the cache but its SoftTTL had passed.
+	// The stale value was returned (and a CacheHit tracked) and a background refresh was started.
+	CacheStaleHit
+
+	// CacheNegativeHit denotes the key was found in the cache as a previous ErrNotFound result
+	CacheNegativeHit
+
+	// CacheWriteDropped denotes an asynchronous cache write was discarded as the write queue was full
+	CacheWriteDropped
+
+	// CacheDecryptError denotes a value read by EncryptedStorage could not be decrypted (and was treated as a miss)
+	CacheDecryptError
+
+	// CacheInvalidationMissed denotes invalidation messages from other instances may have been missed (e.g. after
+	// reconnecting); the local storage is purged when this happens
+	CacheInvalidationMissed
+
+	// CacheInvalidationLate denotes an invalidation message was received later than expected
+	CacheInvalidationLate
+
+	// CacheEarlyExpiry denotes the key was found in the cache but was treated as a miss as it was close to expiry
+	// (see Client.EarlyExpiryBeta).  A CacheMiss is also tracked.
+	CacheEarlyExpiry
 )
 
+// names of the events (in the same order as the constants)
+var eventNames = []string{
+	"hit",
+	"miss",
+	"get_error",
+	"set_error",
+	"invalidate_error",
+	"lambda_error",
+	"unmarshal_error",
+	"marshal_error",
+	"coalesced",
+	"l1_hit",
+	"l1_miss",
+	"l2_hit",
+	"l2_miss",
+	"stale_hit",
+	"negative_hit",
+	"write_dropped",
+	"decrypt_error",
+	"invalidation_missed",
+	"invalidation_late",
+	"early_expiry",
+}
+
+// String returns a short name for the event that is suitable for use as a metric name or label (e.g. "hit")
+func (e Event) String() string {
+	if e < 0 || int(e) >= len(eventNames) {
+		return "unknown"
+	}
+
+	return eventNames[e]
+}
+
 const (
-	// CbRedisStorage is tag for redis storage circuit breaker.
-	// This should be used for in calls to `hystrix.ConfigureCommand()`
+	// CbRedisStorage is the suggested hystrix command name for redis storage circuit breaker.
+	// This should be used with `hystrixbreaker.Breaker` and in calls to `hystrix.ConfigureCommand()`
 	CbRedisStorage = "CbRedisStorage"
 
 	// redis commands
 	redisGet    = "GET"
+	redisSet    = "SET"
 	redisSetex  = "SETEX"
 	redisExpire = "EXPIRE"
-
-	// CbDynamoDbStorage is tag for DynamoDB storage circuit breaker.
-	// This should be used for in calls to `hystrix.ConfigureCommand()`
+	redisMget   = "MGET"
+	redisDel    = "DEL"
+	redisZadd   = "ZADD"
+	redisZrem   = "ZREM"
+	redisZrange = "ZRANGE"
+
+	redisZremrangebyscore = "ZREMRANGEBYSCORE"
+	redisPublish          = "PUBLISH"
+
+	// redis cluster and sentinel commands/replies
+	redisClusterCommand    = "CLUSTER"
+	redisClusterSlotsArg   = "SLOTS"
+	redisAsking            = "ASKING"
+	redisMoved             = "MOVED"
+	redisAsk               = "ASK"
+	redisRole              = "ROLE"
+	redisRoleMaster        = "master"
+	redisSentinelCommand   = "SENTINEL"
+	redisSentinelMasterArg = "get-master-addr-by-name"
+	redisReadOnlyPrefix    = "READONLY"
+
+	// prefix of the redis sorted sets that hold the keys for each tag (scored by the expiry of the key)
+	redisTagKeyPrefix = "cache.tag:"
+
+	// CbDynamoDbStorage is the suggested hystrix command name for DynamoDB storage circuit breaker.
+	// This should be used with `hystrixbreaker.Breaker` and in calls to `hystrix.ConfigureCommand()`
 	CbDynamoDbStorage = "CbDynamoDbStorage"
 
 	// dynamo constants
-	ddbKey  = "key"
-	ddbData = "data"
-	ddbTTL  = "ttl"
+	ddbKey     = "key"
+	ddbData    = "data"
+	ddbTTL     = "ttl"
+	ddbVersion = "version"
+
+	// attributes of the manifest item that replaces the data of values that are split into chunks
+	ddbChunks     = "chunks"
+	ddbChecksum   = "checksum"
+	ddbGeneration = "generation"
+
+	// separates the key from the generation and index in the keys of chunk items
+	ddbChunkKeySeparator = "#chunk:"
+
+	// condition of versioned puts; the item is replaced when it has no version, an older (or the same) version or has
+	// expired (but not yet been deleted by DynamoDB)
+	ddbVersionCondition = "attribute_not_exists(#version) OR #version <= :version OR #ttl < :now"
+
+	// condition of puts that only save the item when the key does not exist (or has expired but not yet been deleted
+	// by DynamoDB)
+	ddbAddCondition = "attribute_not_exists(#key) OR #ttl < :now"
+
+	// dynamo batch limits
+	ddbBatchGetSize     = 100
+	ddbBatchWriteSize   = 25
+	ddbBatchMaxAttempts = 5
+
+	// delays between the retries of unprocessed batch items; the max delay doubles with each attempt
+	ddbBatchBaseDelay = 50 * time.Millisecond
+	ddbBatchMaxDelay  = 1 * time.Second
 )
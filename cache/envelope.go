@@ -0,0 +1,219 @@
+// Copyright 2017 Corey Scott http://www.sage42.org/
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// ErrBadEnvelope is returned when the stored data has an envelope header but could not be decoded
+var ErrBadEnvelope = errors.New("cache envelope is corrupt")
+
+// envelope header is: magic (3 bytes) + version (1 byte) + flags (1 byte) + optional fields (timestamps and durations
+// as varints, codec as 1 byte)
+var envelopeMagic = []byte{0xCA, 0xC4, 0xE0}
+
+const (
+	envelopeVersion = 1
+
+	envelopeHeaderLength = 5
+)
+
+// envelope flags denote which optional fields are present
+const (
+	envelopeFlagSoftExpiry byte = 1 << iota
+	envelopeFlagExpiry
+	envelopeFlagNegative
+	envelopeFlagCompressed
+	envelopeFlagBuildDuration
+
+	// all the flags this version is able to decode
+	envelopeKnownFlags = envelopeFlagSoftExpiry | envelopeFlagExpiry | envelopeFlagNegative | envelopeFlagCompressed |
+		envelopeFlagBuildDuration
+)
+
+// envelope holds the metadata the Client stores alongside the user's payload.
+//
+// Payloads are only wrapped when there is metadata to store (or when the payload itself starts with the envelope magic);
+// any data without the envelope header is treated as a bare payload so that existing cache entries remain readable.
+type envelope struct {
+	// the time after which the value should be refreshed in the background (zero means never)
+	softExpiry time.Time
+
+	// the time after which the value must no longer be used (zero means the storage TTL alone applies)
+	expiry time.Time
+
+	// denotes a "tombstone" that records that the builder returned ErrNotFound
+	negative bool
+
+	// the ID of the Codec used to compress the payload (zero means not compressed)
+	codec byte
+
+	// how long the builder took to create the value (zero means unknown)
+	buildDuration time.Duration
+
+	// the user's payload (the output of BinaryEncoder.MarshalBinary); compressed when codec is set
+	payload []byte
+
+	// the TTL to use when saving this envelope (zero means the storage default); this is not encoded
+	ttl time.Duration
+
+	// the tags to associate with the key when saving this envelope; this is not encoded
+	tags []string
+}
+
+// returns true when the envelope has a soft expiry and it has passed
+func (e *envelope) isStale(now time.Time) bool {
+	return !e.softExpiry.IsZero() && now.After(e.softExpiry)
+}
+
+// returns true when the envelope has an expiry and it has passed
+func (e *envelope) isExpired(now time.Time) bool {
+	return !e.expiry.IsZero() && now.After(e.expiry)
+}
+
+// returns true when there is metadata to store (and therefore the envelope is required)
+func (e *envelope) hasMetadata() bool {
+	return e.flags() != 0
+}
+
+// calculate the flags for the fields present
+func (e *envelope) flags() byte {
+	var out byte
+
+	if !e.softExpiry.IsZero() {
+		out |= envelopeFlagSoftExpiry
+	}
+
+	if !e.expiry.IsZero() {
+		out |= envelopeFlagExpiry
+	}
+
+	if e.negative {
+		out |= envelopeFlagNegative
+	}
+
+	if e.codec != 0 {
+		out |= envelopeFlagCompressed
+	}
+
+	if e.buildDuration > 0 {
+		out |= envelopeFlagBuildDuration
+	}
+
+	return out
+}
+
+// encode the envelope into bytes; when there is no metadata the payload is returned as is (unless it would be mistaken
+// for an envelope)
+func (e *envelope) encode() []byte {
+	if !e.hasMetadata() && !bytes.HasPrefix(e.payload, envelopeMagic) {
+		return e.payload
+	}
+
+	out := make([]byte, 0, envelopeHeaderLength+3*binary.MaxVarintLen64+1+len(e.payload))
+	out = append(out, envelopeMagic...)
+	out = append(out, envelopeVersion, e.flags())
+
+	buffer := make([]byte, binary.MaxVarintLen64)
+	if !e.softExpiry.IsZero() {
+		length := binary.PutVarint(buffer, e.softExpiry.UnixNano())
+		out = append(out, buffer[:length]...)
+	}
+
+	if !e.expiry.IsZero() {
+		length := binary.PutVarint(buffer, e.expiry.UnixNano())
+		out = append(out, buffer[:length]...)
+	}
+
+	if e.codec != 0 {
+		out = append(out, e.codec)
+	}
+
+	if e.buildDuration > 0 {
+		length := binary.PutVarint(buffer, int64(e.buildDuration))
+		out = append(out, buffer[:length]...)
+	}
+
+	return append(out, e.payload...)
+}
+
+// decode the supplied bytes into an envelope
+func decodeEnvelope(data []byte) (*envelope, error) {
+	if len(data) < envelopeHeaderLength || !bytes.HasPrefix(data, envelopeMagic) {
+		// bare payload
+		return &envelope{payload: data}, nil
+	}
+
+	flags := data[4]
+	if data[3] != envelopeVersion || flags&^envelopeKnownFlags != 0 {
+		return nil, ErrBadEnvelope
+	}
+
+	data = data[envelopeHeaderLength:]
+
+	out := &envelope{
+		negative: flags&envelopeFlagNegative != 0,
+	}
+
+	var err error
+
+	if flags&envelopeFlagSoftExpiry != 0 {
+		out.softExpiry, data, err = decodeTime(data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if flags&envelopeFlagExpiry != 0 {
+		out.expiry, data, err = decodeTime(data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if flags&envelopeFlagCompressed != 0 {
+		if len(data) == 0 || data[0] == 0 {
+			return nil, ErrBadEnvelope
+		}
+
+		out.codec, data = data[0], data[1:]
+	}
+
+	if flags&envelopeFlagBuildDuration != 0 {
+		value, length := binary.Varint(data)
+		if length <= 0 || value <= 0 {
+			return nil, ErrBadEnvelope
+		}
+
+		out.buildDuration, data = time.Duration(value), data[length:]
+	}
+
+	out.payload = data
+	return out, nil
+}
+
+// decode a timestamp field and return the remaining data
+func decodeTime(data []byte) (time.Time, []byte, error) {
+	value, length := binary.Varint(data)
+	if length <= 0 {
+		return time.Time{}, nil, ErrBadEnvelope
+	}
+
+	return time.Unix(0, value), data[length:], nil
+}
@@ -0,0 +1,155 @@
+// Copyright 2017 Corey Scott http://www.sage42.org/
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnvelope_roundTrip(t *testing.T) {
+	scenarios := []struct {
+		desc string
+		in   *envelope
+	}{
+		{
+			desc: "no metadata",
+			in: &envelope{
+				payload: []byte(`this is foo`),
+			},
+		},
+		{
+			desc: "soft expiry",
+			in: &envelope{
+				softExpiry: time.Unix(0, time.Now().UnixNano()),
+				payload:    []byte(`this is foo`),
+			},
+		},
+		{
+			desc: "negative with expiry",
+			in: &envelope{
+				expiry:   time.Unix(0, time.Now().UnixNano()),
+				negative: true,
+				payload:  []byte{},
+			},
+		},
+		{
+			desc: "all fields",
+			in: &envelope{
+				softExpiry: time.Unix(0, time.Now().UnixNano()),
+				expiry:     time.Unix(0, time.Now().Add(time.Minute).UnixNano()),
+				payload:    []byte(`this is foo`),
+			},
+		},
+		{
+			desc: "compressed",
+			in: &envelope{
+				expiry:  time.Unix(0, time.Now().UnixNano()),
+				codec:   CodecSnappy,
+				payload: []byte(`this is foo`),
+			},
+		},
+		{
+			desc: "build duration",
+			in: &envelope{
+				expiry:        time.Unix(0, time.Now().UnixNano()),
+				codec:         CodecGzip,
+				buildDuration: 150 * time.Millisecond,
+				payload:       []byte(`this is foo`),
+			},
+		},
+		{
+			desc: "payload starting with the magic",
+			in: &envelope{
+				payload: append(append([]byte{}, envelopeMagic...), envelopeVersion, 0, 'h', 'i'),
+			},
+		},
+		{
+			desc: "payload starting with the magic and an unknown version",
+			in: &envelope{
+				payload: append(append([]byte{}, envelopeMagic...), 2, 0, 'h', 'i'),
+			},
+		},
+		{
+			desc: "payload that is only the magic",
+			in: &envelope{
+				payload: append([]byte{}, envelopeMagic...),
+			},
+		},
+		{
+			desc: "empty payload",
+			in: &envelope{
+				softExpiry: time.Unix(0, time.Now().UnixNano()),
+				payload:    []byte{},
+			},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		t.Run(scenario.desc, func(t *testing.T) {
+			result, resultErr := decodeEnvelope(scenario.in.encode())
+			assert.Nil(t, resultErr)
+			assert.Equal(t, scenario.in, result)
+		})
+	}
+}
+
+func TestEnvelope_barePayload(t *testing.T) {
+	data := []byte(`{"name": "bob"}`)
+
+	// encoding without metadata leaves the payload untouched
+	entry := &envelope{payload: data}
+	assert.Equal(t, data, entry.encode())
+
+	// data without the header is treated as a payload
+	result, resultErr := decodeEnvelope(data)
+	assert.Nil(t, resultErr)
+	assert.Equal(t, data, result.payload)
+	assert.False(t, result.isStale(time.Now()))
+}
+
+func TestEnvelope_corrupt(t *testing.T) {
+	scenarios := []struct {
+		desc string
+		in   []byte
+	}{
+		{
+			desc: "unknown version",
+			in:   append(append([]byte{}, envelopeMagic...), 99, 0),
+		},
+		{
+			desc: "unknown flags",
+			in:   append(append([]byte{}, envelopeMagic...), envelopeVersion, 0x80),
+		},
+		{
+			desc: "truncated field",
+			in:   append(append([]byte{}, envelopeMagic...), envelopeVersion, envelopeFlagSoftExpiry),
+		},
+		{
+			desc: "missing codec",
+			in:   append(append([]byte{}, envelopeMagic...), envelopeVersion, envelopeFlagCompressed),
+		},
+	}
+
+	for _, scenario := range scenarios {
+		t.Run(scenario.desc, func(t *testing.T) {
+			result, resultErr := decodeEnvelope(scenario.in)
+			assert.Nil(t, result)
+			assert.Equal(t, ErrBadEnvelope, resultErr)
+		})
+	}
+}
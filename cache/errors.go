@@ -9,3 +9,8 @@ type LambdaError struct {
 func (e LambdaError) Error() string {
 	return e.Cause.Error()
 }
+
+// Unwrap returns the cause (this allows errors.Is(err, ErrNotFound) and similar)
+func (e LambdaError) Unwrap() error {
+	return e.Cause
+}
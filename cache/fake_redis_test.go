@@ -0,0 +1,687 @@
+// Copyright 2017 Corey Scott http://www.sage42.org/
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// in-process stand-in for a redis server that supports the commands used by RedisStorage and RueidisStorage.
+//
+// It can also act as a redis cluster node (see fakeRedisCluster), a sentinel (see sentinelMaster) and a replica
+// (see role).  Clients that switch to RESP3 with HELLO can enable CLIENT TRACKING (in OPTIN mode) and are sent
+// invalidation messages when the keys they read are changed.
+type fakeRedis struct {
+	listener net.Listener
+
+	// the cluster this node belongs to (optional)
+	cluster *fakeRedisCluster
+
+	mutex  sync.Mutex
+	values map[string][]byte
+	zsets  map[string]map[string]float64
+	conns  map[net.Conn]struct{}
+
+	// clients tracking each key
+	tracked map[string]map[*fakeRedisClient]struct{}
+
+	// role returned by ROLE; writes are rejected with READONLY when set to "slave" (default "master")
+	role string
+
+	// address of the master returned by SENTINEL get-master-addr-by-name
+	sentinelMaster string
+
+	// number of commands received
+	commands int
+
+	// delay before each reply is sent
+	delay time.Duration
+}
+
+// start a fake redis server on a random local port; it is stopped when the test ends
+func newFakeRedis(t *testing.T) *fakeRedis {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+
+	server := &fakeRedis{
+		listener: listener,
+		values:   map[string][]byte{},
+		zsets:    map[string]map[string]float64{},
+		conns:    map[net.Conn]struct{}{},
+		tracked:  map[string]map[*fakeRedisClient]struct{}{},
+		role:     "master",
+	}
+
+	go server.accept()
+	t.Cleanup(server.close)
+
+	return server
+}
+
+func (f *fakeRedis) addr() string {
+	return f.listener.Addr().String()
+}
+
+func (f *fakeRedis) get(key string) []byte {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return f.values[key]
+}
+
+// add the member to the sorted set (requires f.mutex)
+func (f *fakeRedis) zadd(key string, member string, score float64) {
+	if f.zsets[key] == nil {
+		f.zsets[key] = map[string]float64{}
+	}
+
+	f.zsets[key][member] = score
+}
+
+// return the members of the sorted set ordered by score (requires f.mutex)
+func (f *fakeRedis) zmembers(key string) []string {
+	var out []string
+	for member := range f.zsets[key] {
+		out = append(out, member)
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return f.zsets[key][out[i]] < f.zsets[key][out[j]]
+	})
+
+	return out
+}
+
+// add the member to the sorted set
+func (f *fakeRedis) addMember(key string, member string, score float64) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.zadd(key, member, score)
+}
+
+// return the members of the sorted set ordered by score
+func (f *fakeRedis) members(key string) []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return f.zmembers(key)
+}
+
+func (f *fakeRedis) setRole(role string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.role = role
+}
+
+func (f *fakeRedis) setSentinelMaster(addr string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.sentinelMaster = addr
+}
+
+func (f *fakeRedis) setDelay(delay time.Duration) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.delay = delay
+}
+
+func (f *fakeRedis) commandCount() int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return f.commands
+}
+
+func (f *fakeRedis) close() {
+	_ = f.listener.Close()
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	for con := range f.conns {
+		_ = con.Close()
+	}
+}
+
+func (f *fakeRedis) accept() {
+	for {
+		con, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		f.mutex.Lock()
+		f.conns[con] = struct{}{}
+		f.mutex.Unlock()
+
+		go f.serve(con)
+	}
+}
+
+func (f *fakeRedis) serve(con net.Conn) {
+	defer func() {
+		_ = con.Close()
+
+		f.mutex.Lock()
+		delete(f.conns, con)
+		f.mutex.Unlock()
+	}()
+
+	reader := bufio.NewReader(con)
+	client := &fakeRedisClient{writer: bufio.NewWriter(con)}
+	defer f.untrack(client)
+
+	asking := false
+
+	for {
+		args, err := readFakeRedisCommand(reader)
+		if err != nil {
+			return
+		}
+
+		var reply interface{}
+		switch command := strings.ToUpper(args[0]); {
+		case command == redisAsking:
+			asking = true
+			reply = fakeRedisStatus("OK")
+
+		case command == "MULTI":
+			client.multi = [][]string{}
+			reply = fakeRedisStatus("OK")
+
+		case command == "EXEC":
+			replies := make([]interface{}, len(client.multi))
+			for index, queued := range client.multi {
+				replies[index] = f.handle(client, queued, false)
+			}
+			client.multi = nil
+			client.caching = false
+			reply = replies
+
+		case client.multi != nil:
+			client.multi = append(client.multi, args)
+			reply = fakeRedisStatus("QUEUED")
+
+		default:
+			reply = f.handle(client, args, asking)
+			asking = false
+		}
+
+		f.mutex.Lock()
+		delay := f.delay
+		f.mutex.Unlock()
+
+		<-time.After(delay)
+
+		if client.write(reply, reader.Buffered() == 0) != nil {
+			return
+		}
+	}
+}
+
+// process a command and return the reply
+func (f *fakeRedis) handle(client *fakeRedisClient, args []string, asking bool) interface{} {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.commands++
+	command := strings.ToUpper(args[0])
+
+	// CLIENT CACHING YES only applies to the next command (or transaction)
+	caching := client.caching
+	if client.multi == nil {
+		client.caching = false
+	}
+
+	if f.cluster != nil {
+		if redirect := f.cluster.redirect(f, command, args[1:], asking); redirect != nil {
+			return redirect
+		}
+	}
+
+	if f.role != redisRoleMaster {
+		switch command {
+		case redisSet, redisSetex, redisExpire, redisDel, redisZadd, redisZrem, redisZremrangebyscore:
+			return fakeRedisError("READONLY You can't write against a read only replica.")
+		}
+	}
+
+	switch command {
+	case "PING":
+		return fakeRedisStatus("PONG")
+
+	case "HELLO":
+		if len(args) < 2 || args[1] != "3" {
+			return fakeRedisError("NOPROTO unsupported protocol version")
+		}
+		client.resp3 = true
+		return fakeRedisMap{"server", "redis", "version", "6.2.0", "proto", int64(3)}
+
+	case "CLIENT":
+		switch strings.ToUpper(args[1]) {
+		case "TRACKING":
+			if !client.resp3 {
+				return fakeRedisError("ERR tracking requires RESP3")
+			}
+			client.tracking = strings.ToUpper(args[2]) == "ON"
+			return fakeRedisStatus("OK")
+
+		case "CACHING":
+			client.caching = true
+			return fakeRedisStatus("OK")
+
+		case "SETINFO":
+			return fakeRedisStatus("OK")
+		}
+
+	case redisRole:
+		return []interface{}{f.role}
+
+	case redisGet:
+		f.track(client, caching, args[1])
+		return f.values[args[1]]
+
+	case redisMget:
+		out := make([]interface{}, len(args)-1)
+		for index, key := range args[1:] {
+			f.track(client, caching, key)
+			if value, found := f.values[key]; found {
+				out[index] = value
+			}
+		}
+		return out
+
+	case "PTTL":
+		// TTLs are not supported
+		if _, found := f.values[args[1]]; found {
+			return int64(-1)
+		}
+		return int64(-2)
+
+	case redisSet:
+		for _, option := range args[3:] {
+			if _, found := f.values[args[1]]; found && strings.ToUpper(option) == "NX" {
+				return nil
+			}
+		}
+
+		f.values[args[1]] = []byte(args[2])
+		f.invalidate(args[1])
+		return fakeRedisStatus("OK")
+
+	case redisSetex:
+		f.values[args[1]] = []byte(args[3])
+		f.invalidate(args[1])
+		return fakeRedisStatus("OK")
+
+	case redisExpire:
+		// TTLs are not supported; only immediate expiry
+		if args[2] == "0" {
+			delete(f.values, args[1])
+			delete(f.zsets, args[1])
+			f.invalidate(args[1])
+		}
+		return int64(1)
+
+	case redisDel:
+		for _, key := range args[1:] {
+			delete(f.values, key)
+			delete(f.zsets, key)
+			f.invalidate(key)
+		}
+		return int64(len(args) - 1)
+
+	case redisZadd:
+		for index := 2; index+1 < len(args); index += 2 {
+			score, _ := strconv.ParseFloat(args[index], 64)
+			f.zadd(args[1], args[index+1], score)
+		}
+		return int64((len(args) - 2) / 2)
+
+	case redisZrem:
+		for _, member := range args[2:] {
+			delete(f.zsets[args[1]], member)
+		}
+		return int64(len(args) - 2)
+
+	case redisZremrangebyscore:
+		// only "-inf" and exclusive ("(") max scores are supported
+		max, _ := strconv.ParseFloat(strings.TrimPrefix(args[3], "("), 64)
+
+		removed := 0
+		for member, score := range f.zsets[args[1]] {
+			if score < max {
+				delete(f.zsets[args[1]], member)
+				removed++
+			}
+		}
+		return int64(removed)
+
+	case redisZrange:
+		// only the full range (0 -1) is supported
+		var out []interface{}
+		for _, member := range f.zmembers(args[1]) {
+			out = append(out, []byte(member))
+		}
+		return out
+
+	case redisClusterCommand:
+		if f.cluster != nil && strings.ToUpper(args[1]) == redisClusterSlotsArg {
+			return f.cluster.slots()
+		}
+
+	case redisSentinelCommand:
+		if f.sentinelMaster != "" && args[1] == redisSentinelMasterArg {
+			host, port, _ := net.SplitHostPort(f.sentinelMaster)
+			return []interface{}{[]byte(host), []byte(port)}
+		}
+		return nil
+	}
+
+	return fakeRedisError("ERR unknown command '" + args[0] + "'")
+}
+
+// track the key for the client when it has enabled CLIENT TRACKING and requested caching (requires f.mutex)
+func (f *fakeRedis) track(client *fakeRedisClient, caching bool, key string) {
+	if !client.tracking || !caching {
+		return
+	}
+
+	if f.tracked[key] == nil {
+		f.tracked[key] = map[*fakeRedisClient]struct{}{}
+	}
+	f.tracked[key][client] = struct{}{}
+}
+
+// send an invalidation message to the clients tracking the key (requires f.mutex)
+func (f *fakeRedis) invalidate(key string) {
+	for client := range f.tracked[key] {
+		_ = client.write(fakeRedisPush{[]byte("invalidate"), []interface{}{[]byte(key)}}, true)
+	}
+
+	delete(f.tracked, key)
+}
+
+// stop tracking keys for the client
+func (f *fakeRedis) untrack(client *fakeRedisClient) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	for _, clients := range f.tracked {
+		delete(clients, client)
+	}
+}
+
+// the state of a connection to the fake server
+type fakeRedisClient struct {
+	// guards the writer; invalidation messages are written by other connections
+	mutex  sync.Mutex
+	writer *bufio.Writer
+
+	// the following are only used by the connection's goroutine (or while holding fakeRedis.mutex)
+	resp3    bool
+	tracking bool
+	caching  bool
+
+	// commands queued after MULTI (nil when not in a transaction)
+	multi [][]string
+}
+
+// write the reply; flushing when requested
+func (c *fakeRedisClient) write(reply interface{}, flush bool) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	writeFakeRedisReply(c.writer, reply)
+	if !flush {
+		return nil
+	}
+
+	return c.writer.Flush()
+}
+
+// fake redis cluster; the slots are assigned to the nodes and can be moved or migrated during the test
+type fakeRedisCluster struct {
+	nodes []*fakeRedis
+
+	mutex sync.Mutex
+	owner [redisClusterSlots]*fakeRedis
+
+	// keys that have been migrated to another node (and are served by it after ASKING)
+	migrated map[string]*fakeRedis
+}
+
+// start a cluster of nodes with the slots split evenly between them
+func newFakeRedisCluster(t *testing.T, nodes int) *fakeRedisCluster {
+	cluster := &fakeRedisCluster{
+		migrated: map[string]*fakeRedis{},
+	}
+
+	for x := 0; x < nodes; x++ {
+		node := newFakeRedis(t)
+		node.cluster = cluster
+		cluster.nodes = append(cluster.nodes, node)
+	}
+
+	for slot := 0; slot < redisClusterSlots; slot++ {
+		cluster.owner[slot] = cluster.nodes[slot*nodes/redisClusterSlots]
+	}
+
+	return cluster
+}
+
+// return the node that owns the slot of the key
+func (c *fakeRedisCluster) ownerOf(key string) *fakeRedis {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.owner[redisSlot(key)]
+}
+
+// move the slot of the key to the node (nodes reply with MOVED)
+func (c *fakeRedisCluster) move(key string, node *fakeRedis) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.owner[redisSlot(key)] = node
+}
+
+// migrate the key to the node without moving the slot (nodes reply with ASK)
+func (c *fakeRedisCluster) migrate(key string, node *fakeRedis) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.migrated[key] = node
+}
+
+// return the MOVED/ASK/CROSSSLOT error when the node cannot process the command
+func (c *fakeRedisCluster) redirect(node *fakeRedis, command string, args []string, asking bool) interface{} {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if len(args) == 0 || command == redisClusterCommand {
+		return nil
+	}
+
+	keys := args[:1]
+	switch command {
+	case redisMget, redisDel:
+		keys = args
+	}
+
+	slot := redisSlot(keys[0])
+	for _, key := range keys[1:] {
+		if redisSlot(key) != slot {
+			return fakeRedisError("CROSSSLOT Keys in request don't hash to the same slot")
+		}
+	}
+
+	if target, found := c.migrated[keys[0]]; found {
+		if target == node && asking {
+			return nil
+		}
+
+		if target != node {
+			return fakeRedisError(fmt.Sprintf("ASK %d %s", slot, target.addr()))
+		}
+	}
+
+	if owner := c.owner[slot]; owner != node {
+		return fakeRedisError(fmt.Sprintf("MOVED %d %s", slot, owner.addr()))
+	}
+
+	return nil
+}
+
+// return the CLUSTER SLOTS reply
+func (c *fakeRedisCluster) slots() interface{} {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var out []interface{}
+	start := 0
+	for slot := 1; slot <= redisClusterSlots; slot++ {
+		if slot < redisClusterSlots && c.owner[slot] == c.owner[start] {
+			continue
+		}
+
+		host, port, _ := net.SplitHostPort(c.owner[start].addr())
+		portNumber, _ := strconv.Atoi(port)
+
+		out = append(out, []interface{}{
+			int64(start),
+			int64(slot - 1),
+			[]interface{}{[]byte(host), int64(portNumber), []byte("node-id")},
+		})
+		start = slot
+	}
+
+	return out
+}
+
+type fakeRedisStatus string
+
+type fakeRedisError string
+
+// RESP3 map written as key, value, key, value...
+type fakeRedisMap []interface{}
+
+// RESP3 push message (e.g. invalidate)
+type fakeRedisPush []interface{}
+
+// read a command sent as a RESP array of bulk strings
+func readFakeRedisCommand(reader *bufio.Reader) ([]string, error) {
+	count, err := readFakeRedisLength(reader, '*')
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([]string, count)
+	for index := range args {
+		size, err := readFakeRedisLength(reader, '$')
+		if err != nil {
+			return nil, err
+		}
+
+		buffer := make([]byte, size+2)
+		_, err = io.ReadFull(reader, buffer)
+		if err != nil {
+			return nil, err
+		}
+
+		args[index] = string(buffer[:size])
+	}
+
+	if count == 0 {
+		return nil, fmt.Errorf("empty command")
+	}
+
+	return args, nil
+}
+
+func readFakeRedisLength(reader *bufio.Reader, prefix byte) (int, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return 0, err
+	}
+
+	if len(line) < 3 || line[0] != prefix {
+		return 0, fmt.Errorf("unexpected line: %q", line)
+	}
+
+	return strconv.Atoi(strings.TrimSpace(line[1:]))
+}
+
+// write the reply using RESP
+func writeFakeRedisReply(writer *bufio.Writer, reply interface{}) {
+	switch typed := reply.(type) {
+	case nil:
+		_, _ = writer.WriteString("$-1\r\n")
+
+	case fakeRedisStatus:
+		_, _ = writer.WriteString("+" + string(typed) + "\r\n")
+
+	case fakeRedisError:
+		_, _ = writer.WriteString("-" + string(typed) + "\r\n")
+
+	case int64:
+		_, _ = writer.WriteString(":" + strconv.FormatInt(typed, 10) + "\r\n")
+
+	case string:
+		_, _ = writer.WriteString("+" + typed + "\r\n")
+
+	case []byte:
+		if typed == nil {
+			_, _ = writer.WriteString("$-1\r\n")
+			return
+		}
+		_, _ = writer.WriteString("$" + strconv.Itoa(len(typed)) + "\r\n")
+		_, _ = writer.Write(typed)
+		_, _ = writer.WriteString("\r\n")
+
+	case []interface{}:
+		_, _ = writer.WriteString("*" + strconv.Itoa(len(typed)) + "\r\n")
+		for _, item := range typed {
+			writeFakeRedisReply(writer, item)
+		}
+
+	case fakeRedisMap:
+		_, _ = writer.WriteString("%" + strconv.Itoa(len(typed)/2) + "\r\n")
+		for _, item := range typed {
+			writeFakeRedisReply(writer, item)
+		}
+
+	case fakeRedisPush:
+		_, _ = writer.WriteString(">" + strconv.Itoa(len(typed)) + "\r\n")
+		for _, item := range typed {
+			writeFakeRedisReply(writer, item)
+		}
+	}
+}
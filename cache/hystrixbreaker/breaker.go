@@ -0,0 +1,38 @@
+// Copyright 2017 Corey Scott http://www.sage42.org/
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package hystrixbreaker implements cache.CircuitBreaker using hystrix-go
+package hystrixbreaker
+
+import (
+	"context"
+
+	"github.com/afex/hystrix-go/hystrix"
+)
+
+// Breaker implements cache.CircuitBreaker by running each call as a hystrix command.
+//
+// Storages that use the same Name share a circuit (and the settings from `hystrix.ConfigureCommand()`); use a different
+// Name per storage instance for independent circuits.
+type Breaker struct {
+	// Name is the hystrix command name (required; e.g. cache.CbRedisStorage)
+	Name string
+}
+
+// Do implements cache.CircuitBreaker
+func (b *Breaker) Do(ctx context.Context, fn func() error) error {
+	return hystrix.DoC(ctx, b.Name, func(_ context.Context) error {
+		return fn()
+	}, nil)
+}
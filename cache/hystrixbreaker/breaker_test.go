@@ -0,0 +1,78 @@
+// Copyright 2017 Corey Scott http://www.sage42.org/
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package hystrixbreaker
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/afex/hystrix-go/hystrix"
+	"github.com/corsc/go-commons/cache"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBreaker_implements(t *testing.T) {
+	assert.Implements(t, (*cache.CircuitBreaker)(nil), &Breaker{})
+}
+
+func TestBreaker_Do(t *testing.T) {
+	breaker := &Breaker{Name: "TestBreaker_Do"}
+
+	// success
+	called := false
+	resultErr := breaker.Do(context.Background(), func() error {
+		called = true
+		return nil
+	})
+	assert.Nil(t, resultErr)
+	assert.True(t, called)
+
+	// failure
+	fnErr := errors.New("something failed")
+	resultErr = breaker.Do(context.Background(), func() error {
+		return fnErr
+	})
+	assert.Equal(t, fnErr, resultErr)
+}
+
+func TestBreaker_Do_open(t *testing.T) {
+	name := "TestBreaker_Do_open"
+	hystrix.ConfigureCommand(name, hystrix.CommandConfig{
+		RequestVolumeThreshold: 1,
+		ErrorPercentThreshold:  1,
+	})
+	defer hystrix.Flush()
+
+	breaker := &Breaker{Name: name}
+
+	// open the circuit
+	for x := 0; x < 5; x++ {
+		_ = breaker.Do(context.Background(), func() error {
+			return errors.New("something failed")
+		})
+	}
+
+	// make the call
+	called := false
+	resultErr := breaker.Do(context.Background(), func() error {
+		called = true
+		return nil
+	})
+
+	// validate
+	assert.Equal(t, hystrix.ErrCircuitOpen, resultErr)
+	assert.False(t, called)
+}
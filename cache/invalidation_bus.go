@@ -0,0 +1,394 @@
+// Copyright 2017 Corey Scott http://www.sage42.org/
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+const (
+	defaultInvalidationChannel        = "cache.invalidations"
+	defaultInvalidationReconnectDelay = 1 * time.Second
+	defaultInvalidationMaxDelay       = 1 * time.Second
+
+	// how often the subscription connection is checked
+	invalidationHealthCheckInterval = 30 * time.Second
+)
+
+// InvalidationPublisher broadcasts invalidations to the other instances (e.g. RedisInvalidationBus)
+type InvalidationPublisher interface {
+	// Publish broadcasts the invalidation of the supplied keys
+	Publish(ctx context.Context, keys ...string) error
+
+	// PublishTags broadcasts the invalidation of the supplied tags
+	PublishTags(ctx context.Context, tags ...string) error
+}
+
+// optional extension of Storage for storages that can remove all their items at once (e.g. MemoryStorage)
+type purger interface {
+	Purge()
+}
+
+// RedisInvalidationBus implements InvalidationPublisher using redis pub/sub and removes the keys invalidated by
+// other instances from the local storage.
+//
+// Typically this is used with TieredStorage; set the bus as the TieredStorage.Bus and the L1 storage as the bus's
+// Local storage and then call Start.
+//
+// When messages may have been missed (i.e. after reconnecting to redis or when a gap in a sender's messages is
+// detected), the local storage is purged (when it supports purging, e.g. MemoryStorage) and a
+// CacheInvalidationMissed event is tracked.  Messages received more than MaxDelay after they were sent are tracked as
+// CacheInvalidationLate events.
+type RedisInvalidationBus struct {
+	// Pool is the redis connection pool; typically the same as RedisStorage.Pool (required)
+	Pool *redis.Pool
+
+	// Local is the storage from which keys invalidated by other instances are removed (required)
+	Local Storage
+
+	// Channel is the redis pub/sub channel (optional - default "cache.invalidations")
+	Channel string
+
+	// Logger defines a logger to used for errors (optional)
+	Logger Logger
+
+	// Metrics allow for tracking missed and late messages (optional)
+	Metrics Metrics
+
+	// ReconnectDelay is the time waited between attempts to subscribe (optional - default 1 second)
+	ReconnectDelay time.Duration
+
+	// MaxDelay is the max expected time between publishing and receiving a message (optional - default 1 second)
+	MaxDelay time.Duration
+
+	initOnce sync.Once
+
+	// unique ID of this instance and sequence number of the last message published
+	id       string
+	sequence uint64
+
+	// the last sequence number received from each of the other instances
+	mutex     sync.Mutex
+	sequences map[string]uint64
+
+	started  int32
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// the published message
+type invalidationMessage struct {
+	Sender   string   `json:"s"`
+	Sequence uint64   `json:"n"`
+	SentAt   int64    `json:"t"`
+	Keys     []string `json:"k,omitempty"`
+	Tags     []string `json:"g,omitempty"`
+}
+
+// Publish implements InvalidationPublisher
+func (b *RedisInvalidationBus) Publish(ctx context.Context, keys ...string) error {
+	return b.publish(ctx, &invalidationMessage{Keys: keys})
+}
+
+// PublishTags implements InvalidationPublisher
+func (b *RedisInvalidationBus) PublishTags(ctx context.Context, tags ...string) error {
+	return b.publish(ctx, &invalidationMessage{Tags: tags})
+}
+
+// Start subscribes to the channel (in the background) and will keep reconnecting until Close is called
+func (b *RedisInvalidationBus) Start() {
+	b.initOnce.Do(b.init)
+
+	if atomic.CompareAndSwapInt32(&b.started, 0, 1) {
+		go b.run()
+	}
+}
+
+// Close stops the subscription and waits for it to finish or for the context to be done
+func (b *RedisInvalidationBus) Close(ctx context.Context) error {
+	b.initOnce.Do(b.init)
+	b.stopOnce.Do(func() {
+		close(b.stop)
+	})
+
+	if atomic.LoadInt32(&b.started) == 0 {
+		return nil
+	}
+
+	select {
+	case <-b.done:
+		return nil
+
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (b *RedisInvalidationBus) init() {
+	id := make([]byte, 8)
+	_, _ = rand.Read(id)
+
+	b.id = hex.EncodeToString(id)
+	b.sequences = map[string]uint64{}
+	b.stop = make(chan struct{})
+	b.done = make(chan struct{})
+}
+
+// publish the message to the other instances
+func (b *RedisInvalidationBus) publish(ctx context.Context, msg *invalidationMessage) error {
+	b.initOnce.Do(b.init)
+
+	msg.Sender = b.id
+	msg.Sequence = atomic.AddUint64(&b.sequence, 1)
+	msg.SentAt = time.Now().UnixNano()
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	con, err := b.Pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = con.Close()
+	}()
+
+	var timeout time.Duration
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
+
+	_, err = redis.DoWithTimeout(con, timeout, redisPublish, b.getChannel(), data)
+	return err
+}
+
+// subscribe until stopped; reconnecting after any errors
+func (b *RedisInvalidationBus) run() {
+	defer close(b.done)
+
+	subscribed := false
+	for {
+		err := b.subscribe(&subscribed)
+		if b.isStopped() {
+			return
+		}
+
+		logFields(b.getLogger(), LevelError, "cache invalidation subscription error", Field{FieldChannel, b.getChannel()}, Field{FieldError, err})
+
+		select {
+		case <-b.stop:
+			return
+
+		case <-time.After(b.getReconnectDelay()):
+			// try again
+		}
+	}
+}
+
+// subscribe and process messages until stopped or the connection fails
+func (b *RedisInvalidationBus) subscribe(subscribed *bool) error {
+	psc := redis.PubSubConn{Conn: b.Pool.Get()}
+
+	err := psc.Subscribe(b.getChannel())
+	if err != nil {
+		_ = psc.Close()
+		return err
+	}
+
+	// unsubscribe when stopped and check the health of the connection; this is the only goroutine that sends
+	finished := make(chan struct{})
+	senderDone := make(chan struct{})
+	defer func() {
+		close(finished)
+		<-senderDone
+
+		_ = psc.Close()
+	}()
+
+	go func() {
+		defer close(senderDone)
+
+		ticker := time.NewTicker(invalidationHealthCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-b.stop:
+				_ = psc.Unsubscribe()
+				return
+
+			case <-ticker.C:
+				if psc.Ping("") != nil {
+					return
+				}
+
+			case <-finished:
+				return
+			}
+		}
+	}()
+
+	for {
+		switch msg := psc.ReceiveWithTimeout(2 * invalidationHealthCheckInterval).(type) {
+		case redis.Message:
+			b.handle(msg.Data)
+
+		case redis.Subscription:
+			if msg.Count == 0 {
+				// unsubscribed
+				return nil
+			}
+
+			if *subscribed {
+				// messages may have been sent while we were disconnected
+				b.onMissed()
+			}
+			*subscribed = true
+
+		case error:
+			return msg
+		}
+	}
+}
+
+// process a received message
+func (b *RedisInvalidationBus) handle(data []byte) {
+	msg := &invalidationMessage{}
+	err := json.Unmarshal(data, msg)
+	if err != nil {
+		logFields(b.getLogger(), LevelError, "cache invalidation message error", Field{FieldError, err})
+		return
+	}
+
+	if msg.Sender == b.id {
+		// the local storage was updated when the message was sent
+		return
+	}
+
+	if time.Since(time.Unix(0, msg.SentAt)) > b.getMaxDelay() {
+		b.getMetrics().Track(CacheInvalidationLate)
+	}
+
+	if b.isGap(msg) {
+		b.onMissed()
+	}
+
+	ctx := context.Background()
+	for _, key := range msg.Keys {
+		err = b.Local.Invalidate(ctx, key)
+		if err != nil {
+			logFields(b.getLogger(), LevelError, "cache invalidation error", Field{FieldKey, key}, Field{FieldError, err})
+		}
+	}
+
+	for _, tag := range msg.Tags {
+		err = invalidateTag(ctx, b.Local, tag)
+		if err != nil {
+			logFields(b.getLogger(), LevelError, "cache invalidation error", Field{FieldTag, tag}, Field{FieldError, err})
+		}
+	}
+}
+
+// returns true when messages from the sender have been missed
+func (b *RedisInvalidationBus) isGap(msg *invalidationMessage) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	previous, found := b.sequences[msg.Sender]
+	if msg.Sequence > previous {
+		b.sequences[msg.Sender] = msg.Sequence
+	}
+
+	return found && msg.Sequence > previous+1
+}
+
+// remove everything from the local storage as it may hold invalidated values
+func (b *RedisInvalidationBus) onMissed() {
+	b.getMetrics().Track(CacheInvalidationMissed)
+
+	if local, ok := b.Local.(purger); ok {
+		local.Purge()
+		return
+	}
+
+	logFields(b.getLogger(), LevelWarn, "cache invalidation messages missed and local storage does not support purging")
+}
+
+// returns true once Close has been called
+func (b *RedisInvalidationBus) isStopped() bool {
+	select {
+	case <-b.stop:
+		return true
+
+	default:
+		return false
+	}
+}
+
+// return the pub/sub channel
+func (b *RedisInvalidationBus) getChannel() string {
+	if b.Channel != "" {
+		return b.Channel
+	}
+
+	return defaultInvalidationChannel
+}
+
+// return the delay between subscription attempts
+func (b *RedisInvalidationBus) getReconnectDelay() time.Duration {
+	if b.ReconnectDelay > 0 {
+		return b.ReconnectDelay
+	}
+
+	return defaultInvalidationReconnectDelay
+}
+
+// return the max expected message delay
+func (b *RedisInvalidationBus) getMaxDelay() time.Duration {
+	if b.MaxDelay > 0 {
+		return b.MaxDelay
+	}
+
+	return defaultInvalidationMaxDelay
+}
+
+// return the supplied logger or a no-op implementation
+func (b *RedisInvalidationBus) getLogger() Logger {
+	if b.Logger != nil {
+		return b.Logger
+	}
+
+	return noopLogger
+}
+
+// return the supplied metric tracker or a no-op implementation
+func (b *RedisInvalidationBus) getMetrics() Metrics {
+	if b.Metrics != nil {
+		return b.Metrics
+	}
+
+	return noopMetrics
+}
@@ -0,0 +1,200 @@
+// Copyright 2017 Corey Scott http://www.sage42.org/
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/corsc/go-commons/testing/skip"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedisInvalidationBus_implements(t *testing.T) {
+	assert.Implements(t, (*InvalidationPublisher)(nil), &RedisInvalidationBus{})
+}
+
+func TestRedisInvalidationBus_handle(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+
+	local := &MemoryStorage{}
+	assert.Nil(t, local.Set(ctx, "a", []byte(`A`)))
+	assert.Nil(t, local.Set(ctx, "b", []byte(`B`)))
+	assert.Nil(t, local.Tag(ctx, "b", []string{"user:1"}, 0))
+	assert.Nil(t, local.Set(ctx, "c", []byte(`C`)))
+
+	bus := &RedisInvalidationBus{
+		Local: local,
+	}
+	bus.initOnce.Do(bus.init)
+
+	// make the calls
+	bus.handle(testInvalidationMessage(t, &invalidationMessage{Sender: "other", Sequence: 1, Keys: []string{"a"}}))
+	bus.handle(testInvalidationMessage(t, &invalidationMessage{Sender: "other", Sequence: 2, Tags: []string{"user:1"}}))
+
+	// messages from this instance are ignored
+	bus.handle(testInvalidationMessage(t, &invalidationMessage{Sender: bus.id, Sequence: 1, Keys: []string{"c"}}))
+
+	// validate
+	_, resultErr := local.Get(ctx, "a")
+	assert.Equal(t, ErrCacheMiss, resultErr)
+
+	_, resultErr = local.Get(ctx, "b")
+	assert.Equal(t, ErrCacheMiss, resultErr)
+
+	_, resultErr = local.Get(ctx, "c")
+	assert.Nil(t, resultErr)
+}
+
+func TestRedisInvalidationBus_missedAndLate(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+
+	local := &MemoryStorage{}
+
+	metrics := &MockMetrics{}
+	metrics.On("Track", CacheInvalidationMissed).Once()
+	metrics.On("Track", CacheInvalidationLate).Once()
+
+	bus := &RedisInvalidationBus{
+		Local:   local,
+		Metrics: metrics,
+	}
+	bus.initOnce.Do(bus.init)
+
+	// first message from a sender
+	bus.handle(testInvalidationMessage(t, &invalidationMessage{Sender: "other", Sequence: 5, Keys: []string{"a"}}))
+
+	// late message
+	bus.handle(testInvalidationMessage(t, &invalidationMessage{
+		Sender:   "other",
+		Sequence: 6,
+		SentAt:   time.Now().Add(-1 * time.Minute).UnixNano(),
+		Keys:     []string{"a"},
+	}))
+
+	// gap in the sequence; the local storage should be purged
+	assert.Nil(t, local.Set(ctx, "b", []byte(`B`)))
+	bus.handle(testInvalidationMessage(t, &invalidationMessage{Sender: "other", Sequence: 8, Keys: []string{"a"}}))
+
+	assert.Equal(t, 0, local.Len())
+
+	// out of order messages are not gaps
+	bus.handle(testInvalidationMessage(t, &invalidationMessage{Sender: "other", Sequence: 7, Keys: []string{"a"}}))
+
+	assert.True(t, metrics.AssertExpectations(t))
+}
+
+func TestTieredStorage_Bus(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+
+	bus := &testInvalidationPublisher{}
+	storage := &TieredStorage{
+		L1:  &MemoryStorage{},
+		L2:  &MemoryStorage{},
+		Bus: bus,
+	}
+
+	// make the calls
+	assert.Nil(t, storage.Invalidate(ctx, "a"))
+	assert.Nil(t, storage.InvalidateTag(ctx, "user:1"))
+
+	// validate
+	assert.Equal(t, []string{"a"}, bus.keys)
+	assert.Equal(t, []string{"user:1"}, bus.tags)
+}
+
+func TestRedisInvalidationBus_redis(t *testing.T) {
+	skip.IfNotSet(t, RedisTestFlag)
+
+	// inputs
+	ctx, cancelFn := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelFn()
+	key := getTestKey()
+	channel := getTestKey() + ".channel"
+
+	remote := getTestRedisStorage()
+
+	// build 2 instances
+	var instances []*TieredStorage
+	for x := 0; x < 2; x++ {
+		local := &MemoryStorage{}
+		bus := &RedisInvalidationBus{
+			Pool:    remote.Pool,
+			Local:   local,
+			Channel: channel,
+		}
+		bus.Start()
+		defer func() {
+			assert.Nil(t, bus.Close(ctx))
+		}()
+
+		instances = append(instances, &TieredStorage{
+			L1:  local,
+			L2:  remote,
+			Bus: bus,
+		})
+	}
+
+	// allow the subscriptions to start
+	<-time.After(100 * time.Millisecond)
+
+	// populate both L1s
+	assert.Nil(t, instances[0].Set(ctx, key, []byte(`this is foo`)))
+	_, resultErr := instances[1].Get(ctx, key)
+	assert.Nil(t, resultErr)
+
+	// invalidate on 1 instance
+	assert.Nil(t, instances[0].Invalidate(ctx, key))
+
+	// validate the other instance's L1 was cleared
+	<-time.After(100 * time.Millisecond)
+
+	_, resultErr = instances[1].L1.Get(ctx, key)
+	assert.Equal(t, ErrCacheMiss, resultErr)
+}
+
+func testInvalidationMessage(t *testing.T, msg *invalidationMessage) []byte {
+	if msg.SentAt == 0 {
+		msg.SentAt = time.Now().UnixNano()
+	}
+
+	data, err := json.Marshal(msg)
+	assert.Nil(t, err)
+
+	return data
+}
+
+type testInvalidationPublisher struct {
+	keys []string
+	tags []string
+}
+
+func (t *testInvalidationPublisher) Publish(_ context.Context, keys ...string) error {
+	t.keys = append(t.keys, keys...)
+	return nil
+}
+
+func (t *testInvalidationPublisher) PublishTags(_ context.Context, tags ...string) error {
+	t.tags = append(t.tags, tags...)
+	return nil
+}
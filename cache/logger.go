@@ -14,6 +14,10 @@
 
 package cache
 
+import (
+	"strings"
+)
+
 // Logger allows for logging errors in the asynchronous calls
 type Logger interface {
 	// Build returns the data for the supplied key by populating dest
@@ -32,3 +36,112 @@ func (l LoggerFunc) Log(message string, args ...interface{}) {
 var noopLogger = LoggerFunc(func(message string, args ...interface{}) {
 	// intentionally do nothing
 })
+
+// Level is the severity of a log entry
+type Level int
+
+const (
+	// LevelDebug is for diagnostic entries
+	LevelDebug Level = iota
+
+	// LevelInfo is for informational entries
+	LevelInfo
+
+	// LevelWarn is for problems that were handled (e.g. a write that was skipped)
+	LevelWarn
+
+	// LevelError is for failed operations
+	LevelError
+)
+
+// String implements fmt.Stringer
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+
+	case LevelInfo:
+		return "INFO"
+
+	case LevelWarn:
+		return "WARN"
+
+	default:
+		return "ERROR"
+	}
+}
+
+// Keys of the fields included in log entries
+const (
+	// FieldKey is the cache key
+	FieldKey = "key"
+
+	// FieldOp is the operation being performed (e.g. OpGet)
+	FieldOp = "op"
+
+	// FieldStorage is the name of the storage (e.g. "redis")
+	FieldStorage = "storage"
+
+	// FieldError is the error
+	FieldError = "err"
+
+	// FieldKeys is the number of keys in a batch operation
+	FieldKeys = "keys"
+
+	// FieldTag is the tag
+	FieldTag = "tag"
+
+	// FieldNamespace is the client's namespace
+	FieldNamespace = "namespace"
+
+	// FieldChannel is the pub/sub channel
+	FieldChannel = "channel"
+)
+
+// Field is a key/value pair attached to a log entry
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// StructuredLogger is an optional extension of Logger for loggers that support levels and fields (e.g. SlogLogger).
+//
+// When a Logger does not implement this interface, the level is dropped and the fields are appended to the message.
+type StructuredLogger interface {
+	Logger
+
+	// LogFields logs the message with the supplied level and fields
+	LogFields(level Level, message string, fields ...Field)
+}
+
+// log the message to the logger; the fields are formatted into the message for loggers that are not structured
+func logFields(logger Logger, level Level, message string, fields ...Field) {
+	if structured, ok := logger.(StructuredLogger); ok {
+		structured.LogFields(level, message, fields...)
+		return
+	}
+
+	format := &strings.Builder{}
+	format.WriteString(message)
+
+	args := make([]interface{}, 0, len(fields))
+	for index, field := range fields {
+		if index == 0 {
+			format.WriteString(".")
+		}
+
+		format.WriteString(" ")
+		format.WriteString(field.Key)
+		format.WriteString(": ")
+
+		if _, isString := field.Value.(string); isString {
+			format.WriteString("'%s'")
+		} else {
+			format.WriteString("%v")
+		}
+
+		args = append(args, field.Value)
+	}
+
+	logger.Log(format.String(), args...)
+}
@@ -0,0 +1,123 @@
+// Copyright 2017 Corey Scott http://www.sage42.org/
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"strings"
+)
+
+// SlogLogger implements StructuredLogger using log/slog
+type SlogLogger struct {
+	// Logger is the slog logger (optional - default slog.Default())
+	Logger *slog.Logger
+}
+
+// Log implements Logger; the message is logged at error level
+func (s *SlogLogger) Log(message string, args ...interface{}) {
+	s.getLogger().Error(fmt.Sprintf(message, args...))
+}
+
+// LogFields implements StructuredLogger
+func (s *SlogLogger) LogFields(level Level, message string, fields ...Field) {
+	attrs := make([]slog.Attr, 0, len(fields))
+	for _, field := range fields {
+		attrs = append(attrs, slog.Any(field.Key, field.Value))
+	}
+
+	s.getLogger().LogAttrs(context.Background(), slogLevel(level), message, attrs...)
+}
+
+// return the supplied logger or the default
+func (s *SlogLogger) getLogger() *slog.Logger {
+	if s.Logger != nil {
+		return s.Logger
+	}
+
+	return slog.Default()
+}
+
+// convert the level to the equivalent slog level
+func slogLevel(level Level) slog.Level {
+	switch level {
+	case LevelDebug:
+		return slog.LevelDebug
+
+	case LevelInfo:
+		return slog.LevelInfo
+
+	case LevelWarn:
+		return slog.LevelWarn
+
+	default:
+		return slog.LevelError
+	}
+}
+
+// StdLogger implements StructuredLogger using the standard log package.
+//
+// Entries are written as the level, the message and then the fields as key=value pairs
+// (e.g. `ERROR cache get error key="foo" err="timeout" op="get" storage="redis"`).
+type StdLogger struct {
+	// Logger is the standard logger (optional - default log.Default())
+	Logger *log.Logger
+
+	// MinLevel is the lowest level that is logged (optional - default LevelDebug)
+	MinLevel Level
+}
+
+// Log implements Logger
+func (s *StdLogger) Log(message string, args ...interface{}) {
+	s.getLogger().Printf(message, args...)
+}
+
+// LogFields implements StructuredLogger
+func (s *StdLogger) LogFields(level Level, message string, fields ...Field) {
+	if level < s.MinLevel {
+		return
+	}
+
+	out := &strings.Builder{}
+	out.WriteString(level.String())
+	out.WriteString(" ")
+	out.WriteString(message)
+
+	for _, field := range fields {
+		switch value := field.Value.(type) {
+		case string:
+			fmt.Fprintf(out, " %s=%q", field.Key, value)
+
+		case error:
+			fmt.Fprintf(out, " %s=%q", field.Key, value.Error())
+
+		default:
+			fmt.Fprintf(out, " %s=%v", field.Key, value)
+		}
+	}
+
+	s.getLogger().Print(out.String())
+}
+
+// return the supplied logger or the default
+func (s *StdLogger) getLogger() *log.Logger {
+	if s.Logger != nil {
+		return s.Logger
+	}
+
+	return log.Default()
+}
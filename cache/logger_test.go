@@ -0,0 +1,132 @@
+// Copyright 2017 Corey Scott http://www.sage42.org/
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestLogFields_loggerFunc(t *testing.T) {
+	var result string
+	logger := LoggerFunc(func(message string, args ...interface{}) {
+		result = fmt.Sprintf(message, args...)
+	})
+
+	// make the calls
+	logFields(logger, LevelError, "cache get error", Field{FieldKey, "foo"}, Field{FieldError, errors.New("boom")},
+		Field{FieldKeys, 3})
+
+	// validate
+	assert.Equal(t, "cache get error. key: 'foo' err: boom keys: 3", result)
+
+	// no fields
+	logFields(logger, LevelWarn, "cache update dropped")
+	assert.Equal(t, "cache update dropped", result)
+}
+
+func TestSlogLogger(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	logger := &SlogLogger{
+		Logger: slog.New(slog.NewJSONHandler(buffer, &slog.HandlerOptions{Level: slog.LevelDebug})),
+	}
+
+	// make the call
+	logger.LogFields(LevelWarn, "cache get error", Field{FieldKey, "foo"}, Field{FieldError, errors.New("boom")})
+
+	// validate
+	result := map[string]interface{}{}
+	assert.Nil(t, json.Unmarshal(buffer.Bytes(), &result))
+
+	assert.Equal(t, "WARN", result["level"])
+	assert.Equal(t, "cache get error", result["msg"])
+	assert.Equal(t, "foo", result[FieldKey])
+	assert.Equal(t, "boom", result[FieldError])
+}
+
+func TestStdLogger(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	logger := &StdLogger{
+		Logger:   log.New(buffer, "", 0),
+		MinLevel: LevelWarn,
+	}
+
+	// make the calls
+	logger.LogFields(LevelInfo, "ignored")
+	logger.LogFields(LevelError, "cache get error", Field{FieldKey, "foo"}, Field{FieldError, errors.New("boom")},
+		Field{FieldKeys, 3})
+	logger.Log("printf %d", 1)
+
+	// validate
+	assert.Equal(t, "ERROR cache get error key=\"foo\" err=\"boom\" keys=3\nprintf 1\n", buffer.String())
+}
+
+func TestClient_structuredLogger(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	key := getTestKey()
+	storageErr := errors.New("something failed")
+
+	storage := &MockStorage{}
+	storage.On("Get", mock.Anything, key).Return(nil, storageErr)
+
+	logger := &testStructuredLogger{}
+	client := &Client{
+		Storage: storage,
+		Logger:  logger,
+	}
+
+	// make the call
+	resultErr := client.Get(ctx, key, &myDTO{}, BuilderFunc(func(ctx context.Context, key string, dest BinaryEncoder) error {
+		return nil
+	}))
+
+	// validate
+	assert.Equal(t, storageErr, resultErr)
+	assert.Equal(t, LevelError, logger.level)
+	assert.Equal(t, "cache get error", logger.message)
+	assert.Equal(t, []Field{
+		{FieldKey, key},
+		{FieldError, storageErr},
+		{FieldOp, OpGet},
+		{FieldStorage, "*cache.MockStorage"},
+	}, logger.fields)
+}
+
+type testStructuredLogger struct {
+	level   Level
+	message string
+	fields  []Field
+}
+
+func (t *testStructuredLogger) Log(message string, args ...interface{}) {
+	t.message = fmt.Sprintf(message, args...)
+}
+
+func (t *testStructuredLogger) LogFields(level Level, message string, fields ...Field) {
+	t.level = level
+	t.message = message
+	t.fields = fields
+}
@@ -0,0 +1,345 @@
+// Copyright 2017 Corey Scott http://www.sage42.org/
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Operations reported in Observation.Operation
+const (
+	// OpGet is a storage get
+	OpGet = "get"
+
+	// OpSet is a storage set
+	OpSet = "set"
+
+	// OpInvalidate is a storage invalidate
+	OpInvalidate = "invalidate"
+
+	// OpGetMulti is a storage batch get
+	OpGetMulti = "get_multi"
+
+	// OpSetMulti is a storage batch set
+	OpSetMulti = "set_multi"
+
+	// OpTag is a storage tag
+	OpTag = "tag"
+
+	// OpInvalidateTag is a storage tag invalidate
+	OpInvalidateTag = "invalidate_tag"
+
+	// OpBuild is a Builder (or BatchBuilder) run after a cache miss
+	OpBuild = "build"
+)
+
+// Error classes reported in Observation.ErrorClass
+const (
+	// ErrorClassNone denotes the operation was successful (cache misses are not errors)
+	ErrorClassNone = ""
+
+	// ErrorClassTimeout denotes the operation timed out (including context deadlines)
+	ErrorClassTimeout = "timeout"
+
+	// ErrorClassCanceled denotes the operation's context was canceled
+	ErrorClassCanceled = "canceled"
+
+	// ErrorClassNotFound denotes the builder returned ErrNotFound
+	ErrorClassNotFound = "not_found"
+
+	// ErrorClassOther denotes any other error
+	ErrorClassOther = "error"
+)
+
+// Observation describes a single storage operation or builder run
+type Observation struct {
+	// Cache is the Client.Name of the client that performed the operation (empty when not set)
+	Cache string
+
+	// Storage is the storage used (e.g. "redis", "memory", "l1"); empty for OpBuild
+	Storage string
+
+	// Operation is the operation performed (e.g. OpGet)
+	Operation string
+
+	// Duration is how long the operation took
+	Duration time.Duration
+
+	// Bytes is the total size of the values read or written
+	Bytes int
+
+	// Keys is the number of keys involved
+	Keys int
+
+	// Hits is the number of keys found (only for OpGet and OpGetMulti)
+	Hits int
+
+	// ErrorClass classifies the error returned by the operation (ErrorClassNone for success)
+	ErrorClass string
+}
+
+// ExtendedMetrics is an optional extension of Metrics which also receives the details of every storage operation and
+// builder run.
+//
+// When Client.Metrics implements this interface the client reports an Observation for each storage call (with the
+// client's Name) in addition to the usual events.
+type ExtendedMetrics interface {
+	Metrics
+
+	// Observe records the details of a single operation
+	Observe(observation Observation)
+}
+
+// ErrorClass returns the error class (e.g. ErrorClassTimeout) of the supplied error
+func ErrorClass(err error) string {
+	if err == nil || err == ErrCacheMiss {
+		return ErrorClassNone
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrorClassTimeout
+	}
+
+	if errors.Is(err, context.Canceled) {
+		return ErrorClassCanceled
+	}
+
+	if errors.Is(err, ErrNotFound) {
+		return ErrorClassNotFound
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return ErrorClassTimeout
+	}
+
+	return ErrorClassOther
+}
+
+// report a builder run (when extended metrics are used)
+func (c *Client) observeBuild(start time.Time, keys int, bytes int, err error) {
+	metrics, ok := c.Metrics.(ExtendedMetrics)
+	if !ok {
+		return
+	}
+
+	metrics.Observe(Observation{
+		Cache:      c.Name,
+		Operation:  OpBuild,
+		Duration:   time.Since(start),
+		Bytes:      bytes,
+		Keys:       keys,
+		ErrorClass: ErrorClass(err),
+	})
+}
+
+// return the storage wrapped so that every operation is observed (when metrics implements ExtendedMetrics) and the
+// cache name is passed to the storage (see withCacheName); otherwise the storage is returned as is
+func instrument(storage Storage, metrics Metrics, cache string, name string) Storage {
+	extended, ok := metrics.(ExtendedMetrics)
+	if !ok && cache == "" {
+		return storage
+	}
+
+	if name == "" {
+		name = storageName(storage)
+	}
+
+	return instrumentedStorage{
+		storage: storage,
+		metrics: extended,
+		cache:   cache,
+		name:    name,
+	}
+}
+
+// return a short name for the storage (for use in metrics)
+func storageName(storage Storage) string {
+	switch storage.(type) {
+	case *RedisStorage:
+		return "redis"
+
+	case *RueidisStorage:
+		return "rueidis"
+
+	case *DynamoDbStorage, *DynamoDbV2Storage:
+		return "dynamodb"
+
+	case *MemoryStorage:
+		return "memory"
+
+	case *TieredStorage:
+		return "tiered"
+
+	case *EncryptedStorage:
+		return "encrypted"
+
+	default:
+		return fmt.Sprintf("%T", storage)
+	}
+}
+
+// instrumentedStorage implements Storage (and the optional storage extensions) by reporting an Observation for each
+// call to the wrapped storage (when metrics is set) and passing the cache name with each call; this allows storages
+// that instrument their own storages (e.g. TieredStorage) to report the name of the cache that owns them
+type instrumentedStorage struct {
+	storage Storage
+	metrics ExtendedMetrics
+	cache   string
+	name    string
+}
+
+// Get implements Storage
+func (i instrumentedStorage) Get(ctx context.Context, key string) ([]byte, error) {
+	start := time.Now()
+
+	bytes, err := i.storage.Get(withCacheName(ctx, i.cache), key)
+
+	hits := 0
+	if err == nil {
+		hits = 1
+	}
+	i.observe(OpGet, start, 1, hits, len(bytes), err)
+
+	return bytes, err
+}
+
+// Set implements Storage
+func (i instrumentedStorage) Set(ctx context.Context, key string, bytes []byte) error {
+	start := time.Now()
+
+	err := i.storage.Set(withCacheName(ctx, i.cache), key, bytes)
+	i.observe(OpSet, start, 1, 0, len(bytes), err)
+
+	return err
+}
+
+// SetWithTTL implements TTLStorage
+func (i instrumentedStorage) SetWithTTL(ctx context.Context, key string, bytes []byte, ttl time.Duration) error {
+	start := time.Now()
+
+	err := setWithTTL(withCacheName(ctx, i.cache), i.storage, key, bytes, ttl)
+	i.observe(OpSet, start, 1, 0, len(bytes), err)
+
+	return err
+}
+
+// Add implements AddStorage
+func (i instrumentedStorage) Add(ctx context.Context, key string, bytes []byte, ttl time.Duration) (bool, error) {
+	start := time.Now()
+
+	added, err := add(withCacheName(ctx, i.cache), i.storage, key, bytes, ttl)
+	i.observe(OpSet, start, 1, 0, len(bytes), err)
+
+	return added, err
+}
+
+// Invalidate implements Storage
+func (i instrumentedStorage) Invalidate(ctx context.Context, key string) error {
+	start := time.Now()
+
+	err := i.storage.Invalidate(withCacheName(ctx, i.cache), key)
+	i.observe(OpInvalidate, start, 1, 0, 0, err)
+
+	return err
+}
+
+// GetMulti implements MultiStorage
+func (i instrumentedStorage) GetMulti(ctx context.Context, keys []string) (map[string][]byte, error) {
+	start := time.Now()
+
+	out, err := getMulti(withCacheName(ctx, i.cache), i.storage, keys)
+
+	size := 0
+	for _, bytes := range out {
+		size += len(bytes)
+	}
+	i.observe(OpGetMulti, start, len(keys), len(out), size, err)
+
+	return out, err
+}
+
+// SetMulti implements MultiStorage
+func (i instrumentedStorage) SetMulti(ctx context.Context, items map[string][]byte) error {
+	start := time.Now()
+
+	err := setMulti(withCacheName(ctx, i.cache), i.storage, items)
+
+	size := 0
+	for _, bytes := range items {
+		size += len(bytes)
+	}
+	i.observe(OpSetMulti, start, len(items), 0, size, err)
+
+	return err
+}
+
+// Tag implements TagStorage
+func (i instrumentedStorage) Tag(ctx context.Context, key string, tags []string, ttl time.Duration) error {
+	start := time.Now()
+
+	err := tag(withCacheName(ctx, i.cache), i.storage, key, tags, ttl)
+	i.observe(OpTag, start, 1, 0, 0, err)
+
+	return err
+}
+
+// InvalidateTag implements TagStorage
+func (i instrumentedStorage) InvalidateTag(ctx context.Context, tag string) error {
+	start := time.Now()
+
+	err := invalidateTag(withCacheName(ctx, i.cache), i.storage, tag)
+	i.observe(OpInvalidateTag, start, 0, 0, 0, err)
+
+	return err
+}
+
+func (i instrumentedStorage) observe(operation string, start time.Time, keys, hits, bytes int, err error) {
+	if i.metrics == nil {
+		return
+	}
+
+	i.metrics.Observe(Observation{
+		Cache:      i.cache,
+		Storage:    i.name,
+		Operation:  operation,
+		Duration:   time.Since(start),
+		Bytes:      bytes,
+		Keys:       keys,
+		Hits:       hits,
+		ErrorClass: ErrorClass(err),
+	})
+}
+
+type cacheNameKey struct{}
+
+// attach the name of the cache (Client.Name) that made the storage call to the context
+func withCacheName(ctx context.Context, cache string) context.Context {
+	if cache == "" {
+		return ctx
+	}
+
+	return context.WithValue(ctx, cacheNameKey{}, cache)
+}
+
+// return the name of the cache that made the storage call (empty when not set)
+func cacheNameOf(ctx context.Context) string {
+	cache, _ := ctx.Value(cacheNameKey{}).(string)
+	return cache
+}
@@ -0,0 +1,224 @@
+// Copyright 2017 Corey Scott http://www.sage42.org/
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorClass(t *testing.T) {
+	scenarios := []struct {
+		desc     string
+		err      error
+		expected string
+	}{
+		{
+			desc:     "nil",
+			err:      nil,
+			expected: ErrorClassNone,
+		},
+		{
+			desc:     "miss",
+			err:      ErrCacheMiss,
+			expected: ErrorClassNone,
+		},
+		{
+			desc:     "deadline",
+			err:      fmt.Errorf("failed: %w", context.DeadlineExceeded),
+			expected: ErrorClassTimeout,
+		},
+		{
+			desc:     "canceled",
+			err:      context.Canceled,
+			expected: ErrorClassCanceled,
+		},
+		{
+			desc:     "not found",
+			err:      ErrNotFound,
+			expected: ErrorClassNotFound,
+		},
+		{
+			desc:     "other",
+			err:      errors.New("something failed"),
+			expected: ErrorClassOther,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		t.Run(scenario.desc, func(t *testing.T) {
+			assert.Equal(t, scenario.expected, ErrorClass(scenario.err))
+		})
+	}
+}
+
+func TestEvent_String(t *testing.T) {
+	assert.Equal(t, "hit", CacheHit.String())
+	assert.Equal(t, "early_expiry", CacheEarlyExpiry.String())
+	assert.Equal(t, "unknown", Event(-1).String())
+	assert.Equal(t, "unknown", (CacheEarlyExpiry + 1).String())
+}
+
+func TestClient_extendedMetrics(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	key := getTestKey()
+
+	metrics := &testExtendedMetrics{}
+	client := &Client{
+		Name:    "users",
+		Storage: &MemoryStorage{},
+		Metrics: metrics,
+	}
+
+	builder := BuilderFunc(func(ctx context.Context, key string, dest BinaryEncoder) error {
+		dest.(*myDTO).Name = "bob"
+		return nil
+	})
+
+	// make the calls
+	assert.Nil(t, client.Get(ctx, key, &myDTO{}, builder))
+	assert.Nil(t, client.waitForPending(1*time.Second))
+	assert.Nil(t, client.Get(ctx, key, &myDTO{}, builder))
+
+	// validate
+	payload, err := (&myDTO{Name: "bob"}).MarshalBinary()
+	assert.Nil(t, err)
+
+	observations := metrics.get()
+	assert.Equal(t, 4, len(observations))
+
+	// miss
+	assert.Equal(t, Observation{Cache: "users", Storage: "memory", Operation: OpGet, Keys: 1}, withoutDuration(observations[0]))
+
+	// build
+	assert.Equal(t, Observation{Cache: "users", Operation: OpBuild, Keys: 1, Bytes: len(payload)}, withoutDuration(observations[1]))
+
+	// set
+	assert.Equal(t, Observation{Cache: "users", Storage: "memory", Operation: OpSet, Keys: 1, Bytes: len(payload)}, withoutDuration(observations[2]))
+
+	// hit
+	assert.Equal(t, Observation{Cache: "users", Storage: "memory", Operation: OpGet, Keys: 1, Hits: 1, Bytes: len(payload)}, withoutDuration(observations[3]))
+
+	assert.Equal(t, []Event{CacheMiss, CacheHit}, metrics.events)
+}
+
+func TestTieredStorage_extendedMetrics(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	key := getTestKey()
+
+	metrics := &testExtendedMetrics{}
+	storage := &TieredStorage{
+		L1:      &MemoryStorage{},
+		L2:      &MemoryStorage{},
+		Metrics: metrics,
+	}
+
+	assert.Nil(t, storage.L2.Set(ctx, key, []byte(`foo`)))
+
+	// make the call
+	_, resultErr := storage.Get(ctx, key)
+	assert.Nil(t, resultErr)
+
+	// validate
+	observations := metrics.get()
+	assert.Equal(t, 3, len(observations))
+	assert.Equal(t, Observation{Storage: "l1", Operation: OpGet, Keys: 1}, withoutDuration(observations[0]))
+	assert.Equal(t, Observation{Storage: "l2", Operation: OpGet, Keys: 1, Hits: 1, Bytes: 3}, withoutDuration(observations[1]))
+	assert.Equal(t, Observation{Storage: "l1", Operation: OpSet, Keys: 1, Bytes: 3}, withoutDuration(observations[2]))
+}
+
+func TestTieredStorage_extendedMetricsCacheName(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	key := getTestKey()
+
+	metrics := &testExtendedMetrics{}
+	client := &Client{
+		Name: "users",
+		Storage: &TieredStorage{
+			L1:      &MemoryStorage{},
+			L2:      &MemoryStorage{},
+			Metrics: metrics,
+		},
+	}
+
+	builder := BuilderFunc(func(ctx context.Context, key string, dest BinaryEncoder) error {
+		dest.(*myDTO).Name = "bob"
+		return nil
+	})
+
+	// make the call
+	assert.Nil(t, client.Get(ctx, key, &myDTO{}, builder))
+	assert.Nil(t, client.waitForPending(1*time.Second))
+
+	// validate
+	observations := metrics.get()
+	assert.True(t, len(observations) > 0)
+	for _, observation := range observations {
+		assert.Equal(t, "users", observation.Cache)
+	}
+}
+
+func TestStorageName(t *testing.T) {
+	assert.Equal(t, "redis", storageName(&RedisStorage{}))
+	assert.Equal(t, "rueidis", storageName(&RueidisStorage{}))
+	assert.Equal(t, "dynamodb", storageName(&DynamoDbStorage{}))
+	assert.Equal(t, "dynamodb", storageName(&DynamoDbV2Storage{}))
+	assert.Equal(t, "memory", storageName(&MemoryStorage{}))
+	assert.Equal(t, "*cache.MockStorage", storageName(&MockStorage{}))
+}
+
+func withoutDuration(observation Observation) Observation {
+	observation.Duration = 0
+	return observation
+}
+
+type testExtendedMetrics struct {
+	mutex        sync.Mutex
+	events       []Event
+	observations []Observation
+}
+
+func (t *testExtendedMetrics) Track(event Event) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.events = append(t.events, event)
+}
+
+func (t *testExtendedMetrics) Observe(observation Observation) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.observations = append(t.observations, observation)
+}
+
+func (t *testExtendedMetrics) get() []Observation {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return append([]Observation{}, t.observations...)
+}
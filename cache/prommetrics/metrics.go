@@ -0,0 +1,169 @@
+// Copyright 2017 Corey Scott http://www.sage42.org/
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package prommetrics implements cache.ExtendedMetrics using Prometheus
+package prommetrics
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/corsc/go-commons/cache"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const defaultNamespace = "cache"
+
+// Metrics implements cache.ExtendedMetrics by updating Prometheus collectors.
+//
+// The following metrics are registered (with the default namespace):
+//   - cache_events_total{cache,event}
+//   - cache_operation_duration_seconds{cache,storage,operation,error_class}
+//   - cache_operation_bytes_total{cache,storage,operation}
+//   - cache_operation_keys_total{cache,storage,operation}
+//   - cache_operation_hits_total{cache,storage,operation}
+//
+// The collectors are registered on first use; when they are already registered (e.g. by another Metrics with the
+// same Namespace), the existing collectors are used.
+type Metrics struct {
+	// Cache is the value of the cache label of the events counter; typically the Name of the cache.Client that uses
+	// these Metrics (optional - default "")
+	Cache string
+
+	// Namespace is prefixed to every metric name (optional - default "cache")
+	Namespace string
+
+	// Registerer is where the collectors are registered (optional - default prometheus.DefaultRegisterer)
+	Registerer prometheus.Registerer
+
+	// Buckets are the buckets of the duration histogram in seconds (optional - default prometheus.DefBuckets)
+	Buckets []float64
+
+	initOnce sync.Once
+
+	events    *prometheus.CounterVec
+	durations *prometheus.HistogramVec
+	bytes     *prometheus.CounterVec
+	keys      *prometheus.CounterVec
+	hits      *prometheus.CounterVec
+}
+
+// Track implements cache.Metrics
+func (m *Metrics) Track(event cache.Event) {
+	m.initOnce.Do(m.init)
+
+	m.events.WithLabelValues(m.Cache, event.String()).Inc()
+}
+
+// Observe implements cache.ExtendedMetrics
+func (m *Metrics) Observe(observation cache.Observation) {
+	m.initOnce.Do(m.init)
+
+	m.durations.WithLabelValues(observation.Cache, observation.Storage, observation.Operation, observation.ErrorClass).
+		Observe(observation.Duration.Seconds())
+
+	m.bytes.WithLabelValues(observation.Cache, observation.Storage, observation.Operation).Add(float64(observation.Bytes))
+	m.keys.WithLabelValues(observation.Cache, observation.Storage, observation.Operation).Add(float64(observation.Keys))
+	m.hits.WithLabelValues(observation.Cache, observation.Storage, observation.Operation).Add(float64(observation.Hits))
+}
+
+func (m *Metrics) init() {
+	labels := []string{"cache", "storage", "operation"}
+
+	m.events = m.registerCounter(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: m.getNamespace(),
+		Name:      "events_total",
+		Help:      "Total cache events (hit, miss, etc) by cache and event.",
+	}, []string{"cache", "event"}))
+
+	m.durations = m.registerHistogram(prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: m.getNamespace(),
+		Name:      "operation_duration_seconds",
+		Help:      "Duration of cache storage operations and builder runs.",
+		Buckets:   m.getBuckets(),
+	}, append(labels, "error_class")))
+
+	m.bytes = m.registerCounter(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: m.getNamespace(),
+		Name:      "operation_bytes_total",
+		Help:      "Total bytes read or written by cache storage operations and builder runs.",
+	}, labels))
+
+	m.keys = m.registerCounter(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: m.getNamespace(),
+		Name:      "operation_keys_total",
+		Help:      "Total keys requested from cache storage operations and builder runs.",
+	}, labels))
+
+	m.hits = m.registerCounter(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: m.getNamespace(),
+		Name:      "operation_hits_total",
+		Help:      "Total keys found by cache storage get operations.",
+	}, labels))
+}
+
+// register the collector; returning the existing collector when it is already registered
+func (m *Metrics) registerCounter(collector *prometheus.CounterVec) *prometheus.CounterVec {
+	if existing, ok := m.register(collector).(*prometheus.CounterVec); ok {
+		return existing
+	}
+
+	return collector
+}
+
+// register the collector; returning the existing collector when it is already registered
+func (m *Metrics) registerHistogram(collector *prometheus.HistogramVec) *prometheus.HistogramVec {
+	if existing, ok := m.register(collector).(*prometheus.HistogramVec); ok {
+		return existing
+	}
+
+	return collector
+}
+
+// register the collector and return the existing collector (if any).
+// Other registration errors are ignored; the collector is still updated but is not exported.
+func (m *Metrics) register(collector prometheus.Collector) prometheus.Collector {
+	err := m.getRegisterer().Register(collector)
+
+	alreadyRegistered := prometheus.AlreadyRegisteredError{}
+	if errors.As(err, &alreadyRegistered) {
+		return alreadyRegistered.ExistingCollector
+	}
+
+	return nil
+}
+
+func (m *Metrics) getNamespace() string {
+	if m.Namespace != "" {
+		return m.Namespace
+	}
+
+	return defaultNamespace
+}
+
+func (m *Metrics) getRegisterer() prometheus.Registerer {
+	if m.Registerer != nil {
+		return m.Registerer
+	}
+
+	return prometheus.DefaultRegisterer
+}
+
+func (m *Metrics) getBuckets() []float64 {
+	if len(m.Buckets) > 0 {
+		return m.Buckets
+	}
+
+	return prometheus.DefBuckets
+}
@@ -0,0 +1,120 @@
+// Copyright 2017 Corey Scott http://www.sage42.org/
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prommetrics
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/corsc/go-commons/cache"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics_implements(t *testing.T) {
+	assert.Implements(t, (*cache.ExtendedMetrics)(nil), &Metrics{})
+}
+
+func TestMetrics(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	metrics := &Metrics{
+		Cache:      "users",
+		Registerer: registry,
+	}
+
+	// make the calls
+	metrics.Track(cache.CacheHit)
+	metrics.Track(cache.CacheHit)
+	metrics.Track(cache.CacheMiss)
+
+	metrics.Observe(cache.Observation{
+		Cache:     "users",
+		Storage:   "redis",
+		Operation: cache.OpGet,
+		Duration:  10 * time.Millisecond,
+		Bytes:     123,
+		Keys:      1,
+		Hits:      1,
+	})
+
+	// validate
+	assert.Equal(t, float64(2), testutil.ToFloat64(metrics.events.WithLabelValues("users", "hit")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.events.WithLabelValues("users", "miss")))
+	assert.Equal(t, float64(123), testutil.ToFloat64(metrics.bytes.WithLabelValues("users", "redis", cache.OpGet)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.hits.WithLabelValues("users", "redis", cache.OpGet)))
+	assert.Equal(t, 1, testutil.CollectAndCount(metrics.durations, "cache_operation_duration_seconds"))
+}
+
+func TestMetrics_alreadyRegistered(t *testing.T) {
+	registry := prometheus.NewRegistry()
+
+	first := &Metrics{Cache: "users", Registerer: registry}
+	second := &Metrics{Cache: "users", Registerer: registry}
+	other := &Metrics{Cache: "orders", Registerer: registry}
+
+	// make the calls
+	first.Track(cache.CacheHit)
+	second.Track(cache.CacheHit)
+	other.Track(cache.CacheHit)
+
+	// validate all share the same collectors and the events are labelled by cache
+	assert.Equal(t, float64(2), testutil.ToFloat64(first.events.WithLabelValues("users", "hit")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(first.events.WithLabelValues("orders", "hit")))
+}
+
+func TestMetrics_withClient(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+
+	registry := prometheus.NewRegistry()
+	metrics := &Metrics{
+		Cache:      "users",
+		Registerer: registry,
+	}
+
+	client := &cache.Client{
+		Name:    "users",
+		Storage: &cache.MemoryStorage{},
+		Metrics: metrics,
+	}
+
+	// make the calls
+	client.Set(ctx, "key", &myDTO{Name: "bob"})
+
+	result := &myDTO{}
+	err := client.Get(ctx, "key", result, nil)
+	require.NoError(t, err)
+
+	// validate
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.keys.WithLabelValues("users", "memory", cache.OpSet)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.events.WithLabelValues("users", cache.CacheHit.String())))
+}
+
+type myDTO struct {
+	Name string
+}
+
+func (m *myDTO) MarshalBinary() (data []byte, err error) {
+	return []byte(m.Name), nil
+}
+
+func (m *myDTO) UnmarshalBinary(data []byte) error {
+	m.Name = string(data)
+	return nil
+}
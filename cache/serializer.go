@@ -0,0 +1,104 @@
+// Copyright 2017 Corey Scott http://www.sage42.org/
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+// Serializer converts values of type T to and from the bytes stored in the cache (used by Typed)
+type Serializer[T any] interface {
+	// Marshal returns the binary form of the value
+	Marshal(value T) ([]byte, error)
+
+	// Unmarshal returns the value from the output of Marshal
+	Unmarshal(data []byte) (T, error)
+}
+
+// JSONSerializer implements Serializer using encoding/json
+type JSONSerializer[T any] struct{}
+
+// Marshal implements Serializer
+func (JSONSerializer[T]) Marshal(value T) ([]byte, error) {
+	return json.Marshal(value)
+}
+
+// Unmarshal implements Serializer
+func (JSONSerializer[T]) Unmarshal(data []byte) (T, error) {
+	var out T
+	err := json.Unmarshal(data, &out)
+	return out, err
+}
+
+// GobSerializer implements Serializer using encoding/gob
+type GobSerializer[T any] struct{}
+
+// Marshal implements Serializer
+func (GobSerializer[T]) Marshal(value T) ([]byte, error) {
+	buffer := &bytes.Buffer{}
+
+	err := gob.NewEncoder(buffer).Encode(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// Unmarshal implements Serializer
+func (GobSerializer[T]) Unmarshal(data []byte) (T, error) {
+	var out T
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&out)
+	return out, err
+}
+
+// MsgpackSerializer implements Serializer using MessagePack
+type MsgpackSerializer[T any] struct{}
+
+// Marshal implements Serializer
+func (MsgpackSerializer[T]) Marshal(value T) ([]byte, error) {
+	return msgpack.Marshal(value)
+}
+
+// Unmarshal implements Serializer
+func (MsgpackSerializer[T]) Unmarshal(data []byte) (T, error) {
+	var out T
+	err := msgpack.Unmarshal(data, &out)
+	return out, err
+}
+
+// ProtoSerializer implements Serializer for protocol buffer messages.
+//
+// T must be the pointer type of the generated message (e.g. ProtoSerializer[*mypb.User]).
+type ProtoSerializer[T proto.Message] struct{}
+
+// Marshal implements Serializer
+func (ProtoSerializer[T]) Marshal(value T) ([]byte, error) {
+	return proto.Marshal(value)
+}
+
+// Unmarshal implements Serializer
+func (ProtoSerializer[T]) Unmarshal(data []byte) (T, error) {
+	var zero T
+	out := zero.ProtoReflect().New().Interface().(T)
+
+	err := proto.Unmarshal(data, out)
+	return out, err
+}
@@ -0,0 +1,84 @@
+// Copyright 2017 Corey Scott http://www.sage42.org/
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestSerializers(t *testing.T) {
+	in := myUser{ID: 1, Name: "bob", Roles: []string{"admin"}}
+
+	scenarios := []struct {
+		desc       string
+		serializer Serializer[myUser]
+	}{
+		{
+			desc:       "json",
+			serializer: JSONSerializer[myUser]{},
+		},
+		{
+			desc:       "gob",
+			serializer: GobSerializer[myUser]{},
+		},
+		{
+			desc:       "msgpack",
+			serializer: MsgpackSerializer[myUser]{},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		t.Run(scenario.desc, func(t *testing.T) {
+			data, resultErr := scenario.serializer.Marshal(in)
+			assert.Nil(t, resultErr)
+
+			result, resultErr := scenario.serializer.Unmarshal(data)
+			assert.Nil(t, resultErr)
+			assert.Equal(t, in, result)
+		})
+	}
+}
+
+func TestProtoSerializer(t *testing.T) {
+	serializer := ProtoSerializer[*wrapperspb.StringValue]{}
+
+	data, resultErr := serializer.Marshal(wrapperspb.String("bob"))
+	assert.Nil(t, resultErr)
+
+	result, resultErr := serializer.Unmarshal(data)
+	assert.Nil(t, resultErr)
+	assert.True(t, proto.Equal(wrapperspb.String("bob"), result))
+}
+
+func TestSerializers_unmarshalError(t *testing.T) {
+	_, resultErr := JSONSerializer[myUser]{}.Unmarshal([]byte(`not json`))
+	assert.NotNil(t, resultErr)
+
+	_, resultErr = GobSerializer[myUser]{}.Unmarshal([]byte(`not gob`))
+	assert.NotNil(t, resultErr)
+
+	_, resultErr = ProtoSerializer[*wrapperspb.StringValue]{}.Unmarshal([]byte{0xFF})
+	assert.NotNil(t, resultErr)
+}
+
+type myUser struct {
+	ID    int
+	Name  string
+	Roles []string
+}
@@ -0,0 +1,106 @@
+// Copyright 2017 Corey Scott http://www.sage42.org/
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package statsdmetrics implements cache.ExtendedMetrics using a StatsD/DogStatsD client
+package statsdmetrics
+
+import (
+	"time"
+
+	"github.com/corsc/go-commons/cache"
+)
+
+const defaultPrefix = "cache."
+
+// Client is the subset of a DogStatsD client used by Metrics (e.g. *statsd.Client from
+// github.com/DataDog/datadog-go)
+type Client interface {
+	// Incr increments the counter by 1
+	Incr(name string, tags []string, rate float64) error
+
+	// Count adds the value to the counter
+	Count(name string, value int64, tags []string, rate float64) error
+
+	// Timing records the duration
+	Timing(name string, value time.Duration, tags []string, rate float64) error
+}
+
+// Metrics implements cache.ExtendedMetrics by sending StatsD metrics with DogStatsD style tags.
+//
+// The following metrics are sent (with the default prefix):
+//   - cache.event (tags: cache, event)
+//   - cache.operation.duration (tags: cache, storage, operation, error_class)
+//   - cache.operation.bytes (tags: cache, storage, operation, error_class)
+//   - cache.operation.keys (tags: cache, storage, operation, error_class)
+//   - cache.operation.hits (tags: cache, storage, operation, error_class)
+type Metrics struct {
+	// Client is the StatsD client (required)
+	Client Client
+
+	// Cache is the value of the cache tag of the events; typically the Name of the cache.Client that uses these
+	// Metrics (optional - default "")
+	Cache string
+
+	// Prefix is prefixed to every metric name (optional - default "cache.")
+	Prefix string
+
+	// Tags are added to every metric (optional)
+	Tags []string
+
+	// Rate is the sample rate (optional - default 1)
+	Rate float64
+}
+
+// Track implements cache.Metrics
+func (m *Metrics) Track(event cache.Event) {
+	_ = m.Client.Incr(m.getPrefix()+"event", m.tags("cache:"+m.Cache, "event:"+event.String()), m.getRate())
+}
+
+// Observe implements cache.ExtendedMetrics
+func (m *Metrics) Observe(observation cache.Observation) {
+	tags := m.tags(
+		"cache:"+observation.Cache,
+		"storage:"+observation.Storage,
+		"operation:"+observation.Operation,
+		"error_class:"+observation.ErrorClass,
+	)
+
+	prefix := m.getPrefix() + "operation."
+
+	_ = m.Client.Timing(prefix+"duration", observation.Duration, tags, m.getRate())
+	_ = m.Client.Count(prefix+"bytes", int64(observation.Bytes), tags, m.getRate())
+	_ = m.Client.Count(prefix+"keys", int64(observation.Keys), tags, m.getRate())
+	_ = m.Client.Count(prefix+"hits", int64(observation.Hits), tags, m.getRate())
+}
+
+// return the common tags and the supplied tags
+func (m *Metrics) tags(tags ...string) []string {
+	return append(append(make([]string, 0, len(m.Tags)+len(tags)), m.Tags...), tags...)
+}
+
+func (m *Metrics) getPrefix() string {
+	if m.Prefix != "" {
+		return m.Prefix
+	}
+
+	return defaultPrefix
+}
+
+func (m *Metrics) getRate() float64 {
+	if m.Rate > 0 {
+		return m.Rate
+	}
+
+	return 1
+}
@@ -0,0 +1,90 @@
+// Copyright 2017 Corey Scott http://www.sage42.org/
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package statsdmetrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/corsc/go-commons/cache"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestMetrics_implements(t *testing.T) {
+	assert.Implements(t, (*cache.ExtendedMetrics)(nil), &Metrics{})
+}
+
+func TestMetrics_Track(t *testing.T) {
+	client := &mockClient{}
+	client.On("Incr", "cache.event", []string{"env:test", "cache:users", "event:hit"}, float64(1)).Return(nil).Once()
+
+	metrics := &Metrics{
+		Client: client,
+		Cache:  "users",
+		Tags:   []string{"env:test"},
+	}
+
+	// make the call
+	metrics.Track(cache.CacheHit)
+
+	// validate
+	assert.True(t, client.AssertExpectations(t))
+}
+
+func TestMetrics_Observe(t *testing.T) {
+	tags := []string{"cache:users", "storage:redis", "operation:get", "error_class:timeout"}
+
+	client := &mockClient{}
+	client.On("Timing", "my.operation.duration", 10*time.Millisecond, tags, 0.5).Return(nil).Once()
+	client.On("Count", "my.operation.bytes", int64(0), tags, 0.5).Return(nil).Once()
+	client.On("Count", "my.operation.keys", int64(1), tags, 0.5).Return(nil).Once()
+	client.On("Count", "my.operation.hits", int64(0), tags, 0.5).Return(nil).Once()
+
+	metrics := &Metrics{
+		Client: client,
+		Prefix: "my.",
+		Rate:   0.5,
+	}
+
+	// make the call
+	metrics.Observe(cache.Observation{
+		Cache:      "users",
+		Storage:    "redis",
+		Operation:  cache.OpGet,
+		Duration:   10 * time.Millisecond,
+		Keys:       1,
+		ErrorClass: cache.ErrorClassTimeout,
+	})
+
+	// validate
+	assert.True(t, client.AssertExpectations(t))
+}
+
+type mockClient struct {
+	mock.Mock
+}
+
+func (m *mockClient) Incr(name string, tags []string, rate float64) error {
+	return m.Called(name, tags, rate).Error(0)
+}
+
+func (m *mockClient) Count(name string, value int64, tags []string, rate float64) error {
+	return m.Called(name, value, tags, rate).Error(0)
+}
+
+func (m *mockClient) Timing(name string, value time.Duration, tags []string, rate float64) error {
+	return m.Called(name, value, tags, rate).Error(0)
+}
@@ -16,8 +16,13 @@ package cache
 
 import (
 	"context"
+	"errors"
+	"time"
 )
 
+// returned by storages that were unable to complete a batch operation
+var errBatchIncomplete = errors.New("batch operation did not complete")
+
 // Storage is an abstract definition of the underlying cache storage
 //go:generate mockery -name Storage -inpkg -case underscore
 type Storage interface {
@@ -30,3 +35,139 @@ type Storage interface {
 	// Invalidate will force invalidate/remove a key from storage
 	Invalidate(ctx context.Context, key string) error
 }
+
+// MultiStorage is an optional extension of Storage for storages that are able to get or set many keys at once
+type MultiStorage interface {
+	// GetMulti will attempt to get the values for the supplied keys from storage.
+	// Keys that are not found are not included in the result.
+	GetMulti(ctx context.Context, keys []string) (map[string][]byte, error)
+
+	// SetMulti will save the supplied values into storage
+	SetMulti(ctx context.Context, items map[string][]byte) error
+}
+
+// get many keys from storage; falls back to 1 call per key when the storage does not implement MultiStorage
+func getMulti(ctx context.Context, storage Storage, keys []string) (map[string][]byte, error) {
+	if multi, ok := storage.(MultiStorage); ok {
+		return multi.GetMulti(ctx, keys)
+	}
+
+	out := make(map[string][]byte, len(keys))
+	for _, key := range keys {
+		bytes, err := storage.Get(ctx, key)
+		if err != nil {
+			if err == ErrCacheMiss {
+				continue
+			}
+			return nil, err
+		}
+
+		out[key] = bytes
+	}
+
+	return out, nil
+}
+
+// save many keys into storage; falls back to 1 call per key when the storage does not implement MultiStorage
+func setMulti(ctx context.Context, storage Storage, items map[string][]byte) error {
+	if multi, ok := storage.(MultiStorage); ok {
+		return multi.SetMulti(ctx, items)
+	}
+
+	for key, bytes := range items {
+		err := storage.Set(ctx, key, bytes)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// TTLStorage is an optional extension of Storage for storages that support a per item TTL
+type TTLStorage interface {
+	// SetWithTTL will save a value into storage with the supplied TTL (instead of the storage's default)
+	SetWithTTL(ctx context.Context, key string, bytes []byte, ttl time.Duration) error
+}
+
+// save into storage with the supplied TTL; when the TTL is not set or the storage does not implement TTLStorage the
+// storage's default TTL is used
+func setWithTTL(ctx context.Context, storage Storage, key string, bytes []byte, ttl time.Duration) error {
+	if ttl > 0 {
+		if ttlStorage, ok := storage.(TTLStorage); ok {
+			return ttlStorage.SetWithTTL(ctx, key, bytes, ttl)
+		}
+	}
+
+	return storage.Set(ctx, key, bytes)
+}
+
+// AddStorage is an optional extension of Storage for storages that are able to save a value only when the key does
+// not already exist (e.g. redis SET NX or a DynamoDB conditional put)
+type AddStorage interface {
+	// Add will save the value with the supplied TTL (where zero denotes the storage's default TTL) unless the key
+	// already exists; returns false when the key exists
+	Add(ctx context.Context, key string, bytes []byte, ttl time.Duration) (bool, error)
+}
+
+// save the value unless the key already exists; falls back to an unconditional write when the storage does not
+// implement AddStorage
+func add(ctx context.Context, storage Storage, key string, bytes []byte, ttl time.Duration) (bool, error) {
+	if addStorage, ok := storage.(AddStorage); ok {
+		return addStorage.Add(ctx, key, bytes, ttl)
+	}
+
+	return true, setWithTTL(ctx, storage, key, bytes, ttl)
+}
+
+// ErrTagsNotSupported is returned when tags are used with a storage that does not implement TagStorage
+var ErrTagsNotSupported = errors.New("storage does not support tags")
+
+// TagStorage is an optional extension of Storage for storages that are able to invalidate many keys by tag
+type TagStorage interface {
+	// Tag associates the (already saved) key with the supplied tags.
+	// The TTL is that of the item (where zero denotes the storage's default TTL).
+	Tag(ctx context.Context, key string, tags []string, ttl time.Duration) error
+
+	// InvalidateTag will force invalidate/remove all the keys associated with the tag
+	InvalidateTag(ctx context.Context, tag string) error
+}
+
+// associate the key with the tags; returns ErrTagsNotSupported when the storage does not implement TagStorage
+func tag(ctx context.Context, storage Storage, key string, tags []string, ttl time.Duration) error {
+	if tagStorage, ok := storage.(TagStorage); ok {
+		return tagStorage.Tag(ctx, key, tags, ttl)
+	}
+
+	return ErrTagsNotSupported
+}
+
+// invalidate all keys with the tag; returns ErrTagsNotSupported when the storage does not implement TagStorage
+func invalidateTag(ctx context.Context, storage Storage, tag string) error {
+	if tagStorage, ok := storage.(TagStorage); ok {
+		return tagStorage.InvalidateTag(ctx, tag)
+	}
+
+	return ErrTagsNotSupported
+}
+
+// key of the version in the context
+type versionKey struct{}
+
+// WithVersion returns a context that carries the version of the values saved with it.  Storages that support versioned
+// writes (e.g. DynamoDbStorage with Versioned set) will not replace a value with an older version.
+//
+// Client uses the time each build started (in nanoseconds) as the version of the values created by a Builder, so a
+// slow build cannot overwrite the result of a build that started after it.
+func WithVersion(ctx context.Context, version int64) context.Context {
+	return context.WithValue(ctx, versionKey{}, version)
+}
+
+// return the version carried by the context; values without a version use the current time
+func versionOf(ctx context.Context) int64 {
+	if version, ok := ctx.Value(versionKey{}).(int64); ok {
+		return version
+	}
+
+	return time.Now().UnixNano()
+}
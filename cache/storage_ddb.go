@@ -17,24 +17,28 @@ package cache
 import (
 	"context"
 	"strconv"
+	"sync"
 	"time"
 
-	"github.com/afex/hystrix-go/hystrix"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // DynamoDbStorage implements Storage
 //
-// It is strongly recommended that users customize the circuit breaker settings with a call similar to:
+// DynamoDB can take up to 48 hours to delete expired items, so items whose `ttl` attribute has passed are treated as
+// cache misses by Get and GetMulti.
 //
-//    hystrix.ConfigureCommand(cache.CbDynamoDbStorage, hystrix.CommandConfig{
-//        Timeout: 1 * 1000,
-//        MaxConcurrentRequests: 1000,
-//        ErrorPercentThreshold: 50,
-//        })
+// DynamoDB limits items to 400KB, so values larger than ChunkSize are split across multiple chunk items and a manifest
+// item (saved under the key) that records the chunks and a checksum of the value.  The chunks of each write have
+// unique keys, so readers never see a mix of old and new chunks, and the value is replaced (or invalidated) atomically
+// with the manifest.  Chunks that are no longer referenced are deleted by Invalidate or expire with their TTL.
 //
+// Calls are protected by a per instance circuit breaker (see Breaker).  To use a shared hystrix command instead, set
+// Breaker to `&hystrixbreaker.Breaker{Name: cache.CbDynamoDbStorage}` and configure the command with `hystrix.ConfigureCommand()`.
 type DynamoDbStorage struct {
 	// Service is the AWS DDB Client instance
 	Service dynamodbiface.DynamoDBAPI
@@ -42,24 +46,59 @@ type DynamoDbStorage struct {
 	// TableName is the AWS DDB Table name
 	TableName string
 
-	// TTL is the max TTL for cache items (required)
+	// TTL is the default TTL for cache items; it can be overridden per item with SetWithTTL (required)
 	TTL time.Duration
+
+	// ChunkSize is the size of the largest value that is saved as a single item; larger values are split into chunks
+	// of this size (optional - default 350KB)
+	ChunkSize int
+
+	// Versioned enables conditional puts that only replace an item with one of the same or a newer version (see
+	// WithVersion); puts of older versions are silently discarded.  SetMulti uses 1 PutItem per item when this is set as
+	// batch writes cannot be conditional (optional - default disabled)
+	Versioned bool
+
+	// TracerProvider enables OpenTelemetry spans for every DDB call (optional - default disabled)
+	TracerProvider trace.TracerProvider
+
+	// Breaker protects the calls to the server (optional - default is a NativeBreaker with its default settings).
+	// Use NoopBreaker to disable the circuit breaker.
+	Breaker CircuitBreaker
+
+	defaultBreaker     CircuitBreaker
+	defaultBreakerOnce sync.Once
+}
+
+// return the circuit breaker for this storage
+func (r *DynamoDbStorage) getBreaker() CircuitBreaker {
+	if r.Breaker != nil {
+		return r.Breaker
+	}
+
+	r.defaultBreakerOnce.Do(func() {
+		r.defaultBreaker = &NativeBreaker{}
+	})
+
+	return r.defaultBreaker
 }
 
 // Get implements Storage
-func (r *DynamoDbStorage) Get(ctx context.Context, key string) ([]byte, error) {
-	resultCh := make(chan []byte, 1)
-	errorCh := hystrix.Go(CbDynamoDbStorage, func() error {
+func (r *DynamoDbStorage) Get(ctx context.Context, key string) (bytes []byte, err error) {
+	ctx, span := startStorageSpan(ctx, r.TracerProvider, "dynamodb", "GetItem")
+	defer func() {
+		endSpan(span, len(bytes), err)
+	}()
+
+	resultCh := make(chan map[string]*dynamodb.AttributeValue, 1)
+	errorCh := goWithBreaker(ctx, r.getBreaker(), func() error {
 		params := &dynamodb.GetItemInput{
 			Key: map[string]*dynamodb.AttributeValue{
 				ddbKey: {
 					S: aws.String(key),
 				},
 			},
-			TableName: aws.String(r.TableName),
-			AttributesToGet: []*string{
-				aws.String(ddbData),
-			},
+			TableName:       aws.String(r.TableName),
+			AttributesToGet: aws.StringSlice(ddbItemAttributes),
 		}
 
 		resp, err := r.Service.GetItemWithContext(ctx, params)
@@ -67,23 +106,29 @@ func (r *DynamoDbStorage) Get(ctx context.Context, key string) ([]byte, error) {
 			return err
 		}
 
-		if len(resp.Item) == 0 {
+		if len(resp.Item) == 0 || ddbIsExpired(resp.Item[ddbTTL], time.Now()) {
 			// cache miss (cannot be returned as error or the CB will track it)
 			resultCh <- nil
 			return nil
 		}
 
-		resultCh <- resp.Item[ddbData].B
+		resultCh <- resp.Item
 		return nil
-	}, nil)
+	})
 
 	select {
 	case result := <-resultCh:
 		if result == nil {
 			return nil, ErrCacheMiss
 		}
+
+		if ddbIsManifest(result) {
+			// the value was split into chunks
+			return r.getChunked(ctx, key, result)
+		}
+
 		// success
-		return result, nil
+		return result[ddbData].B, nil
 
 	case <-ctx.Done():
 		// timeout/context cancelled
@@ -97,33 +142,166 @@ func (r *DynamoDbStorage) Get(ctx context.Context, key string) ([]byte, error) {
 
 // Set implements Storage
 func (r *DynamoDbStorage) Set(ctx context.Context, key string, bytes []byte) error {
-	resultCh := make(chan struct{}, 1)
-	errorCh := hystrix.Go(CbDynamoDbStorage, func() error {
-		defer close(resultCh)
+	return r.put(ctx, key, bytes, r.TTL)
+}
+
+// SetWithTTL implements TTLStorage
+func (r *DynamoDbStorage) SetWithTTL(ctx context.Context, key string, bytes []byte, ttl time.Duration) error {
+	return r.put(ctx, key, bytes, ttl)
+}
+
+// save the value with the supplied TTL; values larger than the chunk size are split into chunks
+func (r *DynamoDbStorage) put(ctx context.Context, key string, bytes []byte, ttl time.Duration) error {
+	if len(bytes) > r.getChunkSize() {
+		_, err := r.putChunked(ctx, key, bytes, ttl, false)
+		return err
+	}
+
+	_, err := r.putItem(ctx, r.newItem(key, bytes, time.Now().Add(ttl)), len(bytes), false)
+	return err
+}
+
+// Add implements AddStorage using a conditional put; items that have expired (but not yet been deleted by DynamoDB)
+// are replaced
+func (r *DynamoDbStorage) Add(ctx context.Context, key string, bytes []byte, ttl time.Duration) (bool, error) {
+	if ttl <= 0 {
+		ttl = r.TTL
+	}
+
+	if len(bytes) > r.getChunkSize() {
+		return r.putChunked(ctx, key, bytes, ttl, true)
+	}
+
+	return r.putItem(ctx, r.newItem(key, bytes, time.Now().Add(ttl)), len(bytes), true)
+}
+
+// save the item; size is the size of the value.
+//
+// When onlyIfAbsent is set, the item is only saved when the key does not exist (or has expired) and false is returned
+// otherwise.  When Versioned is set, the item is only saved when it is not older than the existing item.
+func (r *DynamoDbStorage) putItem(ctx context.Context, item map[string]*dynamodb.AttributeValue, size int, onlyIfAbsent bool) (saved bool, err error) {
+	ctx, span := startStorageSpan(ctx, r.TracerProvider, "dynamodb", "PutItem")
+	defer func() {
+		endSpan(span, size, err)
+	}()
 
-		timestamp := time.Now().Add(r.TTL).Unix()
+	version := versionOf(ctx)
 
+	resultCh := make(chan bool, 1)
+	errorCh := goWithBreaker(ctx, r.getBreaker(), func() error {
 		params := &dynamodb.PutItemInput{
-			Item: map[string]*dynamodb.AttributeValue{
+			Item:      item,
+			TableName: aws.String(r.TableName),
+		}
+
+		if r.Versioned {
+			params.Item[ddbVersion] = &dynamodb.AttributeValue{
+				N: aws.String(strconv.FormatInt(version, 10)),
+			}
+		}
+
+		if condition, ok := ddbPutCondition(onlyIfAbsent, r.Versioned, version, time.Now()); ok {
+			params.ConditionExpression = aws.String(condition.expression)
+			params.ExpressionAttributeNames = aws.StringMap(condition.names)
+			params.ExpressionAttributeValues = make(map[string]*dynamodb.AttributeValue, len(condition.values))
+			for name, value := range condition.values {
+				params.ExpressionAttributeValues[name] = &dynamodb.AttributeValue{
+					N: aws.String(value),
+				}
+			}
+		}
+
+		_, err := r.Service.PutItemWithContext(ctx, params)
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			// the key exists or a newer version has been saved
+			resultCh <- false
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		resultCh <- true
+		return nil
+	})
+
+	select {
+	case result := <-resultCh:
+		// success
+		return result, nil
+
+	case <-ctx.Done():
+		// timeout/context cancelled
+		return false, ctx.Err()
+
+	case err := <-errorCh:
+		// failure
+		return false, err
+	}
+}
+
+// return the item that holds the value
+func (r *DynamoDbStorage) newItem(key string, bytes []byte, expiry time.Time) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		ddbKey: {
+			S: aws.String(key),
+		},
+		ddbData: {
+			B: bytes,
+		},
+		ddbTTL: {
+			N: aws.String(strconv.FormatInt(expiry.Unix(), 10)),
+		},
+	}
+}
+
+// returns true when the item's ttl attribute has passed
+func ddbIsExpired(ttl *dynamodb.AttributeValue, now time.Time) bool {
+	if ttl == nil {
+		return false
+	}
+
+	return ddbIsExpiredAt(aws.StringValue(ttl.N), now)
+}
+
+// Invalidate implements Storage.
+//
+// Values that were split into chunks are invalidated atomically by deleting the manifest; the chunks are deleted
+// afterwards (chunks that could not be deleted expire with their TTL).
+func (r *DynamoDbStorage) Invalidate(ctx context.Context, key string) (err error) {
+	ctx, span := startStorageSpan(ctx, r.TracerProvider, "dynamodb", "DeleteItem")
+	defer func() {
+		endSpan(span, 0, err)
+	}()
+
+	resultCh := make(chan map[string]*dynamodb.AttributeValue, 1)
+	errorCh := goWithBreaker(ctx, r.getBreaker(), func() error {
+		params := &dynamodb.DeleteItemInput{
+			Key: map[string]*dynamodb.AttributeValue{
 				ddbKey: {
 					S: aws.String(key),
 				},
-				ddbData: {
-					B: bytes,
-				},
-				ddbTTL: {
-					N: aws.String(strconv.FormatInt(timestamp, 10)),
-				},
 			},
-			TableName: aws.String(r.TableName),
+			TableName:    aws.String(r.TableName),
+			ReturnValues: aws.String(dynamodb.ReturnValueAllOld),
 		}
 
-		_, err := r.Service.PutItemWithContext(ctx, params)
-		return err
-	}, nil)
+		resp, err := r.Service.DeleteItemWithContext(ctx, params)
+		if err != nil {
+			return err
+		}
+
+		resultCh <- resp.Attributes
+		return nil
+	})
 
 	select {
-	case <-resultCh:
+	case result := <-resultCh:
+		if ddbIsManifest(result) {
+			_ = r.deleteChunks(ctx, key, result)
+		}
+
 		// success
 		return nil
 
@@ -137,24 +315,213 @@ func (r *DynamoDbStorage) Set(ctx context.Context, key string, bytes []byte) err
 	}
 }
 
-// Invalidate implements Storage
-func (r *DynamoDbStorage) Invalidate(ctx context.Context, key string) error {
-	resultCh := make(chan struct{}, 1)
-	errorCh := hystrix.Go(CbDynamoDbStorage, func() error {
-		defer close(resultCh)
+// GetMulti implements MultiStorage using BatchGetItem
+func (r *DynamoDbStorage) GetMulti(ctx context.Context, keys []string) (map[string][]byte, error) {
+	items, err := r.getItems(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
 
-		params := &dynamodb.DeleteItemInput{
-			Key: map[string]*dynamodb.AttributeValue{
+	out := make(map[string][]byte, len(items))
+	manifests := map[string]map[string]*dynamodb.AttributeValue{}
+	for key, item := range items {
+		if ddbIsManifest(item) {
+			manifests[key] = item
+			continue
+		}
+
+		out[key] = item[ddbData].B
+	}
+
+	if len(manifests) > 0 {
+		// the values were split into chunks
+		values, err := r.getChunkedMulti(ctx, manifests)
+		if err != nil {
+			return nil, err
+		}
+
+		for key, bytes := range values {
+			out[key] = bytes
+		}
+	}
+
+	return out, nil
+}
+
+// get the items for the keys (that exist and have not expired) in batches of at most ddbBatchGetSize keys
+func (r *DynamoDbStorage) getItems(ctx context.Context, keys []string) (map[string]map[string]*dynamodb.AttributeValue, error) {
+	out := make(map[string]map[string]*dynamodb.AttributeValue, len(keys))
+
+	err := ddbBatches(len(keys), ddbBatchGetSize, func(start int, end int) error {
+		items, err := r.batchGet(ctx, keys[start:end])
+		if err != nil {
+			return err
+		}
+
+		for key, item := range items {
+			out[key] = item
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// get a single batch of (at most ddbBatchGetSize) keys; unprocessed keys are retried
+func (r *DynamoDbStorage) batchGet(ctx context.Context, keys []string) (items map[string]map[string]*dynamodb.AttributeValue, err error) {
+	ctx, span := startStorageSpan(ctx, r.TracerProvider, "dynamodb", "BatchGetItem", attrCacheKeys.Int(len(keys)))
+	defer func() {
+		size := 0
+		for _, item := range items {
+			if data := item[ddbData]; data != nil {
+				size += len(data.B)
+			}
+		}
+
+		endSpan(span, size, err)
+	}()
+
+	resultCh := make(chan map[string]map[string]*dynamodb.AttributeValue, 1)
+	errorCh := goWithBreaker(ctx, r.getBreaker(), func() error {
+		requestKeys := make([]map[string]*dynamodb.AttributeValue, len(keys))
+		for index, key := range keys {
+			requestKeys[index] = map[string]*dynamodb.AttributeValue{
 				ddbKey: {
 					S: aws.String(key),
 				},
+			}
+		}
+
+		request := map[string]*dynamodb.KeysAndAttributes{
+			r.TableName: {
+				Keys:            requestKeys,
+				AttributesToGet: aws.StringSlice(append([]string{ddbKey}, ddbItemAttributes...)),
 			},
-			TableName: aws.String(r.TableName),
 		}
 
-		_, err := r.Service.DeleteItemWithContext(ctx, params)
-		return err
-	}, nil)
+		now := time.Now()
+		out := make(map[string]map[string]*dynamodb.AttributeValue, len(keys))
+		err := ddbBatchRetry(ctx, func() (bool, error) {
+			resp, err := r.Service.BatchGetItemWithContext(ctx, &dynamodb.BatchGetItemInput{
+				RequestItems: request,
+			})
+			if err != nil {
+				return false, err
+			}
+
+			for _, item := range resp.Responses[r.TableName] {
+				if ddbIsExpired(item[ddbTTL], now) {
+					continue
+				}
+
+				out[aws.StringValue(item[ddbKey].S)] = item
+			}
+
+			request = resp.UnprocessedKeys
+			return len(request) == 0, nil
+		})
+		if err != nil {
+			return err
+		}
+
+		resultCh <- out
+		return nil
+	})
+
+	select {
+	case result := <-resultCh:
+		// success
+		return result, nil
+
+	case <-ctx.Done():
+		// timeout/context cancelled
+		return nil, ctx.Err()
+
+	case err := <-errorCh:
+		// failure
+		return nil, err
+	}
+}
+
+// SetMulti implements MultiStorage using BatchWriteItem (or PutItem per item when Versioned is set)
+func (r *DynamoDbStorage) SetMulti(ctx context.Context, items map[string][]byte) error {
+	if r.Versioned {
+		for key, bytes := range items {
+			err := r.put(ctx, key, bytes, r.TTL)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	expiry := time.Now().Add(r.TTL)
+
+	requests := make([]*dynamodb.WriteRequest, 0, len(items))
+	for key, bytes := range items {
+		if len(bytes) > r.getChunkSize() {
+			// large values are split into chunks
+			_, err := r.putChunked(ctx, key, bytes, r.TTL, false)
+			if err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		requests = append(requests, &dynamodb.WriteRequest{
+			PutRequest: &dynamodb.PutRequest{
+				Item: r.newItem(key, bytes, expiry),
+			},
+		})
+	}
+
+	return r.batchWriteAll(ctx, requests)
+}
+
+// write the requests in batches of at most ddbBatchWriteSize requests
+func (r *DynamoDbStorage) batchWriteAll(ctx context.Context, requests []*dynamodb.WriteRequest) error {
+	return ddbBatches(len(requests), ddbBatchWriteSize, func(start int, end int) error {
+		return r.batchWrite(ctx, requests[start:end])
+	})
+}
+
+// write a single batch of (at most ddbBatchWriteSize) requests; unprocessed requests are retried
+func (r *DynamoDbStorage) batchWrite(ctx context.Context, requests []*dynamodb.WriteRequest) (err error) {
+	ctx, span := startStorageSpan(ctx, r.TracerProvider, "dynamodb", "BatchWriteItem", attrCacheKeys.Int(len(requests)))
+	defer func() {
+		endSpan(span, 0, err)
+	}()
+
+	resultCh := make(chan struct{}, 1)
+	errorCh := goWithBreaker(ctx, r.getBreaker(), func() error {
+		request := map[string][]*dynamodb.WriteRequest{
+			r.TableName: requests,
+		}
+
+		err := ddbBatchRetry(ctx, func() (bool, error) {
+			resp, err := r.Service.BatchWriteItemWithContext(ctx, &dynamodb.BatchWriteItemInput{
+				RequestItems: request,
+			})
+			if err != nil {
+				return false, err
+			}
+
+			request = resp.UnprocessedItems
+			return len(request) == 0, nil
+		})
+		if err != nil {
+			return err
+		}
+
+		resultCh <- struct{}{}
+		return nil
+	})
 
 	select {
 	case <-resultCh:
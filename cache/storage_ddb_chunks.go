@@ -0,0 +1,146 @@
+// Copyright 2017 Corey Scott http://www.sage42.org/
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func (r *DynamoDbStorage) getChunkSize() int {
+	return ddbChunkSize(r.ChunkSize)
+}
+
+// save the value as chunk items followed by the manifest item (under the key); onlyIfAbsent is passed to putItem.
+//
+// The chunks are saved first, so the value only becomes visible (and replaces any previous value) when the manifest
+// is saved.  Chunks of replaced values expire with their TTL.
+func (r *DynamoDbStorage) putChunked(ctx context.Context, key string, bytes []byte, ttl time.Duration, onlyIfAbsent bool) (bool, error) {
+	expiry := time.Now().Add(ttl)
+	manifest, chunks := ddbPlanChunks(bytes, r.getChunkSize())
+
+	requests := make([]*dynamodb.WriteRequest, 0, len(chunks))
+	for index, chunkKey := range manifest.chunkKeys(key) {
+		requests = append(requests, &dynamodb.WriteRequest{
+			PutRequest: &dynamodb.PutRequest{
+				Item: r.newItem(chunkKey, chunks[index], expiry),
+			},
+		})
+	}
+
+	err := r.batchWriteAll(ctx, requests)
+	if err != nil {
+		return false, err
+	}
+
+	item := map[string]*dynamodb.AttributeValue{
+		ddbKey: {
+			S: aws.String(key),
+		},
+		ddbTTL: {
+			N: aws.String(strconv.FormatInt(expiry.Unix(), 10)),
+		},
+		ddbChunks: {
+			N: aws.String(manifest.chunks),
+		},
+		ddbChecksum: {
+			B: manifest.checksum,
+		},
+		ddbGeneration: {
+			S: aws.String(manifest.generation),
+		},
+	}
+
+	return r.putItem(ctx, item, len(bytes), onlyIfAbsent)
+}
+
+// reassemble the value described by the manifest item; returns ErrCacheMiss when it is incomplete or corrupt
+func (r *DynamoDbStorage) getChunked(ctx context.Context, key string, item map[string]*dynamodb.AttributeValue) ([]byte, error) {
+	return ddbGetChunked(ctx, key, ddbManifestOf(item), r.getData)
+}
+
+// reassemble the values described by the manifest items (by key); values that are incomplete or corrupt are omitted
+func (r *DynamoDbStorage) getChunkedMulti(ctx context.Context, items map[string]map[string]*dynamodb.AttributeValue) (map[string][]byte, error) {
+	manifests := make(map[string]ddbManifest, len(items))
+	for key, item := range items {
+		manifests[key] = ddbManifestOf(item)
+	}
+
+	return ddbGetChunkedMulti(ctx, manifests, r.getData)
+}
+
+// return the data of the items for the keys (that exist and have not expired)
+func (r *DynamoDbStorage) getData(ctx context.Context, keys []string) (map[string][]byte, error) {
+	items, err := r.getItems(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(map[string][]byte, len(items))
+	for key, item := range items {
+		out[key] = ddbBytes(item[ddbData])
+	}
+
+	return out, nil
+}
+
+// delete the chunks of the (already deleted) manifest item
+func (r *DynamoDbStorage) deleteChunks(ctx context.Context, key string, item map[string]*dynamodb.AttributeValue) error {
+	chunkKeys := ddbManifestOf(item).chunkKeys(key)
+
+	requests := make([]*dynamodb.WriteRequest, 0, len(chunkKeys))
+	for _, chunkKey := range chunkKeys {
+		requests = append(requests, &dynamodb.WriteRequest{
+			DeleteRequest: &dynamodb.DeleteRequest{
+				Key: map[string]*dynamodb.AttributeValue{
+					ddbKey: {
+						S: aws.String(chunkKey),
+					},
+				},
+			},
+		})
+	}
+
+	return r.batchWriteAll(ctx, requests)
+}
+
+// return the manifest saved in the item
+func ddbManifestOf(item map[string]*dynamodb.AttributeValue) ddbManifest {
+	manifest := ddbManifest{}
+	if chunks := item[ddbChunks]; chunks != nil {
+		manifest.chunks = aws.StringValue(chunks.N)
+	}
+
+	if generation := item[ddbGeneration]; generation != nil {
+		manifest.generation = aws.StringValue(generation.S)
+	}
+
+	manifest.checksum = ddbBytes(item[ddbChecksum])
+
+	return manifest
+}
+
+// return the value of a binary attribute (or nil when it is not set)
+func ddbBytes(value *dynamodb.AttributeValue) []byte {
+	if value == nil {
+		return nil
+	}
+
+	return value.B
+}
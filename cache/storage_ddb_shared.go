@@ -0,0 +1,291 @@
+// Copyright 2017 Corey Scott http://www.sage42.org/
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	mathrand "math/rand"
+	"strconv"
+	"time"
+)
+
+// largest value saved as a single item; DynamoDB limits items (including the attribute names) to 400KB
+const defaultDdbChunkSize = 350 * 1024
+
+// attributes read by Get and GetMulti
+var ddbItemAttributes = []string{ddbData, ddbTTL, ddbChunks, ddbChecksum, ddbGeneration}
+
+// return the chunk size (or the default when it is not set)
+func ddbChunkSize(chunkSize int) int {
+	if chunkSize > 0 {
+		return chunkSize
+	}
+
+	return defaultDdbChunkSize
+}
+
+// returns true when the (epoch seconds) ttl has passed; items without a valid ttl never expire
+func ddbIsExpiredAt(ttl string, now time.Time) bool {
+	timestamp, err := strconv.ParseInt(ttl, 10, 64)
+	if err != nil {
+		return false
+	}
+
+	return timestamp < now.Unix()
+}
+
+// ddbCondition is the condition of a conditional put; all values are numbers
+type ddbCondition struct {
+	expression string
+	names      map[string]string
+	values     map[string]string
+}
+
+// return the condition of a put; returns false when the put is unconditional.
+//
+// When onlyIfAbsent is set, the item is only saved when the key does not exist (or has expired).  Otherwise, when
+// versioned is set, the item is only saved when it is not older than the existing item.
+func ddbPutCondition(onlyIfAbsent bool, versioned bool, version int64, now time.Time) (ddbCondition, bool) {
+	switch {
+	case onlyIfAbsent:
+		return ddbCondition{
+			expression: ddbAddCondition,
+			names: map[string]string{
+				"#key": ddbKey,
+				"#ttl": ddbTTL,
+			},
+			values: map[string]string{
+				":now": strconv.FormatInt(now.Unix(), 10),
+			},
+		}, true
+
+	case versioned:
+		return ddbCondition{
+			expression: ddbVersionCondition,
+			names: map[string]string{
+				"#version": ddbVersion,
+				"#ttl":     ddbTTL,
+			},
+			values: map[string]string{
+				":version": strconv.FormatInt(version, 10),
+				":now":     strconv.FormatInt(now.Unix(), 10),
+			},
+		}, true
+
+	default:
+		return ddbCondition{}, false
+	}
+}
+
+// call fn with the bounds of each batch (of at most size items) of the count items; stops at the first error
+func ddbBatches(count int, size int, fn func(start int, end int) error) error {
+	for start := 0; start < count; start += size {
+		end := start + size
+		if end > count {
+			end = count
+		}
+
+		err := fn(start, end)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// call the batch operation until it reports that no items are left unprocessed, backing off between attempts;
+// returns errBatchIncomplete when items are still unprocessed after ddbBatchMaxAttempts
+func ddbBatchRetry(ctx context.Context, call func() (done bool, err error)) error {
+	for attempt := 0; attempt < ddbBatchMaxAttempts; attempt++ {
+		err := ddbBatchBackoff(ctx, attempt)
+		if err != nil {
+			return err
+		}
+
+		done, err := call()
+		if err != nil {
+			return err
+		}
+
+		if done {
+			return nil
+		}
+	}
+
+	return errBatchIncomplete
+}
+
+// wait before the supplied (retry) attempt of a batch call; the delay grows exponentially with each attempt and is
+// randomized so that throttled callers do not retry in lockstep.  Returns early when the context is done.
+func ddbBatchBackoff(ctx context.Context, attempt int) error {
+	if attempt <= 0 {
+		return nil
+	}
+
+	maxDelay := ddbBatchBaseDelay << uint(attempt-1)
+	if maxDelay > ddbBatchMaxDelay {
+		maxDelay = ddbBatchMaxDelay
+	}
+
+	timer := time.NewTimer(time.Duration(mathrand.Int63n(int64(maxDelay))) + 1)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ddbManifest describes a value that was split into chunks; it is saved under the value's key in place of the data.
+//
+// The chunks of each write have keys that are unique to the write (see generation), so readers never see a mix of old
+// and new chunks and the value only becomes visible (replacing any previous value) when the manifest is saved.
+type ddbManifest struct {
+	// the number of chunks (as saved in the item)
+	chunks string
+
+	// random identifier of the write that saved the chunks
+	generation string
+
+	// SHA-256 of the value
+	checksum []byte
+}
+
+// returns true when the item is the manifest of a value that was split into chunks
+func ddbIsManifest[V any](item map[string]V) bool {
+	_, found := item[ddbChunks]
+	return found
+}
+
+// split the value into chunks of (at most) chunkSize bytes and return them with their manifest
+func ddbPlanChunks(value []byte, chunkSize int) (ddbManifest, [][]byte) {
+	chunks := make([][]byte, 0, len(value)/chunkSize+1)
+	for start := 0; start < len(value); start += chunkSize {
+		end := start + chunkSize
+		if end > len(value) {
+			end = len(value)
+		}
+
+		chunks = append(chunks, value[start:end])
+	}
+
+	checksum := sha256.Sum256(value)
+
+	return ddbManifest{
+		chunks:     strconv.Itoa(len(chunks)),
+		generation: newDdbGeneration(),
+		checksum:   checksum[:],
+	}, chunks
+}
+
+// return the keys of the chunks (in order); returns nil when the manifest is invalid
+func (m ddbManifest) chunkKeys(key string) []string {
+	count, err := strconv.Atoi(m.chunks)
+	if err != nil || count <= 0 {
+		return nil
+	}
+
+	out := make([]string, 0, count)
+	for index := 0; index < count; index++ {
+		out = append(out, key+ddbChunkKeySeparator+m.generation+":"+strconv.Itoa(index))
+	}
+
+	return out
+}
+
+// join the data of the chunks (by chunk key) in order and verify the result against the checksum; returns false when
+// a chunk is missing (e.g. expired or deleted) or the checksum does not match
+func (m ddbManifest) join(key string, data map[string][]byte) ([]byte, bool) {
+	keys := m.chunkKeys(key)
+	if len(keys) == 0 || m.checksum == nil {
+		return nil, false
+	}
+
+	buffer := &bytes.Buffer{}
+	for _, chunkKey := range keys {
+		chunk := data[chunkKey]
+		if chunk == nil {
+			return nil, false
+		}
+
+		buffer.Write(chunk)
+	}
+
+	sum := sha256.Sum256(buffer.Bytes())
+	if !bytes.Equal(sum[:], m.checksum) {
+		return nil, false
+	}
+
+	return buffer.Bytes(), true
+}
+
+// reassemble the value described by the manifest using getData to read the chunks; returns ErrCacheMiss when it is
+// incomplete or corrupt
+func ddbGetChunked(ctx context.Context, key string, manifest ddbManifest, getData ddbDataGetter) ([]byte, error) {
+	values, err := ddbGetChunkedMulti(ctx, map[string]ddbManifest{key: manifest}, getData)
+	if err != nil {
+		return nil, err
+	}
+
+	value, found := values[key]
+	if !found {
+		return nil, ErrCacheMiss
+	}
+
+	return value, nil
+}
+
+// reassemble the values described by the manifests (by key) using getData to read the chunks; values that are
+// incomplete or corrupt are omitted
+func ddbGetChunkedMulti(ctx context.Context, manifests map[string]ddbManifest, getData ddbDataGetter) (map[string][]byte, error) {
+	var chunkKeys []string
+	for key, manifest := range manifests {
+		chunkKeys = append(chunkKeys, manifest.chunkKeys(key)...)
+	}
+
+	data, err := getData(ctx, chunkKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(map[string][]byte, len(manifests))
+	for key, manifest := range manifests {
+		value, ok := manifest.join(key, data)
+		if ok {
+			out[key] = value
+		}
+	}
+
+	return out, nil
+}
+
+// returns the data of the items (by key) that exist and have not expired
+type ddbDataGetter func(ctx context.Context, keys []string) (map[string][]byte, error)
+
+// return a random identifier for the chunks of a single write
+func newDdbGeneration() string {
+	generation := make([]byte, 8)
+	_, _ = rand.Read(generation)
+
+	return hex.EncodeToString(generation)
+}
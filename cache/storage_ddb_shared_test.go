@@ -0,0 +1,179 @@
+// Copyright 2017 Corey Scott http://www.sage42.org/
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDdbPutCondition(t *testing.T) {
+	now := time.Unix(1000, 0)
+
+	scenarios := []struct {
+		desc         string
+		onlyIfAbsent bool
+		versioned    bool
+		expected     ddbCondition
+		expectedOK   bool
+	}{
+		{
+			desc:       "unconditional",
+			expectedOK: false,
+		},
+		{
+			desc:         "only if absent",
+			onlyIfAbsent: true,
+			versioned:    true,
+			expected: ddbCondition{
+				expression: ddbAddCondition,
+				names:      map[string]string{"#key": ddbKey, "#ttl": ddbTTL},
+				values:     map[string]string{":now": "1000"},
+			},
+			expectedOK: true,
+		},
+		{
+			desc:      "versioned",
+			versioned: true,
+			expected: ddbCondition{
+				expression: ddbVersionCondition,
+				names:      map[string]string{"#version": ddbVersion, "#ttl": ddbTTL},
+				values:     map[string]string{":version": "3", ":now": "1000"},
+			},
+			expectedOK: true,
+		},
+	}
+
+	for _, s := range scenarios {
+		scenario := s
+		t.Run(scenario.desc, func(t *testing.T) {
+			result, resultOK := ddbPutCondition(scenario.onlyIfAbsent, scenario.versioned, 3, now)
+			assert.Equal(t, scenario.expectedOK, resultOK)
+			assert.Equal(t, scenario.expected, result)
+		})
+	}
+}
+
+func TestDdbBatches(t *testing.T) {
+	var batches [][2]int
+	resultErr := ddbBatches(7, 3, func(start int, end int) error {
+		batches = append(batches, [2]int{start, end})
+		return nil
+	})
+
+	assert.Nil(t, resultErr)
+	assert.Equal(t, [][2]int{{0, 3}, {3, 6}, {6, 7}}, batches)
+}
+
+func TestDdbBatchRetry(t *testing.T) {
+	// retried until done
+	calls := 0
+	resultErr := ddbBatchRetry(context.Background(), func() (bool, error) {
+		calls++
+		return calls == 2, nil
+	})
+	assert.Nil(t, resultErr)
+	assert.Equal(t, 2, calls)
+
+	// errors are returned at once
+	calls = 0
+	resultErr = ddbBatchRetry(context.Background(), func() (bool, error) {
+		calls++
+		return false, errors.New("failed")
+	})
+	assert.EqualError(t, resultErr, "failed")
+	assert.Equal(t, 1, calls)
+
+	// incomplete after the max attempts
+	calls = 0
+	resultErr = ddbBatchRetry(context.Background(), func() (bool, error) {
+		calls++
+		return false, nil
+	})
+	assert.Equal(t, errBatchIncomplete, resultErr)
+	assert.Equal(t, ddbBatchMaxAttempts, calls)
+
+	// stops early when the context is done
+	ctx, cancelFn := context.WithCancel(context.Background())
+	cancelFn()
+
+	calls = 0
+	resultErr = ddbBatchRetry(ctx, func() (bool, error) {
+		calls++
+		return false, nil
+	})
+	assert.Equal(t, context.Canceled, resultErr)
+	assert.Equal(t, 1, calls)
+}
+
+func TestDdbBatchBackoff(t *testing.T) {
+	// the first attempt is not delayed
+	start := time.Now()
+	assert.Nil(t, ddbBatchBackoff(context.Background(), 0))
+	assert.True(t, time.Since(start) < ddbBatchBaseDelay)
+
+	// retries are delayed by at most the max delay of the attempt
+	start = time.Now()
+	assert.Nil(t, ddbBatchBackoff(context.Background(), 1))
+	assert.True(t, time.Since(start) <= ddbBatchBaseDelay+20*time.Millisecond)
+
+	// and return early when the context is done
+	ctx, cancelFn := context.WithCancel(context.Background())
+	cancelFn()
+
+	start = time.Now()
+	assert.Equal(t, context.Canceled, ddbBatchBackoff(ctx, ddbBatchMaxAttempts))
+	assert.True(t, time.Since(start) < ddbBatchMaxDelay)
+}
+
+func TestDdbManifest(t *testing.T) {
+	value := []byte(`this value is split into chunks`)
+
+	manifest, chunks := ddbPlanChunks(value, 10)
+	assert.Equal(t, "4", manifest.chunks)
+	assert.Equal(t, 4, len(chunks))
+
+	keys := manifest.chunkKeys("foo")
+	assert.Equal(t, 4, len(keys))
+
+	data := map[string][]byte{}
+	for index, key := range keys {
+		data[key] = chunks[index]
+	}
+
+	// make the call
+	result, resultOK := manifest.join("foo", data)
+
+	// validate
+	assert.True(t, resultOK)
+	assert.Equal(t, value, result)
+
+	// corrupt chunks are detected
+	data[keys[1]] = []byte(`corrupted!`)
+	_, resultOK = manifest.join("foo", data)
+	assert.False(t, resultOK)
+
+	// as are missing chunks
+	delete(data, keys[1])
+	_, resultOK = manifest.join("foo", data)
+	assert.False(t, resultOK)
+
+	// and invalid manifests
+	assert.Nil(t, ddbManifest{chunks: "x"}.chunkKeys("foo"))
+}
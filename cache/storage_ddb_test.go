@@ -16,14 +16,20 @@ package cache
 
 import (
 	"context"
+	"fmt"
 	"log"
+	"strconv"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
 	"github.com/corsc/go-commons/testing/skip"
 	"github.com/stretchr/testify/assert"
 )
@@ -36,6 +42,14 @@ func TestDynamoDbStorage_implements(t *testing.T) {
 	assert.Implements(t, (*Storage)(nil), &DynamoDbStorage{})
 }
 
+func TestDynamoDbStorage_Add(t *testing.T) {
+	testAddStorage(t, &DynamoDbStorage{
+		Service:   newFakeDynamoDb(),
+		TableName: "cachetest",
+		TTL:       60 * time.Second,
+	})
+}
+
 func TestDynamoDbStorage_happyPath(t *testing.T) {
 	skip.IfNotSet(t, DDBTestFlag)
 
@@ -125,6 +139,293 @@ func TestDynamoDbStorage_setWithCtxDone(t *testing.T) {
 	assert.Equal(t, context.Canceled, resultErr)
 }
 
+func TestDynamoDbStorage_implementsMulti(t *testing.T) {
+	assert.Implements(t, (*MultiStorage)(nil), &DynamoDbStorage{})
+}
+
+func TestDynamoDbStorage_multi(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+
+	// more than 1 batch of each type and with some unprocessed items
+	items := map[string][]byte{}
+	var keys []string
+	for x := 0; x < 120; x++ {
+		key := fmt.Sprintf("%s.%d", getTestKey(), x)
+
+		keys = append(keys, key)
+		items[key] = []byte(key)
+	}
+	missingKey := getTestKey() + ".missing"
+
+	service := newFakeDynamoDb()
+	service.unprocessedOnce = true
+
+	storage := &DynamoDbStorage{
+		Service:   service,
+		TableName: "cachetest",
+		TTL:       60 * time.Second,
+	}
+
+	// set the values
+	resultErr := storage.SetMulti(ctx, items)
+	assert.Nil(t, resultErr)
+
+	// get the values
+	result, resultErr := storage.GetMulti(ctx, append(keys, missingKey))
+	assert.Nil(t, resultErr)
+	assert.Equal(t, items, result)
+}
+
+func TestDynamoDbStorage_multiIncomplete(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	key := getTestKey()
+
+	service := newFakeDynamoDb()
+	service.unprocessedAlways = true
+
+	storage := &DynamoDbStorage{
+		Service:   service,
+		TableName: "cachetest",
+		TTL:       60 * time.Second,
+	}
+
+	// set the values
+	resultErr := storage.SetMulti(ctx, map[string][]byte{key: []byte(`this is foo`)})
+	assert.Equal(t, errBatchIncomplete, resultErr)
+
+	// get the values
+	result, resultErr := storage.GetMulti(ctx, []string{key})
+	assert.Nil(t, result)
+	assert.Equal(t, errBatchIncomplete, resultErr)
+}
+
+func TestDynamoDbStorage_SetWithTTL(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	key := getTestKey()
+
+	service := newFakeDynamoDb()
+	storage := &DynamoDbStorage{
+		Service:   service,
+		TableName: "cachetest",
+		TTL:       60 * time.Second,
+	}
+
+	// set a value
+	resultErr := storage.SetWithTTL(ctx, key, []byte(`this is foo`), 1*time.Hour)
+	assert.Nil(t, resultErr)
+
+	// validate the TTL attribute
+	expected := time.Now().Add(1 * time.Hour).Unix()
+	ttl, err := strconv.ParseInt(aws.StringValue(service.items[key][ddbTTL].N), 10, 64)
+	assert.Nil(t, err)
+	assert.InDelta(t, expected, ttl, 1)
+}
+
+func TestDynamoDbStorage_expired(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+
+	service := newFakeDynamoDb()
+	storage := &DynamoDbStorage{
+		Service:   service,
+		TableName: "cachetest",
+		TTL:       60 * time.Second,
+	}
+
+	// items that have expired but not yet been deleted by DynamoDB
+	service.items["expired"] = storage.newItem("expired", []byte(`this is old`), time.Now().Add(-1*time.Hour))
+	service.items["current"] = storage.newItem("current", []byte(`this is new`), time.Now().Add(1*time.Hour))
+
+	// get the values
+	result, resultErr := storage.Get(ctx, "expired")
+	assert.Nil(t, result)
+	assert.Equal(t, ErrCacheMiss, resultErr)
+
+	result, resultErr = storage.Get(ctx, "current")
+	assert.Nil(t, resultErr)
+	assert.Equal(t, []byte(`this is new`), result)
+
+	results, resultErr := storage.GetMulti(ctx, []string{"expired", "current"})
+	assert.Nil(t, resultErr)
+	assert.Equal(t, map[string][]byte{"current": []byte(`this is new`)}, results)
+}
+
+func TestDynamoDbStorage_Versioned(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+
+	service := newFakeDynamoDb()
+	storage := &DynamoDbStorage{
+		Service:   service,
+		TableName: "cachetest",
+		TTL:       60 * time.Second,
+		Versioned: true,
+	}
+
+	// set a value
+	resultErr := storage.Set(WithVersion(ctx, 2), "foo", []byte(`version 2`))
+	assert.Nil(t, resultErr)
+
+	// older versions are discarded
+	resultErr = storage.Set(WithVersion(ctx, 1), "foo", []byte(`version 1`))
+	assert.Nil(t, resultErr)
+
+	resultErr = storage.SetMulti(WithVersion(ctx, 1), map[string][]byte{"foo": []byte(`version 1`)})
+	assert.Nil(t, resultErr)
+
+	result, resultErr := storage.Get(ctx, "foo")
+	assert.Nil(t, resultErr)
+	assert.Equal(t, []byte(`version 2`), result)
+
+	// newer versions replace the value
+	resultErr = storage.SetMulti(WithVersion(ctx, 3), map[string][]byte{"foo": []byte(`version 3`)})
+	assert.Nil(t, resultErr)
+
+	result, resultErr = storage.Get(ctx, "foo")
+	assert.Nil(t, resultErr)
+	assert.Equal(t, []byte(`version 3`), result)
+
+	// expired items are replaced regardless of version
+	resultErr = storage.SetWithTTL(WithVersion(ctx, 4), "foo", []byte(`version 4`), -1*time.Hour)
+	assert.Nil(t, resultErr)
+
+	resultErr = storage.Set(WithVersion(ctx, 1), "foo", []byte(`version 1`))
+	assert.Nil(t, resultErr)
+
+	result, resultErr = storage.Get(ctx, "foo")
+	assert.Nil(t, resultErr)
+	assert.Equal(t, []byte(`version 1`), result)
+}
+
+func TestDynamoDbStorage_chunks(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+
+	service := newFakeDynamoDb()
+	storage := &DynamoDbStorage{
+		Service:   service,
+		TableName: "cachetest",
+		TTL:       60 * time.Second,
+		ChunkSize: 4,
+	}
+
+	items := map[string][]byte{
+		"large":       []byte(`this value is split into chunks`),
+		"exact":       []byte(`abcd`),
+		"also-large":  []byte(`so is this one`),
+		"not-chunked": []byte(`abc`),
+	}
+
+	// set a value
+	resultErr := storage.Set(ctx, "foo", items["large"])
+	assert.Nil(t, resultErr)
+
+	// 8 chunks + the manifest
+	assert.Len(t, service.items, 9)
+
+	// get a value
+	result, resultErr := storage.Get(ctx, "foo")
+	assert.Nil(t, resultErr)
+	assert.Equal(t, items["large"], result)
+
+	// replace the value
+	resultErr = storage.Set(ctx, "foo", []byte(`something else`))
+	assert.Nil(t, resultErr)
+
+	result, resultErr = storage.Get(ctx, "foo")
+	assert.Nil(t, resultErr)
+	assert.Equal(t, []byte(`something else`), result)
+
+	// invalidate removes the manifest and its chunks (the chunks of the replaced value expire with their TTL)
+	before := len(service.items)
+
+	resultErr = storage.Invalidate(ctx, "foo")
+	assert.Nil(t, resultErr)
+	assert.Len(t, service.items, before-5)
+
+	result, resultErr = storage.Get(ctx, "foo")
+	assert.Nil(t, result)
+	assert.Equal(t, ErrCacheMiss, resultErr)
+
+	// multi
+	resultErr = storage.SetMulti(ctx, items)
+	assert.Nil(t, resultErr)
+
+	results, resultErr := storage.GetMulti(ctx, []string{"large", "exact", "also-large", "not-chunked", "missing"})
+	assert.Nil(t, resultErr)
+	assert.Equal(t, items, results)
+}
+
+func TestDynamoDbStorage_chunksCorrupt(t *testing.T) {
+	scenarios := []struct {
+		desc    string
+		corrupt func(service *fakeDynamoDb, chunkKeys []string)
+	}{
+		{
+			desc: "missing chunk",
+			corrupt: func(service *fakeDynamoDb, chunkKeys []string) {
+				delete(service.items, chunkKeys[1])
+			},
+		},
+		{
+			desc: "expired chunk",
+			corrupt: func(service *fakeDynamoDb, chunkKeys []string) {
+				service.items[chunkKeys[1]][ddbTTL] = &dynamodb.AttributeValue{
+					N: aws.String(strconv.FormatInt(time.Now().Add(-1*time.Minute).Unix(), 10)),
+				}
+			},
+		},
+		{
+			desc: "checksum mismatch",
+			corrupt: func(service *fakeDynamoDb, chunkKeys []string) {
+				service.items[chunkKeys[1]][ddbData] = &dynamodb.AttributeValue{
+					B: []byte(`oops`),
+				}
+			},
+		},
+	}
+
+	for _, s := range scenarios {
+		scenario := s
+		t.Run(scenario.desc, func(t *testing.T) {
+			// inputs
+			ctx, cancelFn := context.WithCancel(context.Background())
+			defer cancelFn()
+
+			service := newFakeDynamoDb()
+			storage := &DynamoDbStorage{
+				Service:   service,
+				TableName: "cachetest",
+				TTL:       60 * time.Second,
+				ChunkSize: 4,
+			}
+
+			resultErr := storage.Set(ctx, "foo", []byte(`this value is split into chunks`))
+			assert.Nil(t, resultErr)
+
+			scenario.corrupt(service, ddbManifestOf(service.items["foo"]).chunkKeys("foo"))
+
+			// validate
+			result, resultErr := storage.Get(ctx, "foo")
+			assert.Nil(t, result)
+			assert.Equal(t, ErrCacheMiss, resultErr)
+
+			results, resultErr := storage.GetMulti(ctx, []string{"foo"})
+			assert.Nil(t, resultErr)
+			assert.Empty(t, results)
+		})
+	}
+}
+
 func getTestDynamoDbStorage() *DynamoDbStorage {
 	creds := credentials.NewStaticCredentials("123", "123", "")
 
@@ -203,3 +504,159 @@ func testTableExists(svc *dynamodb.DynamoDB, tableName string) bool {
 
 	return false
 }
+
+// in-memory stand-in for DynamoDB that supports the calls used by DynamoDbStorage
+type fakeDynamoDb struct {
+	dynamodbiface.DynamoDBAPI
+
+	// when set, the first batch call of each type will only process the first item
+	unprocessedOnce bool
+
+	// when set, batch calls will never process any items
+	unprocessedAlways bool
+
+	mutex            sync.Mutex
+	items            map[string]map[string]*dynamodb.AttributeValue
+	unprocessedGet   bool
+	unprocessedWrite bool
+}
+
+func newFakeDynamoDb() *fakeDynamoDb {
+	return &fakeDynamoDb{
+		items: map[string]map[string]*dynamodb.AttributeValue{},
+	}
+}
+
+func (f *fakeDynamoDb) GetItemWithContext(_ aws.Context, input *dynamodb.GetItemInput, _ ...request.Option) (*dynamodb.GetItemOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return &dynamodb.GetItemOutput{
+		Item: f.items[aws.StringValue(input.Key[ddbKey].S)],
+	}, nil
+}
+
+func (f *fakeDynamoDb) PutItemWithContext(_ aws.Context, input *dynamodb.PutItemInput, _ ...request.Option) (*dynamodb.PutItemOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	key := aws.StringValue(input.Item[ddbKey].S)
+
+	if aws.StringValue(input.ConditionExpression) == ddbVersionCondition {
+		existing, found := f.items[key]
+		if found && existing[ddbVersion] != nil &&
+			fakeDynamoDbNumber(existing[ddbVersion]) > fakeDynamoDbNumber(input.ExpressionAttributeValues[":version"]) &&
+			fakeDynamoDbNumber(existing[ddbTTL]) >= fakeDynamoDbNumber(input.ExpressionAttributeValues[":now"]) {
+			return nil, awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "the conditional request failed", nil)
+		}
+	}
+
+	if aws.StringValue(input.ConditionExpression) == ddbAddCondition {
+		existing, found := f.items[key]
+		if found && fakeDynamoDbNumber(existing[ddbTTL]) >= fakeDynamoDbNumber(input.ExpressionAttributeValues[":now"]) {
+			return nil, awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "the conditional request failed", nil)
+		}
+	}
+
+	f.items[key] = input.Item
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (f *fakeDynamoDb) DeleteItemWithContext(_ aws.Context, input *dynamodb.DeleteItemInput, _ ...request.Option) (*dynamodb.DeleteItemOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	key := aws.StringValue(input.Key[ddbKey].S)
+
+	out := &dynamodb.DeleteItemOutput{}
+	if aws.StringValue(input.ReturnValues) == dynamodb.ReturnValueAllOld {
+		out.Attributes = f.items[key]
+	}
+
+	delete(f.items, key)
+	return out, nil
+}
+
+func (f *fakeDynamoDb) BatchGetItemWithContext(_ aws.Context, input *dynamodb.BatchGetItemInput, _ ...request.Option) (*dynamodb.BatchGetItemOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	out := &dynamodb.BatchGetItemOutput{
+		Responses:       map[string][]map[string]*dynamodb.AttributeValue{},
+		UnprocessedKeys: map[string]*dynamodb.KeysAndAttributes{},
+	}
+
+	for table, request := range input.RequestItems {
+		if len(request.Keys) > ddbBatchGetSize {
+			return nil, fmt.Errorf("too many keys: %d", len(request.Keys))
+		}
+
+		keys := request.Keys
+		if f.unprocessedAlways || (f.unprocessedOnce && !f.unprocessedGet) {
+			f.unprocessedGet = true
+
+			processed := 1
+			if f.unprocessedAlways {
+				processed = 0
+			}
+
+			out.UnprocessedKeys[table] = &dynamodb.KeysAndAttributes{
+				Keys:            keys[processed:],
+				AttributesToGet: request.AttributesToGet,
+			}
+			keys = keys[:processed]
+		}
+
+		for _, key := range keys {
+			item, found := f.items[aws.StringValue(key[ddbKey].S)]
+			if found {
+				out.Responses[table] = append(out.Responses[table], item)
+			}
+		}
+	}
+
+	return out, nil
+}
+
+func (f *fakeDynamoDb) BatchWriteItemWithContext(_ aws.Context, input *dynamodb.BatchWriteItemInput, _ ...request.Option) (*dynamodb.BatchWriteItemOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	out := &dynamodb.BatchWriteItemOutput{
+		UnprocessedItems: map[string][]*dynamodb.WriteRequest{},
+	}
+
+	for table, requests := range input.RequestItems {
+		if len(requests) > ddbBatchWriteSize {
+			return nil, fmt.Errorf("too many requests: %d", len(requests))
+		}
+
+		if f.unprocessedAlways || (f.unprocessedOnce && !f.unprocessedWrite) {
+			f.unprocessedWrite = true
+
+			processed := 1
+			if f.unprocessedAlways {
+				processed = 0
+			}
+
+			out.UnprocessedItems[table] = requests[processed:]
+			requests = requests[:processed]
+		}
+
+		for _, request := range requests {
+			if request.PutRequest != nil {
+				f.items[aws.StringValue(request.PutRequest.Item[ddbKey].S)] = request.PutRequest.Item
+			}
+			if request.DeleteRequest != nil {
+				delete(f.items, aws.StringValue(request.DeleteRequest.Key[ddbKey].S))
+			}
+		}
+	}
+
+	return out, nil
+}
+
+func fakeDynamoDbNumber(value *dynamodb.AttributeValue) int64 {
+	number, _ := strconv.ParseInt(aws.StringValue(value.N), 10, 64)
+	return number
+}
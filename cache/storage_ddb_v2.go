@@ -0,0 +1,550 @@
+// Copyright 2017 Corey Scott http://www.sage42.org/
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// DynamoDbV2API is the subset of the AWS SDK for Go v2 DynamoDB client (`*dynamodb.Client`) used by DynamoDbV2Storage
+type DynamoDbV2API interface {
+	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+	DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error)
+	BatchGetItem(ctx context.Context, params *dynamodb.BatchGetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchGetItemOutput, error)
+	BatchWriteItem(ctx context.Context, params *dynamodb.BatchWriteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchWriteItemOutput, error)
+}
+
+// DynamoDbV2Storage implements Storage using the AWS SDK for Go v2; it uses the same table layout as DynamoDbStorage.
+//
+// DynamoDB can take up to 48 hours to delete expired items, so items whose `ttl` attribute has passed are treated as
+// cache misses by Get and GetMulti.
+//
+// Values larger than ChunkSize are split into chunks in the same way as DynamoDbStorage (see DynamoDbStorage), so
+// values saved by either storage can be read by the other.
+//
+// Calls are protected by a per instance circuit breaker (see Breaker).  To use a shared hystrix command instead, set
+// Breaker to `&hystrixbreaker.Breaker{Name: cache.CbDynamoDbStorage}` and configure the command with `hystrix.ConfigureCommand()`.
+type DynamoDbV2Storage struct {
+	// Service is the AWS DDB Client instance (e.g. `dynamodb.NewFromConfig(cfg)`)
+	Service DynamoDbV2API
+
+	// TableName is the AWS DDB Table name
+	TableName string
+
+	// TTL is the default TTL for cache items; it can be overridden per item with SetWithTTL (required)
+	TTL time.Duration
+
+	// ChunkSize is the size of the largest value that is saved as a single item; larger values are split into chunks
+	// of this size (optional - default 350KB)
+	ChunkSize int
+
+	// Versioned enables conditional puts that only replace an item with one of the same or a newer version (see
+	// WithVersion); puts of older versions are silently discarded.  SetMulti uses 1 PutItem per item when this is set as
+	// batch writes cannot be conditional (optional - default disabled)
+	Versioned bool
+
+	// TracerProvider enables OpenTelemetry spans for every DDB call (optional - default disabled)
+	TracerProvider trace.TracerProvider
+
+	// Breaker protects the calls to the server (optional - default is a NativeBreaker with its default settings).
+	// Use NoopBreaker to disable the circuit breaker.
+	Breaker CircuitBreaker
+
+	defaultBreaker     CircuitBreaker
+	defaultBreakerOnce sync.Once
+}
+
+// return the circuit breaker for this storage
+func (r *DynamoDbV2Storage) getBreaker() CircuitBreaker {
+	if r.Breaker != nil {
+		return r.Breaker
+	}
+
+	r.defaultBreakerOnce.Do(func() {
+		r.defaultBreaker = &NativeBreaker{}
+	})
+
+	return r.defaultBreaker
+}
+
+// Get implements Storage
+func (r *DynamoDbV2Storage) Get(ctx context.Context, key string) (bytes []byte, err error) {
+	ctx, span := startStorageSpan(ctx, r.TracerProvider, "dynamodb", "GetItem")
+	defer func() {
+		endSpan(span, len(bytes), err)
+	}()
+
+	resultCh := make(chan map[string]types.AttributeValue, 1)
+	errorCh := goWithBreaker(ctx, r.getBreaker(), func() error {
+		params := &dynamodb.GetItemInput{
+			Key:             ddbV2Key(key),
+			TableName:       aws.String(r.TableName),
+			AttributesToGet: ddbItemAttributes,
+		}
+
+		resp, err := r.Service.GetItem(ctx, params)
+		if err != nil {
+			return err
+		}
+
+		if len(resp.Item) == 0 || ddbV2IsExpired(resp.Item[ddbTTL], time.Now()) {
+			// cache miss (cannot be returned as error or the CB will track it)
+			resultCh <- nil
+			return nil
+		}
+
+		resultCh <- resp.Item
+		return nil
+	})
+
+	select {
+	case result := <-resultCh:
+		if result == nil {
+			return nil, ErrCacheMiss
+		}
+
+		if ddbIsManifest(result) {
+			// the value was split into chunks
+			return r.getChunked(ctx, key, result)
+		}
+
+		// success
+		return ddbV2Bytes(result[ddbData]), nil
+
+	case <-ctx.Done():
+		// timeout/context cancelled
+		return nil, ctx.Err()
+
+	case err := <-errorCh:
+		// failure
+		return nil, err
+	}
+}
+
+// Set implements Storage
+func (r *DynamoDbV2Storage) Set(ctx context.Context, key string, bytes []byte) error {
+	return r.put(ctx, key, bytes, r.TTL)
+}
+
+// SetWithTTL implements TTLStorage
+func (r *DynamoDbV2Storage) SetWithTTL(ctx context.Context, key string, bytes []byte, ttl time.Duration) error {
+	return r.put(ctx, key, bytes, ttl)
+}
+
+// save the value with the supplied TTL; values larger than the chunk size are split into chunks
+func (r *DynamoDbV2Storage) put(ctx context.Context, key string, bytes []byte, ttl time.Duration) error {
+	if len(bytes) > r.getChunkSize() {
+		_, err := r.putChunked(ctx, key, bytes, ttl, false)
+		return err
+	}
+
+	_, err := r.putItem(ctx, ddbV2NewItem(key, bytes, time.Now().Add(ttl)), len(bytes), false)
+	return err
+}
+
+// Add implements AddStorage using a conditional put; items that have expired (but not yet been deleted by DynamoDB)
+// are replaced
+func (r *DynamoDbV2Storage) Add(ctx context.Context, key string, bytes []byte, ttl time.Duration) (bool, error) {
+	if ttl <= 0 {
+		ttl = r.TTL
+	}
+
+	if len(bytes) > r.getChunkSize() {
+		return r.putChunked(ctx, key, bytes, ttl, true)
+	}
+
+	return r.putItem(ctx, ddbV2NewItem(key, bytes, time.Now().Add(ttl)), len(bytes), true)
+}
+
+// save the item; size is the size of the value.
+//
+// When onlyIfAbsent is set, the item is only saved when the key does not exist (or has expired) and false is returned
+// otherwise.  When Versioned is set, the item is only saved when it is not older than the existing item.
+func (r *DynamoDbV2Storage) putItem(ctx context.Context, item map[string]types.AttributeValue, size int, onlyIfAbsent bool) (saved bool, err error) {
+	ctx, span := startStorageSpan(ctx, r.TracerProvider, "dynamodb", "PutItem")
+	defer func() {
+		endSpan(span, size, err)
+	}()
+
+	version := versionOf(ctx)
+
+	resultCh := make(chan bool, 1)
+	errorCh := goWithBreaker(ctx, r.getBreaker(), func() error {
+		params := &dynamodb.PutItemInput{
+			Item:      item,
+			TableName: aws.String(r.TableName),
+		}
+
+		if r.Versioned {
+			params.Item[ddbVersion] = &types.AttributeValueMemberN{Value: strconv.FormatInt(version, 10)}
+		}
+
+		if condition, ok := ddbPutCondition(onlyIfAbsent, r.Versioned, version, time.Now()); ok {
+			params.ConditionExpression = aws.String(condition.expression)
+			params.ExpressionAttributeNames = condition.names
+			params.ExpressionAttributeValues = make(map[string]types.AttributeValue, len(condition.values))
+			for name, value := range condition.values {
+				params.ExpressionAttributeValues[name] = &types.AttributeValueMemberN{Value: value}
+			}
+		}
+
+		_, err := r.Service.PutItem(ctx, params)
+
+		var conditionErr *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionErr) {
+			// the key exists or a newer version has been saved
+			resultCh <- false
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		resultCh <- true
+		return nil
+	})
+
+	select {
+	case result := <-resultCh:
+		// success
+		return result, nil
+
+	case <-ctx.Done():
+		// timeout/context cancelled
+		return false, ctx.Err()
+
+	case err := <-errorCh:
+		// failure
+		return false, err
+	}
+}
+
+// Invalidate implements Storage.
+//
+// Values that were split into chunks are invalidated atomically by deleting the manifest; the chunks are deleted
+// afterwards (chunks that could not be deleted expire with their TTL).
+func (r *DynamoDbV2Storage) Invalidate(ctx context.Context, key string) (err error) {
+	ctx, span := startStorageSpan(ctx, r.TracerProvider, "dynamodb", "DeleteItem")
+	defer func() {
+		endSpan(span, 0, err)
+	}()
+
+	resultCh := make(chan map[string]types.AttributeValue, 1)
+	errorCh := goWithBreaker(ctx, r.getBreaker(), func() error {
+		params := &dynamodb.DeleteItemInput{
+			Key:          ddbV2Key(key),
+			TableName:    aws.String(r.TableName),
+			ReturnValues: types.ReturnValueAllOld,
+		}
+
+		resp, err := r.Service.DeleteItem(ctx, params)
+		if err != nil {
+			return err
+		}
+
+		resultCh <- resp.Attributes
+		return nil
+	})
+
+	select {
+	case result := <-resultCh:
+		if ddbIsManifest(result) {
+			_ = r.deleteChunks(ctx, key, result)
+		}
+
+		// success
+		return nil
+
+	case <-ctx.Done():
+		// timeout/context cancelled
+		return ctx.Err()
+
+	case err := <-errorCh:
+		// failure
+		return err
+	}
+}
+
+// GetMulti implements MultiStorage using BatchGetItem
+func (r *DynamoDbV2Storage) GetMulti(ctx context.Context, keys []string) (map[string][]byte, error) {
+	items, err := r.getItems(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(map[string][]byte, len(items))
+	manifests := map[string]map[string]types.AttributeValue{}
+	for key, item := range items {
+		if ddbIsManifest(item) {
+			manifests[key] = item
+			continue
+		}
+
+		out[key] = ddbV2Bytes(item[ddbData])
+	}
+
+	if len(manifests) > 0 {
+		// the values were split into chunks
+		values, err := r.getChunkedMulti(ctx, manifests)
+		if err != nil {
+			return nil, err
+		}
+
+		for key, bytes := range values {
+			out[key] = bytes
+		}
+	}
+
+	return out, nil
+}
+
+// get the items for the keys (that exist and have not expired) in batches of at most ddbBatchGetSize keys
+func (r *DynamoDbV2Storage) getItems(ctx context.Context, keys []string) (map[string]map[string]types.AttributeValue, error) {
+	out := make(map[string]map[string]types.AttributeValue, len(keys))
+
+	err := ddbBatches(len(keys), ddbBatchGetSize, func(start int, end int) error {
+		items, err := r.batchGet(ctx, keys[start:end])
+		if err != nil {
+			return err
+		}
+
+		for key, item := range items {
+			out[key] = item
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// get a single batch of (at most ddbBatchGetSize) keys; unprocessed keys are retried
+func (r *DynamoDbV2Storage) batchGet(ctx context.Context, keys []string) (items map[string]map[string]types.AttributeValue, err error) {
+	ctx, span := startStorageSpan(ctx, r.TracerProvider, "dynamodb", "BatchGetItem", attrCacheKeys.Int(len(keys)))
+	defer func() {
+		size := 0
+		for _, item := range items {
+			size += len(ddbV2Bytes(item[ddbData]))
+		}
+
+		endSpan(span, size, err)
+	}()
+
+	resultCh := make(chan map[string]map[string]types.AttributeValue, 1)
+	errorCh := goWithBreaker(ctx, r.getBreaker(), func() error {
+		requestKeys := make([]map[string]types.AttributeValue, len(keys))
+		for index, key := range keys {
+			requestKeys[index] = ddbV2Key(key)
+		}
+
+		request := map[string]types.KeysAndAttributes{
+			r.TableName: {
+				Keys:            requestKeys,
+				AttributesToGet: append([]string{ddbKey}, ddbItemAttributes...),
+			},
+		}
+
+		now := time.Now()
+		out := make(map[string]map[string]types.AttributeValue, len(keys))
+		err := ddbBatchRetry(ctx, func() (bool, error) {
+			resp, err := r.Service.BatchGetItem(ctx, &dynamodb.BatchGetItemInput{
+				RequestItems: request,
+			})
+			if err != nil {
+				return false, err
+			}
+
+			for _, item := range resp.Responses[r.TableName] {
+				if ddbV2IsExpired(item[ddbTTL], now) {
+					continue
+				}
+
+				out[ddbV2String(item[ddbKey])] = item
+			}
+
+			request = resp.UnprocessedKeys
+			return len(request) == 0, nil
+		})
+		if err != nil {
+			return err
+		}
+
+		resultCh <- out
+		return nil
+	})
+
+	select {
+	case result := <-resultCh:
+		// success
+		return result, nil
+
+	case <-ctx.Done():
+		// timeout/context cancelled
+		return nil, ctx.Err()
+
+	case err := <-errorCh:
+		// failure
+		return nil, err
+	}
+}
+
+// SetMulti implements MultiStorage using BatchWriteItem (or PutItem per item when Versioned is set)
+func (r *DynamoDbV2Storage) SetMulti(ctx context.Context, items map[string][]byte) error {
+	if r.Versioned {
+		for key, bytes := range items {
+			err := r.put(ctx, key, bytes, r.TTL)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	expiry := time.Now().Add(r.TTL)
+
+	requests := make([]types.WriteRequest, 0, len(items))
+	for key, bytes := range items {
+		if len(bytes) > r.getChunkSize() {
+			// large values are split into chunks
+			_, err := r.putChunked(ctx, key, bytes, r.TTL, false)
+			if err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		requests = append(requests, types.WriteRequest{
+			PutRequest: &types.PutRequest{
+				Item: ddbV2NewItem(key, bytes, expiry),
+			},
+		})
+	}
+
+	return r.batchWriteAll(ctx, requests)
+}
+
+// write the requests in batches of at most ddbBatchWriteSize requests
+func (r *DynamoDbV2Storage) batchWriteAll(ctx context.Context, requests []types.WriteRequest) error {
+	return ddbBatches(len(requests), ddbBatchWriteSize, func(start int, end int) error {
+		return r.batchWrite(ctx, requests[start:end])
+	})
+}
+
+// write a single batch of (at most ddbBatchWriteSize) requests; unprocessed requests are retried
+func (r *DynamoDbV2Storage) batchWrite(ctx context.Context, requests []types.WriteRequest) (err error) {
+	ctx, span := startStorageSpan(ctx, r.TracerProvider, "dynamodb", "BatchWriteItem", attrCacheKeys.Int(len(requests)))
+	defer func() {
+		endSpan(span, 0, err)
+	}()
+
+	resultCh := make(chan struct{}, 1)
+	errorCh := goWithBreaker(ctx, r.getBreaker(), func() error {
+		request := map[string][]types.WriteRequest{
+			r.TableName: requests,
+		}
+
+		err := ddbBatchRetry(ctx, func() (bool, error) {
+			resp, err := r.Service.BatchWriteItem(ctx, &dynamodb.BatchWriteItemInput{
+				RequestItems: request,
+			})
+			if err != nil {
+				return false, err
+			}
+
+			request = resp.UnprocessedItems
+			return len(request) == 0, nil
+		})
+		if err != nil {
+			return err
+		}
+
+		resultCh <- struct{}{}
+		return nil
+	})
+
+	select {
+	case <-resultCh:
+		// success
+		return nil
+
+	case <-ctx.Done():
+		// timeout/context cancelled
+		return ctx.Err()
+
+	case err := <-errorCh:
+		// failure
+		return err
+	}
+}
+
+// return the primary key of the item
+func ddbV2Key(key string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		ddbKey: &types.AttributeValueMemberS{Value: key},
+	}
+}
+
+// return the item that holds the value
+func ddbV2NewItem(key string, bytes []byte, expiry time.Time) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		ddbKey:  &types.AttributeValueMemberS{Value: key},
+		ddbData: &types.AttributeValueMemberB{Value: bytes},
+		ddbTTL:  &types.AttributeValueMemberN{Value: strconv.FormatInt(expiry.Unix(), 10)},
+	}
+}
+
+// returns true when the item's ttl attribute has passed
+func ddbV2IsExpired(ttl types.AttributeValue, now time.Time) bool {
+	number, ok := ttl.(*types.AttributeValueMemberN)
+	if !ok {
+		return false
+	}
+
+	return ddbIsExpiredAt(number.Value, now)
+}
+
+// return the value of a string attribute (or "" when it is not a string)
+func ddbV2String(value types.AttributeValue) string {
+	if typed, ok := value.(*types.AttributeValueMemberS); ok {
+		return typed.Value
+	}
+
+	return ""
+}
+
+// return the value of a binary attribute (or nil when it is not binary)
+func ddbV2Bytes(value types.AttributeValue) []byte {
+	if typed, ok := value.(*types.AttributeValueMemberB); ok {
+		return typed.Value
+	}
+
+	return nil
+}
@@ -0,0 +1,119 @@
+// Copyright 2017 Corey Scott http://www.sage42.org/
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func (r *DynamoDbV2Storage) getChunkSize() int {
+	return ddbChunkSize(r.ChunkSize)
+}
+
+// save the value as chunk items followed by the manifest item (under the key); onlyIfAbsent is passed to putItem.
+//
+// The layout is the same as DynamoDbStorage.putChunked, so values chunked by either storage can be read by both.
+func (r *DynamoDbV2Storage) putChunked(ctx context.Context, key string, bytes []byte, ttl time.Duration, onlyIfAbsent bool) (bool, error) {
+	expiry := time.Now().Add(ttl)
+	manifest, chunks := ddbPlanChunks(bytes, r.getChunkSize())
+
+	requests := make([]types.WriteRequest, 0, len(chunks))
+	for index, chunkKey := range manifest.chunkKeys(key) {
+		requests = append(requests, types.WriteRequest{
+			PutRequest: &types.PutRequest{
+				Item: ddbV2NewItem(chunkKey, chunks[index], expiry),
+			},
+		})
+	}
+
+	err := r.batchWriteAll(ctx, requests)
+	if err != nil {
+		return false, err
+	}
+
+	item := map[string]types.AttributeValue{
+		ddbKey:        &types.AttributeValueMemberS{Value: key},
+		ddbTTL:        &types.AttributeValueMemberN{Value: strconv.FormatInt(expiry.Unix(), 10)},
+		ddbChunks:     &types.AttributeValueMemberN{Value: manifest.chunks},
+		ddbChecksum:   &types.AttributeValueMemberB{Value: manifest.checksum},
+		ddbGeneration: &types.AttributeValueMemberS{Value: manifest.generation},
+	}
+
+	return r.putItem(ctx, item, len(bytes), onlyIfAbsent)
+}
+
+// reassemble the value described by the manifest item; returns ErrCacheMiss when it is incomplete or corrupt
+func (r *DynamoDbV2Storage) getChunked(ctx context.Context, key string, item map[string]types.AttributeValue) ([]byte, error) {
+	return ddbGetChunked(ctx, key, ddbV2ManifestOf(item), r.getData)
+}
+
+// reassemble the values described by the manifest items (by key); values that are incomplete or corrupt are omitted
+func (r *DynamoDbV2Storage) getChunkedMulti(ctx context.Context, items map[string]map[string]types.AttributeValue) (map[string][]byte, error) {
+	manifests := make(map[string]ddbManifest, len(items))
+	for key, item := range items {
+		manifests[key] = ddbV2ManifestOf(item)
+	}
+
+	return ddbGetChunkedMulti(ctx, manifests, r.getData)
+}
+
+// return the data of the items for the keys (that exist and have not expired)
+func (r *DynamoDbV2Storage) getData(ctx context.Context, keys []string) (map[string][]byte, error) {
+	items, err := r.getItems(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(map[string][]byte, len(items))
+	for key, item := range items {
+		out[key] = ddbV2Bytes(item[ddbData])
+	}
+
+	return out, nil
+}
+
+// delete the chunks of the (already deleted) manifest item
+func (r *DynamoDbV2Storage) deleteChunks(ctx context.Context, key string, item map[string]types.AttributeValue) error {
+	chunkKeys := ddbV2ManifestOf(item).chunkKeys(key)
+
+	requests := make([]types.WriteRequest, 0, len(chunkKeys))
+	for _, chunkKey := range chunkKeys {
+		requests = append(requests, types.WriteRequest{
+			DeleteRequest: &types.DeleteRequest{
+				Key: ddbV2Key(chunkKey),
+			},
+		})
+	}
+
+	return r.batchWriteAll(ctx, requests)
+}
+
+// return the manifest saved in the item
+func ddbV2ManifestOf(item map[string]types.AttributeValue) ddbManifest {
+	manifest := ddbManifest{
+		generation: ddbV2String(item[ddbGeneration]),
+		checksum:   ddbV2Bytes(item[ddbChecksum]),
+	}
+
+	if chunks, ok := item[ddbChunks].(*types.AttributeValueMemberN); ok {
+		manifest.chunks = chunks.Value
+	}
+
+	return manifest
+}
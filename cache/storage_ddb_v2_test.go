@@ -0,0 +1,594 @@
+// Copyright 2017 Corey Scott http://www.sage42.org/
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDynamoDbV2Storage_implements(t *testing.T) {
+	assert.Implements(t, (*Storage)(nil), &DynamoDbV2Storage{})
+	assert.Implements(t, (*TTLStorage)(nil), &DynamoDbV2Storage{})
+	assert.Implements(t, (*MultiStorage)(nil), &DynamoDbV2Storage{})
+	assert.Implements(t, (*DynamoDbV2API)(nil), &dynamodb.Client{})
+}
+
+func TestDynamoDbV2Storage_happyPath(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	key := getTestKey()
+
+	storage := getTestDynamoDbV2Storage(newFakeDynamoDbV2())
+
+	// get a value (should fail)
+	result, resultErr := storage.Get(ctx, key)
+	assert.Nil(t, result)
+	assert.Equal(t, ErrCacheMiss, resultErr)
+
+	// set a value
+	data := []byte(`this is foo`)
+	resultErr = storage.Set(ctx, key, data)
+	assert.Nil(t, resultErr)
+
+	// get a value
+	result, resultErr = storage.Get(ctx, key)
+	assert.Equal(t, data, result)
+	assert.Nil(t, resultErr)
+
+	// invalidate that value
+	resultErr = storage.Invalidate(ctx, key)
+	assert.Nil(t, resultErr)
+
+	result, resultErr = storage.Get(ctx, key)
+	assert.Nil(t, result)
+	assert.Equal(t, ErrCacheMiss, resultErr)
+}
+
+func TestDynamoDbV2Storage_Add(t *testing.T) {
+	testAddStorage(t, getTestDynamoDbV2Storage(newFakeDynamoDbV2()))
+}
+
+func TestDynamoDbV2Storage_multi(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	missingKey := getTestKey() + ".missing"
+
+	service := newFakeDynamoDbV2()
+	service.unprocessedOnce = true
+
+	storage := getTestDynamoDbV2Storage(service)
+
+	// more items than fit in a single batch
+	items := map[string][]byte{}
+	keys := []string{}
+	for x := 0; x < ddbBatchGetSize+5; x++ {
+		key := fmt.Sprintf("%s.%d", getTestKey(), x)
+		items[key] = []byte(key)
+		keys = append(keys, key)
+	}
+
+	// set the values
+	resultErr := storage.SetMulti(ctx, items)
+	assert.Nil(t, resultErr)
+
+	// get the values
+	result, resultErr := storage.GetMulti(ctx, append(keys, missingKey))
+	assert.Nil(t, resultErr)
+	assert.Equal(t, items, result)
+}
+
+func TestDynamoDbV2Storage_multiIncomplete(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	key := getTestKey()
+
+	service := newFakeDynamoDbV2()
+	service.unprocessedAlways = true
+
+	storage := getTestDynamoDbV2Storage(service)
+
+	// set the values
+	resultErr := storage.SetMulti(ctx, map[string][]byte{key: []byte(`this is foo`)})
+	assert.Equal(t, errBatchIncomplete, resultErr)
+
+	// get the values
+	result, resultErr := storage.GetMulti(ctx, []string{key})
+	assert.Nil(t, result)
+	assert.Equal(t, errBatchIncomplete, resultErr)
+}
+
+func TestDynamoDbV2Storage_SetWithTTL(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	key := getTestKey()
+
+	service := newFakeDynamoDbV2()
+	storage := getTestDynamoDbV2Storage(service)
+
+	// set a value
+	resultErr := storage.SetWithTTL(ctx, key, []byte(`this is foo`), 1*time.Hour)
+	assert.Nil(t, resultErr)
+
+	// validate the TTL attribute
+	expected := time.Now().Add(1 * time.Hour).Unix()
+	assert.InDelta(t, expected, fakeDynamoDbV2Number(service.items[key][ddbTTL]), 1)
+}
+
+func TestDynamoDbV2Storage_expired(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+
+	service := newFakeDynamoDbV2()
+	storage := getTestDynamoDbV2Storage(service)
+
+	// items that have expired but not yet been deleted by DynamoDB
+	service.items["expired"] = ddbV2NewItem("expired", []byte(`this is old`), time.Now().Add(-1*time.Hour))
+	service.items["current"] = ddbV2NewItem("current", []byte(`this is new`), time.Now().Add(1*time.Hour))
+
+	// get the values
+	result, resultErr := storage.Get(ctx, "expired")
+	assert.Nil(t, result)
+	assert.Equal(t, ErrCacheMiss, resultErr)
+
+	result, resultErr = storage.Get(ctx, "current")
+	assert.Nil(t, resultErr)
+	assert.Equal(t, []byte(`this is new`), result)
+
+	results, resultErr := storage.GetMulti(ctx, []string{"expired", "current"})
+	assert.Nil(t, resultErr)
+	assert.Equal(t, map[string][]byte{"current": []byte(`this is new`)}, results)
+}
+
+func TestDynamoDbV2Storage_Versioned(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+
+	storage := getTestDynamoDbV2Storage(newFakeDynamoDbV2())
+	storage.Versioned = true
+
+	// set a value
+	resultErr := storage.Set(WithVersion(ctx, 2), "foo", []byte(`version 2`))
+	assert.Nil(t, resultErr)
+
+	// older versions are discarded
+	resultErr = storage.Set(WithVersion(ctx, 1), "foo", []byte(`version 1`))
+	assert.Nil(t, resultErr)
+
+	resultErr = storage.SetMulti(WithVersion(ctx, 1), map[string][]byte{"foo": []byte(`version 1`)})
+	assert.Nil(t, resultErr)
+
+	result, resultErr := storage.Get(ctx, "foo")
+	assert.Nil(t, resultErr)
+	assert.Equal(t, []byte(`version 2`), result)
+
+	// newer versions replace the value
+	resultErr = storage.SetMulti(WithVersion(ctx, 3), map[string][]byte{"foo": []byte(`version 3`)})
+	assert.Nil(t, resultErr)
+
+	result, resultErr = storage.Get(ctx, "foo")
+	assert.Nil(t, resultErr)
+	assert.Equal(t, []byte(`version 3`), result)
+
+	// expired items are replaced regardless of version
+	resultErr = storage.SetWithTTL(WithVersion(ctx, 4), "foo", []byte(`version 4`), -1*time.Hour)
+	assert.Nil(t, resultErr)
+
+	resultErr = storage.Set(WithVersion(ctx, 1), "foo", []byte(`version 1`))
+	assert.Nil(t, resultErr)
+
+	result, resultErr = storage.Get(ctx, "foo")
+	assert.Nil(t, resultErr)
+	assert.Equal(t, []byte(`version 1`), result)
+}
+
+func TestDynamoDbV2Storage_error(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+
+	service := newFakeDynamoDbV2()
+	service.err = &types.ProvisionedThroughputExceededException{Message: aws.String("slow down")}
+
+	storage := getTestDynamoDbV2Storage(service)
+
+	// make the calls
+	_, resultErr := storage.Get(ctx, "foo")
+	assert.Equal(t, service.err, resultErr)
+
+	resultErr = storage.Set(ctx, "foo", []byte(`bar`))
+	assert.Equal(t, service.err, resultErr)
+
+	resultErr = storage.Invalidate(ctx, "foo")
+	assert.Equal(t, service.err, resultErr)
+}
+
+func TestDynamoDbV2Storage_chunks(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+
+	service := newFakeDynamoDbV2()
+	storage := getTestDynamoDbV2Storage(service)
+	storage.ChunkSize = 4
+
+	items := map[string][]byte{
+		"large":       []byte(`this value is split into chunks`),
+		"exact":       []byte(`abcd`),
+		"also-large":  []byte(`so is this one`),
+		"not-chunked": []byte(`abc`),
+	}
+
+	// set a value
+	resultErr := storage.Set(ctx, "foo", items["large"])
+	assert.Nil(t, resultErr)
+
+	// 8 chunks + the manifest
+	assert.Len(t, service.items, 9)
+
+	// get a value
+	result, resultErr := storage.Get(ctx, "foo")
+	assert.Nil(t, resultErr)
+	assert.Equal(t, items["large"], result)
+
+	// replace the value
+	resultErr = storage.Set(ctx, "foo", []byte(`something else`))
+	assert.Nil(t, resultErr)
+
+	result, resultErr = storage.Get(ctx, "foo")
+	assert.Nil(t, resultErr)
+	assert.Equal(t, []byte(`something else`), result)
+
+	// invalidate removes the manifest and its chunks (the chunks of the replaced value expire with their TTL)
+	before := len(service.items)
+
+	resultErr = storage.Invalidate(ctx, "foo")
+	assert.Nil(t, resultErr)
+	assert.Len(t, service.items, before-5)
+
+	result, resultErr = storage.Get(ctx, "foo")
+	assert.Nil(t, result)
+	assert.Equal(t, ErrCacheMiss, resultErr)
+
+	// add
+	added, resultErr := storage.Add(ctx, "bar", items["large"], 0)
+	assert.Nil(t, resultErr)
+	assert.True(t, added)
+
+	added, resultErr = storage.Add(ctx, "bar", items["also-large"], 0)
+	assert.Nil(t, resultErr)
+	assert.False(t, added)
+
+	result, resultErr = storage.Get(ctx, "bar")
+	assert.Nil(t, resultErr)
+	assert.Equal(t, items["large"], result)
+
+	// multi
+	resultErr = storage.SetMulti(ctx, items)
+	assert.Nil(t, resultErr)
+
+	results, resultErr := storage.GetMulti(ctx, []string{"large", "exact", "also-large", "not-chunked", "missing"})
+	assert.Nil(t, resultErr)
+	assert.Equal(t, items, results)
+}
+
+func TestDynamoDbV2Storage_chunksCorrupt(t *testing.T) {
+	scenarios := []struct {
+		desc    string
+		corrupt func(service *fakeDynamoDbV2, chunkKeys []string)
+	}{
+		{
+			desc: "missing chunk",
+			corrupt: func(service *fakeDynamoDbV2, chunkKeys []string) {
+				delete(service.items, chunkKeys[1])
+			},
+		},
+		{
+			desc: "expired chunk",
+			corrupt: func(service *fakeDynamoDbV2, chunkKeys []string) {
+				service.items[chunkKeys[1]][ddbTTL] = &types.AttributeValueMemberN{
+					Value: strconv.FormatInt(time.Now().Add(-1*time.Minute).Unix(), 10),
+				}
+			},
+		},
+		{
+			desc: "checksum mismatch",
+			corrupt: func(service *fakeDynamoDbV2, chunkKeys []string) {
+				service.items[chunkKeys[1]][ddbData] = &types.AttributeValueMemberB{Value: []byte(`oops`)}
+			},
+		},
+	}
+
+	for _, s := range scenarios {
+		scenario := s
+		t.Run(scenario.desc, func(t *testing.T) {
+			// inputs
+			ctx, cancelFn := context.WithCancel(context.Background())
+			defer cancelFn()
+
+			service := newFakeDynamoDbV2()
+			storage := getTestDynamoDbV2Storage(service)
+			storage.ChunkSize = 4
+
+			resultErr := storage.Set(ctx, "foo", []byte(`this value is split into chunks`))
+			assert.Nil(t, resultErr)
+
+			scenario.corrupt(service, ddbV2ManifestOf(service.items["foo"]).chunkKeys("foo"))
+
+			// validate
+			result, resultErr := storage.Get(ctx, "foo")
+			assert.Nil(t, result)
+			assert.Equal(t, ErrCacheMiss, resultErr)
+
+			results, resultErr := storage.GetMulti(ctx, []string{"foo"})
+			assert.Nil(t, resultErr)
+			assert.Empty(t, results)
+		})
+	}
+}
+
+func TestDynamoDbV2Storage_chunksSharedLayout(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+
+	v1Service := newFakeDynamoDb()
+	v1Storage := &DynamoDbStorage{
+		Service:   v1Service,
+		TableName: "cachetest",
+		TTL:       60 * time.Second,
+		ChunkSize: 4,
+	}
+
+	value := []byte(`this value is split into chunks`)
+
+	resultErr := v1Storage.Set(ctx, "foo", value)
+	require.NoError(t, resultErr)
+
+	// copy the items saved by DynamoDbStorage into the v2 stand-in
+	service := newFakeDynamoDbV2()
+	for key, item := range v1Service.items {
+		service.items[key] = map[string]types.AttributeValue{
+			ddbKey: &types.AttributeValueMemberS{Value: key},
+			ddbTTL: &types.AttributeValueMemberN{Value: aws.ToString(item[ddbTTL].N)},
+		}
+
+		if item[ddbData] != nil {
+			service.items[key][ddbData] = &types.AttributeValueMemberB{Value: item[ddbData].B}
+		}
+
+		if item[ddbChunks] != nil {
+			service.items[key][ddbChunks] = &types.AttributeValueMemberN{Value: aws.ToString(item[ddbChunks].N)}
+			service.items[key][ddbChecksum] = &types.AttributeValueMemberB{Value: item[ddbChecksum].B}
+			service.items[key][ddbGeneration] = &types.AttributeValueMemberS{Value: aws.ToString(item[ddbGeneration].S)}
+		}
+	}
+
+	storage := getTestDynamoDbV2Storage(service)
+
+	// make the call
+	result, resultErr := storage.Get(ctx, "foo")
+
+	// validate
+	assert.Nil(t, resultErr)
+	assert.Equal(t, value, result)
+}
+
+func getTestDynamoDbV2Storage(service DynamoDbV2API) *DynamoDbV2Storage {
+	return &DynamoDbV2Storage{
+		Service:   service,
+		TableName: "cachetest",
+		TTL:       60 * time.Second,
+		Breaker:   NoopBreaker{},
+	}
+}
+
+// in-memory stand-in for DynamoDB that supports the calls used by DynamoDbV2Storage
+type fakeDynamoDbV2 struct {
+	// when set, the first batch call of each type will only process the first item
+	unprocessedOnce bool
+
+	// when set, batch calls will never process any items
+	unprocessedAlways bool
+
+	// when set, every call fails with this error
+	err error
+
+	mutex            sync.Mutex
+	items            map[string]map[string]types.AttributeValue
+	unprocessedGet   bool
+	unprocessedWrite bool
+}
+
+func newFakeDynamoDbV2() *fakeDynamoDbV2 {
+	return &fakeDynamoDbV2{
+		items: map[string]map[string]types.AttributeValue{},
+	}
+}
+
+func (f *fakeDynamoDbV2) GetItem(_ context.Context, input *dynamodb.GetItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	return &dynamodb.GetItemOutput{
+		Item: f.items[ddbV2String(input.Key[ddbKey])],
+	}, nil
+}
+
+func (f *fakeDynamoDbV2) PutItem(_ context.Context, input *dynamodb.PutItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	key := ddbV2String(input.Item[ddbKey])
+
+	if aws.ToString(input.ConditionExpression) == ddbVersionCondition {
+		existing, found := f.items[key]
+		if found && existing[ddbVersion] != nil &&
+			fakeDynamoDbV2Number(existing[ddbVersion]) > fakeDynamoDbV2Number(input.ExpressionAttributeValues[":version"]) &&
+			fakeDynamoDbV2Number(existing[ddbTTL]) >= fakeDynamoDbV2Number(input.ExpressionAttributeValues[":now"]) {
+			return nil, &types.ConditionalCheckFailedException{Message: aws.String("the conditional request failed")}
+		}
+	}
+
+	if aws.ToString(input.ConditionExpression) == ddbAddCondition {
+		existing, found := f.items[key]
+		if found && fakeDynamoDbV2Number(existing[ddbTTL]) >= fakeDynamoDbV2Number(input.ExpressionAttributeValues[":now"]) {
+			return nil, &types.ConditionalCheckFailedException{Message: aws.String("the conditional request failed")}
+		}
+	}
+
+	f.items[key] = input.Item
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (f *fakeDynamoDbV2) DeleteItem(_ context.Context, input *dynamodb.DeleteItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	key := ddbV2String(input.Key[ddbKey])
+
+	out := &dynamodb.DeleteItemOutput{}
+	if input.ReturnValues == types.ReturnValueAllOld {
+		out.Attributes = f.items[key]
+	}
+
+	delete(f.items, key)
+	return out, nil
+}
+
+func (f *fakeDynamoDbV2) BatchGetItem(_ context.Context, input *dynamodb.BatchGetItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.BatchGetItemOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	out := &dynamodb.BatchGetItemOutput{
+		Responses:       map[string][]map[string]types.AttributeValue{},
+		UnprocessedKeys: map[string]types.KeysAndAttributes{},
+	}
+
+	for table, request := range input.RequestItems {
+		if len(request.Keys) > ddbBatchGetSize {
+			return nil, fmt.Errorf("too many keys: %d", len(request.Keys))
+		}
+
+		keys := request.Keys
+		if f.unprocessedAlways || (f.unprocessedOnce && !f.unprocessedGet) {
+			f.unprocessedGet = true
+
+			processed := 1
+			if f.unprocessedAlways {
+				processed = 0
+			}
+
+			out.UnprocessedKeys[table] = types.KeysAndAttributes{
+				Keys:            keys[processed:],
+				AttributesToGet: request.AttributesToGet,
+			}
+			keys = keys[:processed]
+		}
+
+		for _, key := range keys {
+			item, found := f.items[ddbV2String(key[ddbKey])]
+			if found {
+				out.Responses[table] = append(out.Responses[table], item)
+			}
+		}
+	}
+
+	return out, nil
+}
+
+func (f *fakeDynamoDbV2) BatchWriteItem(_ context.Context, input *dynamodb.BatchWriteItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.BatchWriteItemOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	out := &dynamodb.BatchWriteItemOutput{
+		UnprocessedItems: map[string][]types.WriteRequest{},
+	}
+
+	for table, requests := range input.RequestItems {
+		if len(requests) > ddbBatchWriteSize {
+			return nil, fmt.Errorf("too many requests: %d", len(requests))
+		}
+
+		if f.unprocessedAlways || (f.unprocessedOnce && !f.unprocessedWrite) {
+			f.unprocessedWrite = true
+
+			processed := 1
+			if f.unprocessedAlways {
+				processed = 0
+			}
+
+			out.UnprocessedItems[table] = requests[processed:]
+			requests = requests[:processed]
+		}
+
+		for _, request := range requests {
+			if request.PutRequest != nil {
+				f.items[ddbV2String(request.PutRequest.Item[ddbKey])] = request.PutRequest.Item
+			}
+			if request.DeleteRequest != nil {
+				delete(f.items, ddbV2String(request.DeleteRequest.Key[ddbKey]))
+			}
+		}
+	}
+
+	return out, nil
+}
+
+func fakeDynamoDbV2Number(value types.AttributeValue) int64 {
+	number, ok := value.(*types.AttributeValueMemberN)
+	if !ok {
+		return 0
+	}
+
+	out, _ := strconv.ParseInt(number.Value, 10, 64)
+	return out
+}
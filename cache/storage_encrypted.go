@@ -0,0 +1,274 @@
+// Copyright 2017 Corey Scott http://www.sage42.org/
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrUnknownEncryptionKey is returned when the key ID is not one of EncryptedStorage.Keys
+	ErrUnknownEncryptionKey = errors.New("unknown encryption key")
+
+	// errCiphertextCorrupt denotes the stored value could not be parsed
+	errCiphertextCorrupt = errors.New("ciphertext is corrupt")
+)
+
+// encrypted value format: version (1 byte) + key ID length (1 byte) + key ID + nonce + AES-GCM ciphertext
+const (
+	encryptedVersion = 1
+
+	// the max length of a key ID
+	maxEncryptionKeyIDLength = 255
+)
+
+// EncryptedStorage implements Storage by encrypting values with AES-GCM before they are passed to the wrapped storage.
+//
+// The ID of the key used is stored with each value; this allows keys to be rotated by adding a new key, making it
+// the CurrentKeyID and (once all values encrypted with it have expired) removing the old key.
+//
+// The cache key is used as additional authenticated data; as such values cannot be moved between keys.
+//
+// Values that cannot be decrypted (e.g. their key has been removed or the data was modified) are treated as misses
+// and tracked as CacheDecryptError events so that they are rebuilt and replaced.
+type EncryptedStorage struct {
+	// Storage is the storage that holds the encrypted values (required)
+	Storage Storage
+
+	// Keys are the AES keys (16, 24 or 32 bytes) by key ID (required)
+	//
+	// All keys are used for decryption.  Key IDs are stored with every value and are limited to 255 bytes.
+	Keys map[string][]byte
+
+	// CurrentKeyID is the ID of the key used to encrypt new values; it must exist in Keys (required)
+	CurrentKeyID string
+
+	// Metrics allow for tracking decryption failures (optional)
+	Metrics Metrics
+
+	initOnce sync.Once
+	initErr  error
+	aeads    map[string]cipher.AEAD
+}
+
+// Get implements Storage
+func (r *EncryptedStorage) Get(ctx context.Context, key string) ([]byte, error) {
+	data, err := r.Storage.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.decryptOrMiss(key, data)
+}
+
+// Set implements Storage
+func (r *EncryptedStorage) Set(ctx context.Context, key string, bytes []byte) error {
+	data, err := r.encrypt(key, bytes)
+	if err != nil {
+		return err
+	}
+
+	return r.Storage.Set(ctx, key, data)
+}
+
+// SetWithTTL implements TTLStorage
+func (r *EncryptedStorage) SetWithTTL(ctx context.Context, key string, bytes []byte, ttl time.Duration) error {
+	data, err := r.encrypt(key, bytes)
+	if err != nil {
+		return err
+	}
+
+	return setWithTTL(ctx, r.Storage, key, data, ttl)
+}
+
+// Add implements AddStorage (when the wrapped storage does)
+func (r *EncryptedStorage) Add(ctx context.Context, key string, bytes []byte, ttl time.Duration) (bool, error) {
+	data, err := r.encrypt(key, bytes)
+	if err != nil {
+		return false, err
+	}
+
+	return add(ctx, r.Storage, key, data, ttl)
+}
+
+// Invalidate implements Storage
+func (r *EncryptedStorage) Invalidate(ctx context.Context, key string) error {
+	return r.Storage.Invalidate(ctx, key)
+}
+
+// GetMulti implements MultiStorage
+func (r *EncryptedStorage) GetMulti(ctx context.Context, keys []string) (map[string][]byte, error) {
+	found, err := getMulti(ctx, r.Storage, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(map[string][]byte, len(found))
+	for key, data := range found {
+		plaintext, err := r.decryptOrMiss(key, data)
+		if err != nil {
+			// misses are omitted
+			continue
+		}
+
+		out[key] = plaintext
+	}
+
+	return out, nil
+}
+
+// SetMulti implements MultiStorage
+func (r *EncryptedStorage) SetMulti(ctx context.Context, items map[string][]byte) error {
+	encrypted := make(map[string][]byte, len(items))
+	for key, bytes := range items {
+		data, err := r.encrypt(key, bytes)
+		if err != nil {
+			return err
+		}
+
+		encrypted[key] = data
+	}
+
+	return setMulti(ctx, r.Storage, encrypted)
+}
+
+// Tag implements TagStorage (when the wrapped storage does)
+func (r *EncryptedStorage) Tag(ctx context.Context, key string, tags []string, ttl time.Duration) error {
+	return tag(ctx, r.Storage, key, tags, ttl)
+}
+
+// InvalidateTag implements TagStorage (when the wrapped storage does)
+func (r *EncryptedStorage) InvalidateTag(ctx context.Context, tag string) error {
+	return invalidateTag(ctx, r.Storage, tag)
+}
+
+// encrypt the value with the current key
+func (r *EncryptedStorage) encrypt(key string, plaintext []byte) ([]byte, error) {
+	aead, err := r.getAEAD(r.CurrentKeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	keyID := r.CurrentKeyID
+
+	out := make([]byte, 0, 2+len(keyID)+aead.NonceSize()+len(plaintext)+aead.Overhead())
+	out = append(out, encryptedVersion, byte(len(keyID)))
+	out = append(out, keyID...)
+
+	nonce := out[len(out) : len(out)+aead.NonceSize()]
+	_, err = io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return nil, err
+	}
+	out = out[:len(out)+len(nonce)]
+
+	return aead.Seal(out, nonce, plaintext, []byte(key)), nil
+}
+
+// decrypt the value; failures are tracked and returned as a cache miss
+func (r *EncryptedStorage) decryptOrMiss(key string, data []byte) ([]byte, error) {
+	plaintext, err := r.decrypt(key, data)
+	if err != nil {
+		r.getMetrics().Track(CacheDecryptError)
+		return nil, ErrCacheMiss
+	}
+
+	return plaintext, nil
+}
+
+// decrypt the value with the key denoted in its header
+func (r *EncryptedStorage) decrypt(key string, data []byte) ([]byte, error) {
+	if len(data) < 2 || data[0] != encryptedVersion {
+		return nil, errCiphertextCorrupt
+	}
+
+	keyIDLength := int(data[1])
+	data = data[2:]
+	if len(data) < keyIDLength {
+		return nil, errCiphertextCorrupt
+	}
+
+	aead, err := r.getAEAD(string(data[:keyIDLength]))
+	if err != nil {
+		return nil, err
+	}
+	data = data[keyIDLength:]
+
+	if len(data) < aead.NonceSize() {
+		return nil, errCiphertextCorrupt
+	}
+
+	return aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], []byte(key))
+}
+
+// return the cipher for the supplied key ID
+func (r *EncryptedStorage) getAEAD(keyID string) (cipher.AEAD, error) {
+	r.initOnce.Do(r.init)
+	if r.initErr != nil {
+		return nil, r.initErr
+	}
+
+	aead, found := r.aeads[keyID]
+	if !found {
+		return nil, ErrUnknownEncryptionKey
+	}
+
+	return aead, nil
+}
+
+// build and validate the ciphers for all keys
+func (r *EncryptedStorage) init() {
+	r.aeads = make(map[string]cipher.AEAD, len(r.Keys))
+
+	for keyID, key := range r.Keys {
+		if len(keyID) > maxEncryptionKeyIDLength {
+			r.initErr = fmt.Errorf("encryption key ID '%s' is too long", keyID)
+			return
+		}
+
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			r.initErr = fmt.Errorf("encryption key '%s' is invalid: %w", keyID, err)
+			return
+		}
+
+		r.aeads[keyID], err = cipher.NewGCM(block)
+		if err != nil {
+			r.initErr = err
+			return
+		}
+	}
+
+	if _, found := r.aeads[r.CurrentKeyID]; !found {
+		r.initErr = fmt.Errorf("current encryption key '%s': %w", r.CurrentKeyID, ErrUnknownEncryptionKey)
+	}
+}
+
+// return the supplied metric tracker or a no-op implementation
+func (r *EncryptedStorage) getMetrics() Metrics {
+	if r.Metrics != nil {
+		return r.Metrics
+	}
+
+	return noopMetrics
+}
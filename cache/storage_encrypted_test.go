@@ -0,0 +1,204 @@
+// Copyright 2017 Corey Scott http://www.sage42.org/
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	testKeyA = bytes.Repeat([]byte{0xA}, 32)
+	testKeyB = bytes.Repeat([]byte{0xB}, 16)
+)
+
+func TestEncryptedStorage_implements(t *testing.T) {
+	assert.Implements(t, (*Storage)(nil), &EncryptedStorage{})
+	assert.Implements(t, (*TTLStorage)(nil), &EncryptedStorage{})
+	assert.Implements(t, (*MultiStorage)(nil), &EncryptedStorage{})
+}
+
+func TestEncryptedStorage_happyPath(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	key := getTestKey()
+	data := []byte(`this is foo`)
+
+	inner := &MemoryStorage{}
+	storage := &EncryptedStorage{
+		Storage:      inner,
+		Keys:         map[string][]byte{"a": testKeyA},
+		CurrentKeyID: "a",
+	}
+
+	// set a value
+	resultErr := storage.Set(ctx, key, data)
+	assert.Nil(t, resultErr)
+
+	// validate the stored data is not the plaintext
+	stored, resultErr := inner.Get(ctx, key)
+	assert.Nil(t, resultErr)
+	assert.False(t, bytes.Contains(stored, data))
+
+	// get a value
+	result, resultErr := storage.Get(ctx, key)
+	assert.Nil(t, resultErr)
+	assert.Equal(t, data, result)
+}
+
+func TestEncryptedStorage_keyRotation(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	oldKey := getTestKey() + ".old"
+	newKey := getTestKey() + ".new"
+
+	inner := &MemoryStorage{}
+
+	// write with the old key
+	before := &EncryptedStorage{
+		Storage:      inner,
+		Keys:         map[string][]byte{"a": testKeyA},
+		CurrentKeyID: "a",
+	}
+	assert.Nil(t, before.Set(ctx, oldKey, []byte(`old`)))
+
+	// rotate; both keys can be read
+	after := &EncryptedStorage{
+		Storage:      inner,
+		Keys:         map[string][]byte{"a": testKeyA, "b": testKeyB},
+		CurrentKeyID: "b",
+	}
+	assert.Nil(t, after.Set(ctx, newKey, []byte(`new`)))
+
+	result, resultErr := after.GetMulti(ctx, []string{oldKey, newKey})
+	assert.Nil(t, resultErr)
+	assert.Equal(t, map[string][]byte{oldKey: []byte(`old`), newKey: []byte(`new`)}, result)
+
+	// the old storage is not able to read the new key
+	metrics := &MockMetrics{}
+	metrics.On("Track", CacheDecryptError).Once()
+	before.Metrics = metrics
+
+	_, resultErr = before.Get(ctx, newKey)
+	assert.Equal(t, ErrCacheMiss, resultErr)
+	assert.True(t, metrics.AssertExpectations(t))
+}
+
+func TestEncryptedStorage_tamperedOrMoved(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	key := getTestKey()
+
+	inner := &MemoryStorage{}
+	storage := &EncryptedStorage{
+		Storage:      inner,
+		Keys:         map[string][]byte{"a": testKeyA},
+		CurrentKeyID: "a",
+	}
+	assert.Nil(t, storage.Set(ctx, key, []byte(`this is foo`)))
+
+	stored, resultErr := inner.Get(ctx, key)
+	assert.Nil(t, resultErr)
+
+	// copy to another key
+	assert.Nil(t, inner.Set(ctx, key+".copy", stored))
+
+	_, resultErr = storage.Get(ctx, key+".copy")
+	assert.Equal(t, ErrCacheMiss, resultErr)
+
+	// modify the ciphertext
+	stored[len(stored)-1] ^= 0xFF
+	assert.Nil(t, inner.Set(ctx, key, stored))
+
+	_, resultErr = storage.Get(ctx, key)
+	assert.Equal(t, ErrCacheMiss, resultErr)
+
+	// unencrypted data
+	assert.Nil(t, inner.Set(ctx, key, []byte(`plain`)))
+
+	_, resultErr = storage.Get(ctx, key)
+	assert.Equal(t, ErrCacheMiss, resultErr)
+}
+
+func TestEncryptedStorage_badConfig(t *testing.T) {
+	scenarios := []struct {
+		desc    string
+		storage *EncryptedStorage
+	}{
+		{
+			desc: "missing current key",
+			storage: &EncryptedStorage{
+				Keys:         map[string][]byte{"a": testKeyA},
+				CurrentKeyID: "b",
+			},
+		},
+		{
+			desc: "invalid key length",
+			storage: &EncryptedStorage{
+				Keys:         map[string][]byte{"a": []byte(`too short`)},
+				CurrentKeyID: "a",
+			},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		t.Run(scenario.desc, func(t *testing.T) {
+			scenario.storage.Storage = &MemoryStorage{}
+
+			resultErr := scenario.storage.Set(context.Background(), getTestKey(), []byte(`this is foo`))
+			assert.NotNil(t, resultErr)
+		})
+	}
+
+	// the error can be identified
+	storage := scenarios[0].storage
+	resultErr := storage.Set(context.Background(), getTestKey(), []byte(`this is foo`))
+	assert.True(t, errors.Is(resultErr, ErrUnknownEncryptionKey))
+}
+
+func TestEncryptedStorage_withClient(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	key := getTestKey()
+
+	client := &Client{
+		Storage: &EncryptedStorage{
+			Storage:      &MemoryStorage{},
+			Keys:         map[string][]byte{"a": testKeyA},
+			CurrentKeyID: "a",
+		},
+		Compression: SnappyCodec{},
+	}
+
+	// populate the cache
+	client.Set(ctx, key, &myDTO{Name: "bob"})
+
+	// make the call
+	result := &myDTO{}
+	resultErr := client.Get(ctx, key, result, BuilderFunc(func(ctx context.Context, key string, dest BinaryEncoder) error {
+		assert.Fail(t, "builder should not be called")
+		return nil
+	}))
+	assert.Nil(t, resultErr)
+	assert.Equal(t, "bob", result.Name)
+}
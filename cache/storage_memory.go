@@ -0,0 +1,425 @@
+// Copyright 2017 Corey Scott http://www.sage42.org/
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// the number of items checked for expiry on each write (see MemoryStorage.sweep)
+const memorySweepSize = 4
+
+// ErrItemTooLarge is returned when the item is larger than the storage is able to hold
+var ErrItemTooLarge = errors.New("item too large")
+
+// MemoryStorage implements Storage as an in-process LRU cache
+//
+// Items are removed when they expire or, when the storage is full, in least recently used order.  Expired items are
+// removed when read, when the storage is full and by a sweep that checks a few items on each write; as such expired
+// items that are never read again do not accumulate, even when the storage has no bounds.  Items that the
+// Client must not lose (e.g. the namespace version) are pinned: they are never evicted to make space, are not
+// limited by TTL and do not count towards MaxEntries or MaxBytes.
+type MemoryStorage struct {
+	// MaxEntries is the max number of items to store (optional - default unlimited)
+	MaxEntries int
+
+	// MaxBytes is the max total size of the stored keys and values (optional - default unlimited)
+	MaxBytes int64
+
+	// TTL is the max TTL for cache items (optional - default items only leave the cache when evicted)
+	TTL time.Duration
+
+	// OnEvict is called for every item that is removed due to expiry or to make space (optional)
+	//
+	// It is not called for invalidated or overwritten items.
+	OnEvict func(key string, bytes []byte)
+
+	mutex      sync.Mutex
+	items      map[string]*list.Element
+	lru        *list.List
+	totalBytes int64
+
+	// the next item to be checked by sweep (nil to start again from the least recently used item)
+	sweepNext *list.Element
+
+	// the number and size of the pinned items (included in the totals above)
+	pinnedItems int
+	pinnedBytes int64
+
+	// the keys associated with each tag
+	tags map[string]map[string]struct{}
+
+	// returns the current time; replaced in tests (default time.Now)
+	now func() time.Time
+}
+
+// a single item in the LRU list
+type memoryItem struct {
+	key     string
+	bytes   []byte
+	expires time.Time
+	tags    []string
+
+	// pinned items are never evicted to make space
+	pinned bool
+}
+
+// size of the item as counted towards MaxBytes
+func (m *memoryItem) size() int64 {
+	return int64(len(m.key) + len(m.bytes))
+}
+
+// returns true when the item has a TTL and it has passed
+func (m *memoryItem) isExpired(now time.Time) bool {
+	return !m.expires.IsZero() && now.After(m.expires)
+}
+
+// return the current time
+func (r *MemoryStorage) getNow() time.Time {
+	if r.now != nil {
+		return r.now()
+	}
+
+	return time.Now()
+}
+
+// Get implements Storage
+func (r *MemoryStorage) Get(ctx context.Context, key string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mutex.Lock()
+
+	element, found := r.items[key]
+	if !found {
+		r.mutex.Unlock()
+		return nil, ErrCacheMiss
+	}
+
+	item := element.Value.(*memoryItem)
+	if item.isExpired(r.getNow()) {
+		r.removeElement(element)
+		r.mutex.Unlock()
+
+		r.notifyEvicted([]*memoryItem{item})
+		return nil, ErrCacheMiss
+	}
+
+	r.lru.MoveToFront(element)
+	out := copyBytes(item.bytes)
+
+	r.mutex.Unlock()
+
+	return out, nil
+}
+
+// Set implements Storage
+func (r *MemoryStorage) Set(ctx context.Context, key string, bytes []byte) error {
+	return r.SetWithTTL(ctx, key, bytes, r.TTL)
+}
+
+// SetWithTTL implements TTLStorage
+//
+// When the storage has a TTL, it is the upper limit; items will never be kept for longer than the storage TTL.
+func (r *MemoryStorage) SetWithTTL(ctx context.Context, key string, bytes []byte, ttl time.Duration) error {
+	_, err := r.put(ctx, key, bytes, ttl, false)
+	return err
+}
+
+// Add implements AddStorage
+func (r *MemoryStorage) Add(ctx context.Context, key string, bytes []byte, ttl time.Duration) (bool, error) {
+	return r.put(ctx, key, bytes, ttl, true)
+}
+
+// save the item; when onlyIfAbsent is set, existing (unexpired) items are retained and false is returned
+func (r *MemoryStorage) put(ctx context.Context, key string, bytes []byte, ttl time.Duration, onlyIfAbsent bool) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	pinned := isPinned(ctx)
+	if r.TTL > 0 && !pinned && (ttl <= 0 || ttl > r.TTL) {
+		ttl = r.TTL
+	}
+
+	now := r.getNow()
+	item := &memoryItem{
+		key:    key,
+		bytes:  copyBytes(bytes),
+		pinned: pinned,
+	}
+	if ttl > 0 {
+		item.expires = now.Add(ttl)
+	}
+
+	if r.MaxBytes > 0 && item.size() > r.MaxBytes {
+		return false, ErrItemTooLarge
+	}
+
+	r.mutex.Lock()
+	r.init()
+
+	if element, found := r.items[key]; found {
+		if onlyIfAbsent && !element.Value.(*memoryItem).isExpired(now) {
+			r.mutex.Unlock()
+			return false, nil
+		}
+
+		r.removeElement(element)
+	}
+
+	r.items[key] = r.lru.PushFront(item)
+	r.totalBytes += item.size()
+	if item.pinned {
+		r.pinnedItems++
+		r.pinnedBytes += item.size()
+	}
+
+	evicted := append(r.sweep(now), r.evict()...)
+
+	r.mutex.Unlock()
+
+	r.notifyEvicted(evicted)
+	return true, nil
+}
+
+// Invalidate implements Storage
+func (r *MemoryStorage) Invalidate(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if element, found := r.items[key]; found {
+		r.removeElement(element)
+	}
+
+	return nil
+}
+
+// Tag implements TagStorage
+//
+// Tags are removed along with the item; as such the TTL is not required.
+func (r *MemoryStorage) Tag(ctx context.Context, key string, tags []string, _ time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	element, found := r.items[key]
+	if !found {
+		// nothing to tag (e.g. already evicted)
+		return nil
+	}
+
+	item := element.Value.(*memoryItem)
+	for _, tag := range tags {
+		keys, found := r.tags[tag]
+		if !found {
+			keys = map[string]struct{}{}
+			r.tags[tag] = keys
+		}
+
+		if _, found := keys[key]; !found {
+			keys[key] = struct{}{}
+			item.tags = append(item.tags, tag)
+		}
+	}
+
+	return nil
+}
+
+// InvalidateTag implements TagStorage
+func (r *MemoryStorage) InvalidateTag(ctx context.Context, tag string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for key := range r.tags[tag] {
+		if element, found := r.items[key]; found {
+			r.removeElement(element)
+		}
+	}
+
+	return nil
+}
+
+// Purge removes all items from the storage.  OnEvict is not called for the removed items.
+func (r *MemoryStorage) Purge() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.items = nil
+	r.lru = nil
+	r.tags = nil
+	r.totalBytes = 0
+	r.sweepNext = nil
+	r.pinnedItems = 0
+	r.pinnedBytes = 0
+}
+
+// Len returns the number of items currently held (including any that have expired but not yet been removed)
+func (r *MemoryStorage) Len() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return len(r.items)
+}
+
+// lazy init of the internal data structures. Must be called with the mutex held.
+func (r *MemoryStorage) init() {
+	if r.items == nil {
+		r.items = map[string]*list.Element{}
+		r.lru = list.New()
+		r.tags = map[string]map[string]struct{}{}
+	}
+}
+
+// remove items until the storage is within its limits; expired items are removed first and pinned items are kept.
+// Must be called with the mutex held.
+func (r *MemoryStorage) evict() []*memoryItem {
+	var evicted []*memoryItem
+
+	now := r.getNow()
+	for element := r.lru.Back(); element != nil && r.isFull(); {
+		previous := element.Prev()
+
+		item := element.Value.(*memoryItem)
+		if item.isExpired(now) {
+			r.removeElement(element)
+			evicted = append(evicted, item)
+		}
+
+		element = previous
+	}
+
+	for element := r.lru.Back(); element != nil && r.isFull(); {
+		previous := element.Prev()
+
+		item := element.Value.(*memoryItem)
+		if !item.pinned {
+			r.removeElement(element)
+			evicted = append(evicted, item)
+		}
+
+		element = previous
+	}
+
+	return evicted
+}
+
+// remove expired items; a few items are checked on each write, continuing from where the previous sweep stopped, so
+// that every item is eventually checked. Must be called with the mutex held.
+func (r *MemoryStorage) sweep(now time.Time) []*memoryItem {
+	var expired []*memoryItem
+
+	for checked := 0; checked < memorySweepSize && r.lru.Len() > 0; checked++ {
+		element := r.sweepNext
+		if element == nil {
+			element = r.lru.Back()
+		}
+		r.sweepNext = element.Prev()
+
+		item := element.Value.(*memoryItem)
+		if item.isExpired(now) {
+			r.removeElement(element)
+			expired = append(expired, item)
+		}
+	}
+
+	return expired
+}
+
+// returns true when the (unpinned) items exceed the limits. Must be called with the mutex held.
+func (r *MemoryStorage) isFull() bool {
+	if r.MaxEntries > 0 && r.lru.Len()-r.pinnedItems > r.MaxEntries {
+		return true
+	}
+
+	return r.MaxBytes > 0 && r.totalBytes-r.pinnedBytes > r.MaxBytes
+}
+
+// remove an item from the storage. Must be called with the mutex held.
+func (r *MemoryStorage) removeElement(element *list.Element) {
+	item := element.Value.(*memoryItem)
+
+	if r.sweepNext == element {
+		r.sweepNext = element.Prev()
+	}
+
+	r.lru.Remove(element)
+	delete(r.items, item.key)
+	r.totalBytes -= item.size()
+	if item.pinned {
+		r.pinnedItems--
+		r.pinnedBytes -= item.size()
+	}
+
+	for _, tag := range item.tags {
+		keys := r.tags[tag]
+
+		delete(keys, item.key)
+		if len(keys) == 0 {
+			delete(r.tags, tag)
+		}
+	}
+}
+
+// call the eviction callback (outside the lock so that it may safely use the storage)
+func (r *MemoryStorage) notifyEvicted(items []*memoryItem) {
+	if r.OnEvict == nil {
+		return
+	}
+
+	for _, item := range items {
+		r.OnEvict(item.key, item.bytes)
+	}
+}
+
+type pinnedKey struct{}
+
+// mark the items saved with the context as pinned (see MemoryStorage)
+func withPinned(ctx context.Context) context.Context {
+	return context.WithValue(ctx, pinnedKey{}, true)
+}
+
+// returns true when the items saved with the context are pinned
+func isPinned(ctx context.Context) bool {
+	pinned, _ := ctx.Value(pinnedKey{}).(bool)
+	return pinned
+}
+
+// return a copy of the supplied slice so that callers cannot modify the stored data
+func copyBytes(in []byte) []byte {
+	if in == nil {
+		return nil
+	}
+
+	out := make([]byte, len(in))
+	copy(out, in)
+	return out
+}
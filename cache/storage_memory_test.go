@@ -0,0 +1,421 @@
+// Copyright 2017 Corey Scott http://www.sage42.org/
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryStorage_implements(t *testing.T) {
+	assert.Implements(t, (*Storage)(nil), &MemoryStorage{})
+}
+
+func TestMemoryStorage_happyPath(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	key := getTestKey()
+
+	storage := &MemoryStorage{}
+
+	// get a value (should fail)
+	result, resultErr := storage.Get(ctx, key)
+	assert.Nil(t, result)
+	assert.Equal(t, ErrCacheMiss, resultErr)
+
+	// set a value
+	data := []byte(`this is foo`)
+	resultErr = storage.Set(ctx, key, data)
+	assert.Nil(t, resultErr)
+
+	// get a value
+	result, resultErr = storage.Get(ctx, key)
+	assert.Equal(t, data, result)
+	assert.Nil(t, resultErr)
+}
+
+func TestMemoryStorage_Invalidate(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	key := getTestKey()
+
+	storage := &MemoryStorage{}
+
+	// set a value
+	data := []byte(`this is foo`)
+	resultErr := storage.Set(ctx, key, data)
+	assert.Nil(t, resultErr)
+
+	// invalidate that value
+	resultErr = storage.Invalidate(ctx, key)
+	assert.Nil(t, resultErr)
+
+	// get a value (should fail)
+	result, resultErr := storage.Get(ctx, key)
+	assert.Nil(t, result)
+	assert.Equal(t, ErrCacheMiss, resultErr)
+	assert.Equal(t, 0, storage.Len())
+}
+
+func TestMemoryStorage_TTL(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	key := getTestKey()
+
+	var evictedKeys []string
+	storage := &MemoryStorage{
+		TTL: 10 * time.Millisecond,
+		OnEvict: func(key string, bytes []byte) {
+			evictedKeys = append(evictedKeys, key)
+		},
+	}
+
+	// set a value
+	resultErr := storage.Set(ctx, key, []byte(`this is foo`))
+	assert.Nil(t, resultErr)
+
+	// wait for it to expire
+	<-time.After(20 * time.Millisecond)
+
+	// get a value (should fail)
+	result, resultErr := storage.Get(ctx, key)
+	assert.Nil(t, result)
+	assert.Equal(t, ErrCacheMiss, resultErr)
+	assert.Equal(t, []string{key}, evictedKeys)
+}
+
+func TestMemoryStorage_SetWithTTL(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+
+	now := time.Now()
+	storage := &MemoryStorage{
+		TTL: 10 * time.Second,
+		now: func() time.Time {
+			return now
+		},
+	}
+
+	// the storage TTL is the upper limit
+	assert.Nil(t, storage.SetWithTTL(ctx, "longer", []byte(`A`), 1*time.Minute))
+	assert.Nil(t, storage.SetWithTTL(ctx, "shorter", []byte(`B`), 1*time.Second))
+
+	now = now.Add(5 * time.Second)
+
+	_, resultErr := storage.Get(ctx, "shorter")
+	assert.Equal(t, ErrCacheMiss, resultErr)
+
+	_, resultErr = storage.Get(ctx, "longer")
+	assert.Nil(t, resultErr)
+
+	now = now.Add(10 * time.Second)
+
+	_, resultErr = storage.Get(ctx, "longer")
+	assert.Equal(t, ErrCacheMiss, resultErr)
+}
+
+func TestMemoryStorage_Add(t *testing.T) {
+	now := time.Now()
+	storage := &MemoryStorage{
+		now: func() time.Time {
+			return now
+		},
+	}
+
+	testAddStorage(t, storage)
+
+	// expired items are replaced
+	ctx := context.Background()
+	assert.Nil(t, storage.SetWithTTL(ctx, "expiring", []byte(`A`), 1*time.Second))
+
+	now = now.Add(5 * time.Second)
+
+	added, resultErr := storage.Add(ctx, "expiring", []byte(`B`), 0)
+	assert.Nil(t, resultErr)
+	assert.True(t, added)
+}
+
+// validate an AddStorage only saves values for keys that do not exist
+func testAddStorage(t *testing.T, storage AddStorage) {
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	key := getTestKey()
+
+	// the first add saves the value
+	added, resultErr := storage.Add(ctx, key, []byte(`first`), 1*time.Minute)
+	assert.Nil(t, resultErr)
+	assert.True(t, added)
+
+	// later adds do not
+	added, resultErr = storage.Add(ctx, key, []byte(`second`), 1*time.Minute)
+	assert.Nil(t, resultErr)
+	assert.False(t, added)
+
+	result, resultErr := storage.(Storage).Get(ctx, key)
+	assert.Nil(t, resultErr)
+	assert.Equal(t, []byte(`first`), result)
+}
+
+func TestMemoryStorage_sweep(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+
+	now := time.Now()
+	var evictedKeys []string
+	storage := &MemoryStorage{
+		OnEvict: func(key string, bytes []byte) {
+			evictedKeys = append(evictedKeys, key)
+		},
+		now: func() time.Time {
+			return now
+		},
+	}
+
+	// items that expire and are never read again
+	for index := 0; index < 100; index++ {
+		assert.Nil(t, storage.SetWithTTL(ctx, "old-"+strconv.Itoa(index), []byte(`A`), 1*time.Minute))
+	}
+
+	now = now.Add(2 * time.Minute)
+
+	// make the calls
+	for index := 0; index < 100; index++ {
+		assert.Nil(t, storage.SetWithTTL(ctx, "new-"+strconv.Itoa(index), []byte(`B`), 1*time.Hour))
+	}
+
+	// validate
+	assert.Equal(t, 100, storage.Len())
+	assert.Equal(t, 100, len(evictedKeys))
+
+	for _, key := range evictedKeys {
+		assert.True(t, strings.HasPrefix(key, "old-"))
+	}
+}
+
+func TestMemoryStorage_evictByEntries(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+
+	var evictedKeys []string
+	storage := &MemoryStorage{
+		MaxEntries: 2,
+		OnEvict: func(key string, bytes []byte) {
+			evictedKeys = append(evictedKeys, key)
+		},
+	}
+
+	// fill the storage
+	assert.Nil(t, storage.Set(ctx, "a", []byte(`A`)))
+	assert.Nil(t, storage.Set(ctx, "b", []byte(`B`)))
+
+	// use "a" so that "b" becomes the least recently used
+	_, resultErr := storage.Get(ctx, "a")
+	assert.Nil(t, resultErr)
+
+	// add another item
+	assert.Nil(t, storage.Set(ctx, "c", []byte(`C`)))
+
+	// validate
+	assert.Equal(t, []string{"b"}, evictedKeys)
+	assert.Equal(t, 2, storage.Len())
+
+	_, resultErr = storage.Get(ctx, "b")
+	assert.Equal(t, ErrCacheMiss, resultErr)
+}
+
+func TestMemoryStorage_evictByBytes(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+
+	var evictedKeys []string
+	storage := &MemoryStorage{
+		MaxBytes: 10,
+		OnEvict: func(key string, bytes []byte) {
+			evictedKeys = append(evictedKeys, key)
+		},
+	}
+
+	// each item is 5 bytes (key + value)
+	assert.Nil(t, storage.Set(ctx, "a", []byte(`AAAA`)))
+	assert.Nil(t, storage.Set(ctx, "b", []byte(`BBBB`)))
+	assert.Nil(t, storage.Set(ctx, "c", []byte(`CCCC`)))
+
+	// validate
+	assert.Equal(t, []string{"a"}, evictedKeys)
+	assert.Equal(t, 2, storage.Len())
+
+	// items that can never fit are rejected
+	resultErr := storage.Set(ctx, "d", []byte(`this is far too large`))
+	assert.Equal(t, ErrItemTooLarge, resultErr)
+}
+
+func TestMemoryStorage_pinned(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+
+	storage := &MemoryStorage{
+		MaxEntries: 2,
+		MaxBytes:   10,
+		TTL:        1 * time.Second,
+	}
+
+	// pin the least recently used item
+	assert.Nil(t, storage.SetWithTTL(withPinned(ctx), "pinned", []byte(`PIN`), 1*time.Hour))
+
+	// fill the storage many times over
+	for _, key := range []string{"a", "b", "c", "d"} {
+		assert.Nil(t, storage.Set(ctx, key, []byte(`AAAA`)))
+	}
+
+	// validate the pinned item is kept (and does not count towards the limits)
+	result, resultErr := storage.Get(ctx, "pinned")
+	assert.Nil(t, resultErr)
+	assert.Equal(t, []byte(`PIN`), result)
+	assert.Equal(t, 3, storage.Len())
+
+	// the storage TTL does not apply to the pinned item
+	storage.now = func() time.Time {
+		return time.Now().Add(1 * time.Minute)
+	}
+
+	result, resultErr = storage.Get(ctx, "pinned")
+	assert.Nil(t, resultErr)
+	assert.Equal(t, []byte(`PIN`), result)
+
+	_, resultErr = storage.Get(ctx, "d")
+	assert.Equal(t, ErrCacheMiss, resultErr)
+
+	// replacing the pinned item with an unpinned one makes it evictable again
+	assert.Nil(t, storage.Set(ctx, "pinned", []byte(`PIN`)))
+	assert.Nil(t, storage.Set(ctx, "e", []byte(`AAAA`)))
+	assert.Nil(t, storage.Set(ctx, "f", []byte(`AAAA`)))
+
+	_, resultErr = storage.Get(ctx, "pinned")
+	assert.Equal(t, ErrCacheMiss, resultErr)
+}
+
+func TestMemoryStorage_concurrentUse(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+
+	storage := &MemoryStorage{
+		MaxEntries: 50,
+		TTL:        time.Millisecond,
+	}
+
+	wg := &sync.WaitGroup{}
+	for x := 0; x < 10; x++ {
+		wg.Add(1)
+
+		go func(x int) {
+			defer wg.Done()
+
+			for y := 0; y < 1000; y++ {
+				key := fmt.Sprintf("key.%d", (x*y)%100)
+
+				_ = storage.Set(ctx, key, []byte(key))
+				_, _ = storage.Get(ctx, key)
+				if y%10 == 0 {
+					_ = storage.Invalidate(ctx, key)
+				}
+			}
+		}(x)
+	}
+	wg.Wait()
+
+	assert.True(t, storage.Len() <= 50)
+}
+
+func TestMemoryStorage_InvalidateTag(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+
+	storage := &MemoryStorage{
+		MaxEntries: 3,
+	}
+
+	// set and tag the values
+	assert.Nil(t, storage.Set(ctx, "a", []byte(`A`)))
+	assert.Nil(t, storage.Tag(ctx, "a", []string{"user:1", "user:2"}, 0))
+	assert.Nil(t, storage.Set(ctx, "b", []byte(`B`)))
+	assert.Nil(t, storage.Tag(ctx, "b", []string{"user:1"}, 0))
+	assert.Nil(t, storage.Set(ctx, "c", []byte(`C`)))
+	assert.Nil(t, storage.Tag(ctx, "c", []string{"user:2"}, 0))
+
+	// tagging a missing key does nothing
+	assert.Nil(t, storage.Tag(ctx, "missing", []string{"user:1"}, 0))
+
+	// invalidate
+	resultErr := storage.InvalidateTag(ctx, "user:1")
+	assert.Nil(t, resultErr)
+
+	// validate
+	_, resultErr = storage.Get(ctx, "a")
+	assert.Equal(t, ErrCacheMiss, resultErr)
+	_, resultErr = storage.Get(ctx, "b")
+	assert.Equal(t, ErrCacheMiss, resultErr)
+	_, resultErr = storage.Get(ctx, "c")
+	assert.Nil(t, resultErr)
+
+	// validate removed items are no longer tracked by the tags
+	assert.Equal(t, map[string]map[string]struct{}{"user:2": {"c": {}}}, storage.tags)
+
+	// overwritten items lose their tags
+	assert.Nil(t, storage.Set(ctx, "c", []byte(`C2`)))
+	assert.Nil(t, storage.InvalidateTag(ctx, "user:2"))
+
+	_, resultErr = storage.Get(ctx, "c")
+	assert.Nil(t, resultErr)
+}
+
+func TestMemoryStorage_Purge(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+
+	storage := &MemoryStorage{}
+	assert.Nil(t, storage.Set(ctx, "a", []byte(`A`)))
+	assert.Nil(t, storage.Tag(ctx, "a", []string{"user:1"}, 0))
+
+	// make the call
+	storage.Purge()
+
+	// validate
+	assert.Equal(t, 0, storage.Len())
+
+	_, resultErr := storage.Get(ctx, "a")
+	assert.Equal(t, ErrCacheMiss, resultErr)
+
+	// the storage remains usable
+	assert.Nil(t, storage.Set(ctx, "b", []byte(`B`)))
+	assert.Equal(t, 1, storage.Len())
+}
@@ -16,33 +16,68 @@ package cache
 
 import (
 	"context"
+	"strconv"
 	"sync"
 	"time"
 
-	"github.com/afex/hystrix-go/hystrix"
 	"github.com/garyburd/redigo/redis"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// default maximum duration of each call to redis
+const defaultRedisTimeout = 1 * time.Second
+
 // RedisStorage implements Storage
 //
-// It is strongly recommended that users customize the circuit breaker settings with a call similar to:
-//
-//    hystrix.ConfigureCommand(cache.CbRedisStorage, hystrix.CommandConfig{
-//        Timeout: 1 * 1000,
-//        MaxConcurrentRequests: 1000,
-//        ErrorPercentThreshold: 50,
-//        })
+// Each call uses the context's deadline (limited to Timeout) as the read timeout of the connection; calls return as
+// soon as the context is done.  Connections dialled with RedisDial are closed at once when the call's context is done,
+// freeing their slot in the pool; other connections are discarded when the read timeout passes without a reply.
+// Write timeouts can be set on the connections with `redis.DialWriteTimeout()`.
 //
+// Calls are protected by a per instance circuit breaker (see Breaker).  To use a shared hystrix command instead, set
+// Breaker to `&hystrixbreaker.Breaker{Name: cache.CbRedisStorage}` and configure the command with `hystrix.ConfigureCommand()`.
 type RedisStorage struct {
-	// Pool is the redis connection pool (required)
+	// Pool is the redis connection pool; dial its connections with RedisDial so cancelled calls release them at once
+	// (required; unless created with NewRedisClusterStorage)
 	Pool *redis.Pool
 
-	// TTL is the max TTL for cache items (required)
+	// TTL is the default TTL for cache items; it can be overridden per item with SetWithTTL (required)
 	TTL time.Duration
 
+	// Timeout is the maximum duration of each call; the context's deadline is used when it is earlier (optional -
+	// default 1 second)
+	Timeout time.Duration
+
+	// TracerProvider enables OpenTelemetry spans for every redis command (optional - default disabled)
+	TracerProvider trace.TracerProvider
+
+	// Breaker protects the calls to the server (optional - default is a NativeBreaker with its default settings).
+	// Use NoopBreaker to disable the circuit breaker.
+	Breaker CircuitBreaker
+
 	// calculated version of TTL
 	ttlInSeconds int64
 	ttlOnce      sync.Once
+
+	defaultBreaker     CircuitBreaker
+	defaultBreakerOnce sync.Once
+
+	// sends the commands to the server(s); set by NewRedisClusterStorage and NewRedisSentinelStorage
+	router redisRouter
+}
+
+// return the circuit breaker for this storage
+func (r *RedisStorage) getBreaker() CircuitBreaker {
+	if r.Breaker != nil {
+		return r.Breaker
+	}
+
+	r.defaultBreakerOnce.Do(func() {
+		r.defaultBreaker = &NativeBreaker{}
+	})
+
+	return r.defaultBreaker
 }
 
 // Get implements Storage
@@ -65,6 +100,35 @@ func (r *RedisStorage) Set(ctx context.Context, key string, bytes []byte) error
 	return err
 }
 
+// SetWithTTL implements TTLStorage
+func (r *RedisStorage) SetWithTTL(ctx context.Context, key string, bytes []byte, ttl time.Duration) error {
+	_, err := r.do(ctx, redisSetex, key, ttlInSeconds(ttl), bytes)
+	return err
+}
+
+// Add implements AddStorage using SET with the NX option
+func (r *RedisStorage) Add(ctx context.Context, key string, bytes []byte, ttl time.Duration) (bool, error) {
+	ttlSeconds := r.getTTL()
+	if ttl > 0 {
+		ttlSeconds = ttlInSeconds(ttl)
+	}
+
+	resp, err := r.do(ctx, redisSet, key, bytes, "EX", ttlSeconds, "NX")
+	if err != nil {
+		return false, err
+	}
+
+	return resp != nil, nil
+}
+
+func (r *RedisStorage) getTimeout() time.Duration {
+	if r.Timeout > 0 {
+		return r.Timeout
+	}
+
+	return defaultRedisTimeout
+}
+
 // return the number of seconds an item can live for
 func (r *RedisStorage) getTTL() int64 {
 	r.ttlOnce.Do(func() {
@@ -74,27 +138,217 @@ func (r *RedisStorage) getTTL() int64 {
 	return r.ttlInSeconds
 }
 
+// convert the TTL to whole seconds (rounding up as redis does not accept a TTL of 0)
+func ttlInSeconds(ttl time.Duration) int64 {
+	return int64((ttl + time.Second - 1) / time.Second)
+}
+
 // Invalidate implements Storage
 func (r *RedisStorage) Invalidate(ctx context.Context, key string) error {
 	_, err := r.do(ctx, redisExpire, key, 0)
 	return err
 }
 
+// GetMulti implements MultiStorage using MGET
+func (r *RedisStorage) GetMulti(ctx context.Context, keys []string) (map[string][]byte, error) {
+	if len(keys) == 0 {
+		return map[string][]byte{}, nil
+	}
+
+	// keys are only split when using a cluster
+	groups := r.getRouter().splitKeys(keys)
+
+	commands := make([]redisCommand, len(groups))
+	for index, group := range groups {
+		commands[index] = redisCommand{name: redisMget, args: redisArgs(group)}
+	}
+
+	replies, err := r.exec(ctx, redisMget, commands)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(map[string][]byte, len(keys))
+	for index, group := range groups {
+		values, err := redis.ByteSlices(replies[index], nil)
+		if err != nil {
+			return nil, err
+		}
+
+		for keyIndex, value := range values {
+			if value != nil {
+				out[group[keyIndex]] = value
+			}
+		}
+	}
+
+	return out, nil
+}
+
+// SetMulti implements MultiStorage using pipelined SETEX commands
+func (r *RedisStorage) SetMulti(ctx context.Context, items map[string][]byte) error {
+	if len(items) == 0 {
+		return nil
+	}
+
+	commands := make([]redisCommand, 0, len(items))
+	for key, bytes := range items {
+		commands = append(commands, redisCommand{name: redisSetex, args: []interface{}{key, r.getTTL(), bytes}})
+	}
+
+	_, err := r.exec(ctx, redisSetex, commands)
+	return err
+}
+
+// Tag implements TagStorage by adding the key to a redis sorted set per tag, scored by the expiry of the item.
+//
+// Keys that have expired are removed from the sets on each write, so the sets do not grow with keys that are no longer
+// cached.  The tag sets expire after the storage TTL or the item TTL (whichever is longer).
+func (r *RedisStorage) Tag(ctx context.Context, key string, tags []string, ttl time.Duration) error {
+	if len(tags) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	score, ttlSeconds := redisTagExpiry(now, r.getTTL(), ttl)
+
+	commands := make([]redisCommand, 0, len(tags)*3)
+	for _, tag := range tags {
+		commands = append(commands,
+			redisCommand{name: redisZadd, args: []interface{}{redisTagKey(tag), score, key}},
+			redisCommand{name: redisZremrangebyscore, args: []interface{}{redisTagKey(tag), "-inf", redisTagExpired(now)}},
+			redisCommand{name: redisExpire, args: []interface{}{redisTagKey(tag), ttlSeconds}},
+		)
+	}
+
+	_, err := r.exec(ctx, redisZadd, commands)
+	return err
+}
+
+// InvalidateTag implements TagStorage by removing every key in the tag's set.
+//
+// Only the keys that were removed are taken out of the set; keys tagged during the invalidation are retained.
+func (r *RedisStorage) InvalidateTag(ctx context.Context, tag string) error {
+	resp, err := r.do(ctx, redisZrange, redisTagKey(tag), 0, -1)
+	if err != nil {
+		return err
+	}
+
+	keys, err := redis.Strings(resp, nil)
+	if err != nil || len(keys) == 0 {
+		return err
+	}
+
+	// keys are only split when using a cluster
+	groups := r.getRouter().splitKeys(keys)
+
+	commands := make([]redisCommand, 0, len(groups)+1)
+	for _, group := range groups {
+		commands = append(commands, redisCommand{name: redisDel, args: redisArgs(group)})
+	}
+	commands = append(commands, redisCommand{name: redisZrem, args: append([]interface{}{redisTagKey(tag)}, redisArgs(keys)...)})
+
+	_, err = r.exec(ctx, redisDel, commands)
+	return err
+}
+
+// return the key of the redis sorted set that holds the keys for the tag
+func redisTagKey(tag string) string {
+	return redisTagKeyPrefix + tag
+}
+
+// return the score of a key in a tag set (the expiry of the item in epoch seconds) and the TTL of the set; the set is
+// kept for the storage TTL or the item TTL (whichever is longer).  Items without a TTL use the storage TTL.
+func redisTagExpiry(now time.Time, storageTTL int64, ttl time.Duration) (int64, int64) {
+	itemTTL := storageTTL
+	if ttl > 0 {
+		itemTTL = ttlInSeconds(ttl)
+	}
+
+	setTTL := storageTTL
+	if itemTTL > setTTL {
+		setTTL = itemTTL
+	}
+
+	return now.Unix() + itemTTL, setTTL
+}
+
+// return the (exclusive) max score of the keys in a tag set that have expired
+func redisTagExpired(now time.Time) string {
+	return "(" + strconv.FormatInt(now.Unix(), 10)
+}
+
+// return the keys as command arguments
+func redisArgs(keys []string) []interface{} {
+	args := make([]interface{}, len(keys))
+	for index, key := range keys {
+		args[index] = key
+	}
+
+	return args
+}
+
+// return the router that sends the commands to the server(s)
+func (r *RedisStorage) getRouter() redisRouter {
+	if r.router != nil {
+		return r.router
+	}
+
+	return redisPoolRouter{pool: r.Pool}
+}
+
 // calls to redis protected by a circuit breaker
 func (r *RedisStorage) do(ctx context.Context, command string, args ...interface{}) (interface{}, error) {
-	resultCh := make(chan interface{}, 1)
-	errorCh := hystrix.Go(CbRedisStorage, func() error {
-		con := r.Pool.Get()
+	replies, err := r.exec(ctx, command, []redisCommand{{name: command, args: args}})
+	if err != nil {
+		return nil, err
+	}
+
+	return replies[0], nil
+}
+
+// pipelined calls to redis protected by a circuit breaker; name is the command used to identify the calls in spans
+func (r *RedisStorage) exec(ctx context.Context, name string, commands []redisCommand) (replies []interface{}, err error) {
+	// limit the read timeout to Timeout
+	ctx, cancelFn := context.WithTimeout(ctx, r.getTimeout())
+	defer cancelFn()
+
+	var attrs []attribute.KeyValue
+	if len(commands) > 1 {
+		attrs = append(attrs, attribute.Bool("db.redis.pipeline", true))
+	}
+
+	ctx, span := startStorageSpan(ctx, r.TracerProvider, "redis", name, attrs...)
+	defer func() {
+		size := 0
+		for index, command := range commands {
+			var reply interface{}
+			if index < len(replies) {
+				reply = replies[index]
+			}
 
-		reply, err := con.Do(command, args...)
+			size += payloadSize(reply, command.args)
+		}
+
+		endSpan(span, size, err)
+	}()
+
+	resultCh := make(chan []interface{}, 1)
+	errorCh := goWithBreaker(ctx, r.getBreaker(), func() error {
+		replies, err := r.getRouter().exec(ctx, commands)
+		if err != nil {
+			return err
+		}
+
+		err = redisReplyError(replies)
 		if err != nil {
 			return err
 		}
 
-		resultCh <- reply
+		resultCh <- replies
 
 		return nil
-	}, nil)
+	})
 
 	select {
 	case result := <-resultCh:
@@ -110,3 +364,19 @@ func (r *RedisStorage) do(ctx context.Context, command string, args ...interface
 		return nil, err
 	}
 }
+
+// return the total size of the values sent to or received from redis
+func payloadSize(reply interface{}, args []interface{}) int {
+	size := 0
+	for _, value := range append([]interface{}{reply}, args...) {
+		switch typed := value.(type) {
+		case []byte:
+			size += len(typed)
+
+		case []interface{}:
+			size += payloadSize(nil, typed)
+		}
+	}
+
+	return size
+}
@@ -0,0 +1,422 @@
+// Copyright 2017 Corey Scott http://www.sage42.org/
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+const (
+	// number of hash slots in a redis cluster
+	redisClusterSlots = 16384
+
+	defaultRedisMaxIdle      = 10
+	defaultRedisMaxRedirects = 3
+)
+
+// RedisClusterConfig is the configuration for NewRedisClusterStorage
+type RedisClusterConfig struct {
+	// Addrs are the addresses (host:port) of 1 or more nodes used to discover the cluster (required)
+	Addrs []string
+
+	// DialOptions are used when connecting to each node (optional)
+	DialOptions []redis.DialOption
+
+	// MaxIdle is the maximum number of idle connections per node (optional - default 10)
+	MaxIdle int
+
+	// IdleTimeout closes connections that have been idle for this duration (optional - default disabled)
+	IdleTimeout time.Duration
+
+	// MaxRedirects is the maximum number of MOVED/ASK redirects followed per command (optional - default 3)
+	MaxRedirects int
+}
+
+func (c *RedisClusterConfig) getMaxIdle() int {
+	if c.MaxIdle > 0 {
+		return c.MaxIdle
+	}
+
+	return defaultRedisMaxIdle
+}
+
+func (c *RedisClusterConfig) getMaxRedirects() int {
+	if c.MaxRedirects > 0 {
+		return c.MaxRedirects
+	}
+
+	return defaultRedisMaxRedirects
+}
+
+// NewRedisClusterStorage returns a RedisStorage that sends each command to the Redis Cluster node that serves the hash
+// slot of its key.
+//
+// The slots are discovered with CLUSTER SLOTS (from any of the Addrs) and reloaded after a node replies with MOVED;
+// ASK redirects (sent while a slot is being migrated) are followed without reloading.  Multi-key commands (used by
+// GetMulti and InvalidateTag) are split by hash slot; hash tags (e.g. `{user:1}:profile`) can be used to keep related
+// keys in the same slot.
+//
+// The returned storage has no Pool; a RedisInvalidationBus can use a pool connected to any node of the cluster.
+func NewRedisClusterStorage(config *RedisClusterConfig, ttl time.Duration) *RedisStorage {
+	return &RedisStorage{
+		TTL:    ttl,
+		router: &redisClusterRouter{config: config},
+	}
+}
+
+// redisClusterRouter implements redisRouter by sending each command to the node that serves the slot of its key
+type redisClusterRouter struct {
+	config *RedisClusterConfig
+
+	// address of the master of each slot; nil when the slots need to be loaded
+	slots      []string
+	slotsMutex sync.Mutex
+
+	// coalesces concurrent loads of the slots
+	loading coalescer
+
+	// connection pool per node address
+	pools      map[string]*redis.Pool
+	poolsMutex sync.Mutex
+}
+
+// exec implements redisRouter
+func (c *redisClusterRouter) exec(ctx context.Context, commands []redisCommand) ([]interface{}, error) {
+	slots, err := c.getSlots(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// group the commands by node
+	var addrs []string
+	indexes := map[string][]int{}
+
+	for index, command := range commands {
+		slot := redisSlot(command.key())
+
+		addr := slots[slot]
+		if addr == "" {
+			return nil, fmt.Errorf("no redis cluster node serves slot %d", slot)
+		}
+
+		if _, found := indexes[addr]; !found {
+			addrs = append(addrs, addr)
+		}
+		indexes[addr] = append(indexes[addr], index)
+	}
+
+	replies := make([]interface{}, len(commands))
+	for _, addr := range addrs {
+		nodeCommands := make([]redisCommand, len(indexes[addr]))
+		for x, index := range indexes[addr] {
+			nodeCommands[x] = commands[index]
+		}
+
+		nodeReplies, err := c.send(ctx, addr, false, nodeCommands)
+		if err != nil {
+			// the node may have left the cluster
+			c.resetSlots()
+			return nil, err
+		}
+
+		for x, index := range indexes[addr] {
+			replies[index] = nodeReplies[x]
+		}
+	}
+
+	// follow redirects
+	for index := range replies {
+		for redirects := 0; redirects < c.config.getMaxRedirects(); redirects++ {
+			kind, addr, isRedirect := parseRedisRedirect(replies[index])
+			if !isRedirect {
+				break
+			}
+
+			if kind == redisMoved {
+				// the slot has a new owner
+				c.resetSlots()
+			}
+
+			nodeReplies, err := c.send(ctx, addr, kind == redisAsk, commands[index:index+1])
+			if err != nil {
+				return nil, err
+			}
+
+			replies[index] = nodeReplies[0]
+		}
+	}
+
+	return replies, nil
+}
+
+// splitKeys implements redisRouter by grouping the keys by slot
+func (c *redisClusterRouter) splitKeys(keys []string) [][]string {
+	var groups [][]string
+	indexes := map[int]int{}
+
+	for _, key := range keys {
+		slot := redisSlot(key)
+
+		index, found := indexes[slot]
+		if !found {
+			index = len(groups)
+			indexes[slot] = index
+			groups = append(groups, nil)
+		}
+
+		groups[index] = append(groups[index], key)
+	}
+
+	return groups
+}
+
+// send the commands to the node; when asking is set, the commands are preceded by ASKING
+func (c *redisClusterRouter) send(ctx context.Context, addr string, asking bool, commands []redisCommand) ([]interface{}, error) {
+	con, err := c.getPool(addr).GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = con.Close()
+	}()
+
+	if !asking {
+		return redisPipeline(ctx, con, commands)
+	}
+
+	replies, err := redisPipeline(ctx, con, append([]redisCommand{{name: redisAsking}}, commands...))
+	if err != nil {
+		return nil, err
+	}
+
+	return replies[1:], nil
+}
+
+// return the address of the master of each slot; loading them from the cluster when required.
+//
+// The slots are loaded without holding slotsMutex and concurrent callers share the result of a single load.
+func (c *redisClusterRouter) getSlots(ctx context.Context) ([]string, error) {
+	c.slotsMutex.Lock()
+	slots := c.slots
+	c.slotsMutex.Unlock()
+
+	if slots != nil {
+		return slots, nil
+	}
+
+	_, shared, err := c.loading.do(ctx, redisClusterCommand, func() ([]byte, error) {
+		var err error
+
+		slots, err = c.loadAnySlots(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		c.slotsMutex.Lock()
+		c.slots = slots
+		c.slotsMutex.Unlock()
+
+		return nil, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !shared {
+		return slots, nil
+	}
+
+	// loaded by another caller (or reset again since)
+	return c.getSlots(ctx)
+}
+
+// load the slots from the first node (of the known addresses) that answers.
+//
+// The load is shared by other callers so it ignores the cancellation of the caller's context (but not its deadline).
+func (c *redisClusterRouter) loadAnySlots(ctx context.Context) ([]string, error) {
+	loadCtx := context.WithoutCancel(ctx)
+	if deadline, ok := ctx.Deadline(); ok {
+		var cancelFn context.CancelFunc
+
+		loadCtx, cancelFn = context.WithDeadline(loadCtx, deadline)
+		defer cancelFn()
+	}
+
+	err := errors.New("no redis cluster addresses")
+	for _, addr := range c.knownAddrs() {
+		var slots []string
+
+		slots, err = c.loadSlots(loadCtx, addr)
+		if err == nil {
+			return slots, nil
+		}
+	}
+
+	return nil, err
+}
+
+// load the slots from the node using CLUSTER SLOTS
+func (c *redisClusterRouter) loadSlots(ctx context.Context, addr string) ([]string, error) {
+	con, err := c.getPool(addr).GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = con.Close()
+	}()
+
+	timeout, err := redisReadTimeout(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ranges, err := redis.Values(redis.DoWithTimeout(con, timeout, redisClusterCommand, redisClusterSlotsArg))
+	if err != nil {
+		return nil, err
+	}
+
+	slots := make([]string, redisClusterSlots)
+	for _, slotRange := range ranges {
+		// start slot, end slot, master [host, port, id], replicas...
+		fields, err := redis.Values(slotRange, nil)
+		if err != nil || len(fields) < 3 {
+			return nil, fmt.Errorf("unexpected CLUSTER SLOTS reply: %v", slotRange)
+		}
+
+		master, err := redis.Values(fields[2], nil)
+		if err != nil || len(master) < 2 {
+			return nil, fmt.Errorf("unexpected CLUSTER SLOTS reply: %v", slotRange)
+		}
+
+		start, _ := redis.Int(fields[0], nil)
+		end, _ := redis.Int(fields[1], nil)
+		host, _ := redis.String(master[0], nil)
+		port, _ := redis.Int(master[1], nil)
+
+		if host == "" {
+			// the node does not know its own address
+			host, _, _ = net.SplitHostPort(addr)
+		}
+
+		masterAddr := net.JoinHostPort(host, strconv.Itoa(port))
+		for slot := start; slot <= end && slot < redisClusterSlots; slot++ {
+			slots[slot] = masterAddr
+		}
+	}
+
+	return slots, nil
+}
+
+// discard the slots so that they are reloaded by the next call
+func (c *redisClusterRouter) resetSlots() {
+	c.slotsMutex.Lock()
+	defer c.slotsMutex.Unlock()
+
+	c.slots = nil
+}
+
+// return the configured addresses followed by the addresses of the other nodes seen so far
+func (c *redisClusterRouter) knownAddrs() []string {
+	c.poolsMutex.Lock()
+	defer c.poolsMutex.Unlock()
+
+	out := append([]string{}, c.config.Addrs...)
+	for addr := range c.pools {
+		if !slices.Contains(c.config.Addrs, addr) {
+			out = append(out, addr)
+		}
+	}
+
+	return out
+}
+
+// return the connection pool for the node
+func (c *redisClusterRouter) getPool(addr string) *redis.Pool {
+	c.poolsMutex.Lock()
+	defer c.poolsMutex.Unlock()
+
+	if c.pools == nil {
+		c.pools = map[string]*redis.Pool{}
+	}
+
+	pool, found := c.pools[addr]
+	if !found {
+		pool = &redis.Pool{
+			MaxIdle:     c.config.getMaxIdle(),
+			IdleTimeout: c.config.IdleTimeout,
+			Dial: func() (redis.Conn, error) {
+				return RedisDial("tcp", addr, c.config.DialOptions...)
+			},
+		}
+		c.pools[addr] = pool
+	}
+
+	return pool
+}
+
+// return the type (MOVED or ASK) and target address when the reply is a redirect
+// (e.g. `MOVED 3999 127.0.0.1:6381`)
+func parseRedisRedirect(reply interface{}) (kind string, addr string, isRedirect bool) {
+	redisErr, ok := reply.(redis.Error)
+	if !ok {
+		return "", "", false
+	}
+
+	fields := strings.Fields(string(redisErr))
+	if len(fields) != 3 || (fields[0] != redisMoved && fields[0] != redisAsk) {
+		return "", "", false
+	}
+
+	return fields[0], fields[2], true
+}
+
+// return the hash slot of the key; when the key contains a hash tag (e.g. `{user:1}:profile`) only the tag is hashed
+func redisSlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+
+	return int(crc16(key) % redisClusterSlots)
+}
+
+// CRC16 (XMODEM) as used by redis cluster
+func crc16(key string) uint16 {
+	var crc uint16
+	for index := 0; index < len(key); index++ {
+		crc ^= uint16(key[index]) << 8
+
+		for bit := 0; bit < 8; bit++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+
+	return crc
+}
@@ -0,0 +1,224 @@
+// Copyright 2017 Corey Scott http://www.sage42.org/
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedisSlot(t *testing.T) {
+	assert.Equal(t, uint16(0x31C3), crc16("123456789"))
+
+	assert.Equal(t, 12182, redisSlot("foo"))
+	assert.Equal(t, 5061, redisSlot("bar"))
+
+	// hash tags
+	assert.Equal(t, redisSlot("user1000"), redisSlot("{user1000}.following"))
+	assert.Equal(t, redisSlot("{user1000}.following"), redisSlot("{user1000}.followers"))
+	assert.Equal(t, redisSlot("foo{}{bar}"), redisSlot("foo{}{bar}"))
+	assert.NotEqual(t, redisSlot("bar"), redisSlot("foo{}{bar}"))
+}
+
+func TestRedisClusterStorage_happyPath(t *testing.T) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelFn()
+
+	cluster := newFakeRedisCluster(t, 3)
+	storage := NewRedisClusterStorage(&RedisClusterConfig{
+		Addrs: []string{cluster.nodes[0].addr()},
+	}, 60*time.Second)
+
+	keys := testClusterKeys(cluster)
+
+	// make the calls
+	for _, key := range keys {
+		assert.Nil(t, storage.Set(ctx, key, []byte(key)))
+	}
+
+	// validate
+	for _, key := range keys {
+		assert.Equal(t, []byte(key), cluster.ownerOf(key).get(key))
+
+		result, resultErr := storage.Get(ctx, key)
+		assert.Nil(t, resultErr)
+		assert.Equal(t, []byte(key), result)
+	}
+
+	results, resultErr := storage.GetMulti(ctx, append(keys, "unknown"))
+	assert.Nil(t, resultErr)
+	assert.Equal(t, len(keys), len(results))
+	for _, key := range keys {
+		assert.Equal(t, []byte(key), results[key])
+	}
+
+	// tags cover keys on every node
+	for _, key := range keys {
+		assert.Nil(t, storage.Tag(ctx, key, []string{"all"}, 0))
+	}
+	assert.Nil(t, storage.InvalidateTag(ctx, "all"))
+
+	results, resultErr = storage.GetMulti(ctx, keys)
+	assert.Nil(t, resultErr)
+	assert.Equal(t, 0, len(results))
+}
+
+func TestRedisClusterStorage_moved(t *testing.T) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelFn()
+
+	cluster := newFakeRedisCluster(t, 2)
+	storage := NewRedisClusterStorage(&RedisClusterConfig{
+		Addrs: []string{cluster.nodes[0].addr()},
+	}, 60*time.Second)
+
+	key := "foo"
+	oldOwner := cluster.ownerOf(key)
+	newOwner := cluster.nodes[0]
+	if oldOwner == newOwner {
+		newOwner = cluster.nodes[1]
+	}
+
+	assert.Nil(t, storage.Set(ctx, key, []byte(`bar`)))
+	assert.Equal(t, []byte(`bar`), oldOwner.get(key))
+
+	// move the slot
+	cluster.move(key, newOwner)
+
+	// make the call
+	resultErr := storage.Set(ctx, key, []byte(`baz`))
+
+	// validate
+	assert.Nil(t, resultErr)
+	assert.Equal(t, []byte(`baz`), newOwner.get(key))
+
+	// the slots were reloaded so the old owner is no longer used
+	commands := oldOwner.commandCount()
+
+	result, resultErr := storage.Get(ctx, key)
+	assert.Nil(t, resultErr)
+	assert.Equal(t, []byte(`baz`), result)
+	assert.Equal(t, commands, oldOwner.commandCount())
+}
+
+func TestRedisClusterStorage_ask(t *testing.T) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelFn()
+
+	cluster := newFakeRedisCluster(t, 2)
+	storage := NewRedisClusterStorage(&RedisClusterConfig{
+		Addrs: []string{cluster.nodes[0].addr()},
+	}, 60*time.Second)
+
+	key := "foo"
+	owner := cluster.ownerOf(key)
+	target := cluster.nodes[0]
+	if owner == target {
+		target = cluster.nodes[1]
+	}
+
+	// migrate the key
+	cluster.migrate(key, target)
+
+	// make the calls
+	assert.Nil(t, storage.Set(ctx, key, []byte(`bar`)))
+
+	result, resultErr := storage.Get(ctx, key)
+
+	// validate
+	assert.Nil(t, resultErr)
+	assert.Equal(t, []byte(`bar`), result)
+	assert.Equal(t, []byte(`bar`), target.get(key))
+	assert.Nil(t, owner.get(key))
+}
+
+func TestRedisClusterStorage_unavailable(t *testing.T) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelFn()
+
+	storage := NewRedisClusterStorage(&RedisClusterConfig{
+		Addrs: []string{"127.0.0.1:1"},
+	}, 60*time.Second)
+
+	// make the call
+	_, resultErr := storage.Get(ctx, "foo")
+
+	// validate
+	assert.NotNil(t, resultErr)
+}
+
+func TestRedisClusterRouter_getSlotsCoalesced(t *testing.T) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelFn()
+
+	cluster := newFakeRedisCluster(t, 3)
+	cluster.nodes[0].setDelay(200 * time.Millisecond)
+
+	router := &redisClusterRouter{config: &RedisClusterConfig{
+		Addrs: []string{cluster.nodes[0].addr()},
+	}}
+
+	// make the calls
+	wg := &sync.WaitGroup{}
+	for x := 0; x < 10; x++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			slots, err := router.getSlots(ctx)
+			assert.Nil(t, err)
+			assert.Equal(t, redisClusterSlots, len(slots))
+		}()
+	}
+
+	// the mutex is not held while the slots are loaded
+	<-time.After(50 * time.Millisecond)
+	assert.True(t, router.slotsMutex.TryLock())
+	router.slotsMutex.Unlock()
+
+	wg.Wait()
+
+	// validate
+	assert.Equal(t, 1, cluster.nodes[0].commandCount())
+}
+
+func TestRedisClusterRouter_splitKeys(t *testing.T) {
+	router := &redisClusterRouter{config: &RedisClusterConfig{}}
+
+	// make the call
+	result := router.splitKeys([]string{"{a}1", "{b}1", "{a}2", "{b}2", "{c}1"})
+
+	// validate
+	assert.Equal(t, [][]string{{"{a}1", "{a}2"}, {"{b}1", "{b}2"}, {"{c}1"}}, result)
+}
+
+// return keys so that every node of the cluster owns at least 1
+func testClusterKeys(cluster *fakeRedisCluster) []string {
+	var keys []string
+	owners := map[*fakeRedis]bool{}
+
+	for x := 0; len(owners) < len(cluster.nodes); x++ {
+		key := fmt.Sprintf("%s-%d", getTestKey(), x)
+		keys = append(keys, key)
+		owners[cluster.ownerOf(key)] = true
+	}
+
+	return keys
+}
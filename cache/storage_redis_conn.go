@@ -0,0 +1,91 @@
+// Copyright 2017 Corey Scott http://www.sage42.org/
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// RedisDial connects to the redis server at the address (see redis.Dial).
+//
+// RedisStorage closes connections dialled with RedisDial as soon as the context of their call is done, which
+// immediately frees the connection's slot in the pool.  Connections dialled otherwise are closed (and their slot freed)
+// when the read timeout passes (see RedisStorage.Timeout), as a pooled connection cannot otherwise be closed while its
+// reply is being read.
+func RedisDial(network string, address string, options ...redis.DialOption) (redis.Conn, error) {
+	con, err := redis.Dial(network, address, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &redisConn{Conn: con}, nil
+}
+
+// redisConn is a connection that can be closed (by another goroutine) while its reply is being read
+type redisConn struct {
+	redis.Conn
+}
+
+// request for the redisConn underneath a pooled connection; see redisConnOf
+type redisConnRequest struct {
+	conn *redisConn
+}
+
+// Do implements redis.Conn; answering requests for the connection
+func (c *redisConn) Do(commandName string, args ...interface{}) (interface{}, error) {
+	if request, ok := redisConnRequestOf(commandName, args); ok {
+		request.conn = c
+		return nil, nil
+	}
+
+	return c.Conn.Do(commandName, args...)
+}
+
+// DoWithTimeout implements redis.ConnWithTimeout
+func (c *redisConn) DoWithTimeout(timeout time.Duration, commandName string, args ...interface{}) (interface{}, error) {
+	return redis.DoWithTimeout(c.Conn, timeout, commandName, args...)
+}
+
+// ReceiveWithTimeout implements redis.ConnWithTimeout
+func (c *redisConn) ReceiveWithTimeout(timeout time.Duration) (interface{}, error) {
+	return redis.ReceiveWithTimeout(c.Conn, timeout)
+}
+
+// return the redisConn underneath the (pooled) connection; returns nil when it was not dialled with RedisDial.
+//
+// The pool does not expose its connections, so the request is sent through it as `Do("")` which every connection
+// treats as a no-op flush when nothing has been sent.
+func redisConnOf(con redis.Conn) *redisConn {
+	if typed, ok := con.(*redisConn); ok {
+		return typed
+	}
+
+	request := &redisConnRequest{}
+	_, _ = con.Do("", request)
+
+	return request.conn
+}
+
+// return the request for the connection (if the call is one)
+func redisConnRequestOf(commandName string, args []interface{}) (*redisConnRequest, bool) {
+	if commandName != "" || len(args) != 1 {
+		return nil, false
+	}
+
+	request, ok := args[0].(*redisConnRequest)
+	return request, ok
+}
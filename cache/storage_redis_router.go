@@ -0,0 +1,151 @@
+// Copyright 2017 Corey Scott http://www.sage42.org/
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// a redis command; the first argument is the key used to route the command
+type redisCommand struct {
+	name string
+	args []interface{}
+}
+
+// return the key used to route the command
+func (c redisCommand) key() string {
+	if len(c.args) == 0 {
+		return ""
+	}
+
+	switch typed := c.args[0].(type) {
+	case string:
+		return typed
+
+	case []byte:
+		return string(typed)
+
+	default:
+		return fmt.Sprint(typed)
+	}
+}
+
+// redisRouter sends commands to the redis server(s) that serve their keys
+type redisRouter interface {
+	// exec sends the commands (pipelined where possible) and returns a reply per command.  Errors returned by the
+	// server are returned as replies of type redis.Error.
+	exec(ctx context.Context, commands []redisCommand) ([]interface{}, error)
+
+	// splitKeys groups the keys so that each group can be used by a single multi-key command
+	splitKeys(keys []string) [][]string
+}
+
+// redisPoolRouter sends every command to a single server using the pool
+type redisPoolRouter struct {
+	pool *redis.Pool
+}
+
+// exec implements redisRouter
+func (p redisPoolRouter) exec(ctx context.Context, commands []redisCommand) ([]interface{}, error) {
+	con, err := p.pool.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = con.Close()
+	}()
+
+	return redisPipeline(ctx, con, commands)
+}
+
+// splitKeys implements redisRouter
+func (p redisPoolRouter) splitKeys(keys []string) [][]string {
+	return [][]string{keys}
+}
+
+// send the commands on the connection and receive the replies; errors returned by the server are returned as replies.
+//
+// The replies are read with the context's deadline as the read timeout.  Connections dialled with RedisDial are closed
+// as soon as the context is done; the replies can no longer be read and the pool discards the connection.
+func redisPipeline(ctx context.Context, con redis.Conn, commands []redisCommand) ([]interface{}, error) {
+	timeout, err := redisReadTimeout(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if closable := redisConnOf(con); closable != nil {
+		stop := context.AfterFunc(ctx, func() {
+			_ = closable.Close()
+		})
+		defer stop()
+	}
+
+	for _, command := range commands {
+		err = con.Send(command.name, command.args...)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// flush and receive every pending reply
+	replies, err := redis.Values(redis.DoWithTimeout(con, timeout, ""))
+	if err != nil && ctx.Err() != nil {
+		// the connection was closed as the context is done
+		return nil, ctx.Err()
+	}
+
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() && timeout > 0 {
+		// the read timeout is the context's deadline
+		return nil, context.DeadlineExceeded
+	}
+
+	return replies, err
+}
+
+// return the time remaining until the context's deadline (or 0 when it has no deadline)
+func redisReadTimeout(ctx context.Context) (time.Duration, error) {
+	err := ctx.Err()
+	if err != nil {
+		return 0, err
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return 0, nil
+	}
+
+	timeout := time.Until(deadline)
+	if timeout <= 0 {
+		return 0, context.DeadlineExceeded
+	}
+
+	return timeout, nil
+}
+
+// return the first error returned by the server (if any)
+func redisReplyError(replies []interface{}) error {
+	for _, reply := range replies {
+		if redisErr, ok := reply.(redis.Error); ok {
+			return redisErr
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,84 @@
+// Copyright 2017 Corey Scott http://www.sage42.org/
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedisStorage_fakeServer(t *testing.T) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelFn()
+
+	server := newFakeRedis(t)
+	storage := &RedisStorage{
+		Pool: &redis.Pool{
+			Dial: func() (redis.Conn, error) { return redis.Dial("tcp", server.addr()) },
+		},
+		TTL: 60 * time.Second,
+	}
+
+	// make the calls
+	assert.Nil(t, storage.SetMulti(ctx, map[string][]byte{"a": []byte(`A`), "b": []byte(`B`)}))
+	assert.Nil(t, storage.Tag(ctx, "a", []string{"letters"}, 0))
+
+	_, resultErr := storage.Get(ctx, "c")
+	assert.Equal(t, ErrCacheMiss, resultErr)
+
+	results, resultErr := storage.GetMulti(ctx, []string{"a", "b", "c"})
+	assert.Nil(t, resultErr)
+	assert.Equal(t, map[string][]byte{"a": []byte(`A`), "b": []byte(`B`)}, results)
+
+	assert.Nil(t, storage.InvalidateTag(ctx, "letters"))
+
+	// validate
+	results, resultErr = storage.GetMulti(ctx, []string{"a", "b", "c"})
+	assert.Nil(t, resultErr)
+	assert.Equal(t, map[string][]byte{"b": []byte(`B`)}, results)
+}
+
+func TestRedisPipeline_serverErrors(t *testing.T) {
+	server := newFakeRedis(t)
+
+	con, err := redis.Dial("tcp", server.addr())
+	assert.Nil(t, err)
+	defer func() {
+		_ = con.Close()
+	}()
+
+	// make the call
+	replies, resultErr := redisPipeline(context.Background(), con, []redisCommand{
+		{name: redisSetex, args: []interface{}{"foo", 60, "bar"}},
+		{name: "UNKNOWN", args: []interface{}{"foo"}},
+		{name: redisGet, args: []interface{}{"foo"}},
+	})
+
+	// validate
+	assert.Nil(t, resultErr)
+	assert.Equal(t, 3, len(replies))
+	assert.Equal(t, []byte(`bar`), replies[2])
+	assert.Equal(t, redis.Error("ERR unknown command 'UNKNOWN'"), redisReplyError(replies))
+}
+
+func TestRedisCommand_key(t *testing.T) {
+	assert.Equal(t, "foo", redisCommand{name: redisGet, args: []interface{}{"foo"}}.key())
+	assert.Equal(t, "foo", redisCommand{name: redisGet, args: []interface{}{[]byte(`foo`)}}.key())
+	assert.Equal(t, "", redisCommand{name: redisAsking}.key())
+}
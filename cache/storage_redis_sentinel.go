@@ -0,0 +1,303 @@
+// Copyright 2017 Corey Scott http://www.sage42.org/
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// returned by TestOnBorrow for connections to a previous master
+var errRedisMasterChanged = errors.New("redis master has changed")
+
+// RedisSentinelConfig is the configuration for NewRedisSentinelStorage
+type RedisSentinelConfig struct {
+	// Addrs are the addresses (host:port) of the sentinels (required)
+	Addrs []string
+
+	// MasterName is the name of the master monitored by the sentinels (required)
+	MasterName string
+
+	// DialOptions are used when connecting to the master (optional)
+	DialOptions []redis.DialOption
+
+	// SentinelDialOptions are used when connecting to the sentinels (optional)
+	SentinelDialOptions []redis.DialOption
+
+	// DialTimeout is the connect timeout when connecting to the sentinels and the master, and the read timeout of the
+	// queries to the sentinels and the master's ROLE check (optional - default 1 second).  Timeouts in DialOptions and
+	// SentinelDialOptions take precedence.
+	DialTimeout time.Duration
+
+	// MaxIdle is the maximum number of idle connections (optional - default 10)
+	MaxIdle int
+
+	// IdleTimeout closes connections that have been idle for this duration (optional - default disabled)
+	IdleTimeout time.Duration
+}
+
+func (c *RedisSentinelConfig) getMaxIdle() int {
+	if c.MaxIdle > 0 {
+		return c.MaxIdle
+	}
+
+	return defaultRedisMaxIdle
+}
+
+func (c *RedisSentinelConfig) getDialTimeout() time.Duration {
+	if c.DialTimeout > 0 {
+		return c.DialTimeout
+	}
+
+	return defaultRedisTimeout
+}
+
+// return the options used to connect to the sentinels
+func (c *RedisSentinelConfig) sentinelDialOptions() []redis.DialOption {
+	timeout := c.getDialTimeout()
+
+	options := []redis.DialOption{
+		redis.DialConnectTimeout(timeout),
+		redis.DialReadTimeout(timeout),
+		redis.DialWriteTimeout(timeout),
+	}
+
+	return append(options, c.SentinelDialOptions...)
+}
+
+// return the options used to connect to the master; the read timeout is left to the commands
+func (c *RedisSentinelConfig) masterDialOptions() []redis.DialOption {
+	options := []redis.DialOption{
+		redis.DialConnectTimeout(c.getDialTimeout()),
+	}
+
+	return append(options, c.DialOptions...)
+}
+
+// NewRedisSentinelStorage returns a RedisStorage that connects to the master discovered from Redis Sentinel.
+//
+// The master's address is cached.  When a command fails with a connection error or a READONLY error (i.e. the master
+// was demoted during a failover) the master is discovered again and connections to the previous master are closed
+// instead of being reused.  The returned storage's Pool follows the master and can be shared with a
+// RedisInvalidationBus.
+func NewRedisSentinelStorage(config *RedisSentinelConfig, ttl time.Duration) *RedisStorage {
+	sentinel := &redisSentinelRouter{config: config}
+	sentinel.pool = &redis.Pool{
+		MaxIdle:      config.getMaxIdle(),
+		IdleTimeout:  config.IdleTimeout,
+		Dial:         sentinel.dial,
+		TestOnBorrow: sentinel.testOnBorrow,
+	}
+
+	return &RedisStorage{
+		Pool:   sentinel.pool,
+		TTL:    ttl,
+		router: sentinel,
+	}
+}
+
+// redisSentinelRouter implements redisRouter by sending every command to the master discovered from the sentinels
+type redisSentinelRouter struct {
+	config *RedisSentinelConfig
+	pool   *redis.Pool
+
+	// address of the current master; empty when it needs to be discovered
+	master      string
+	masterMutex sync.Mutex
+
+	// coalesces concurrent discoveries of the master
+	discovery coalescer
+}
+
+// exec implements redisRouter
+func (s *redisSentinelRouter) exec(ctx context.Context, commands []redisCommand) ([]interface{}, error) {
+	replies, err := redisPoolRouter{pool: s.pool}.exec(ctx, commands)
+	if (err != nil && ctx.Err() == nil) || isRedisReadOnly(replies) {
+		// there may have been a failover
+		s.resetMaster()
+	}
+
+	return replies, err
+}
+
+// splitKeys implements redisRouter
+func (s *redisSentinelRouter) splitKeys(keys []string) [][]string {
+	return [][]string{keys}
+}
+
+// connect to the current master
+func (s *redisSentinelRouter) dial() (redis.Conn, error) {
+	addr, err := s.getMaster()
+	if err != nil {
+		return nil, err
+	}
+
+	con, err := RedisDial("tcp", addr, s.config.masterDialOptions()...)
+	if err != nil {
+		s.resetMaster()
+		return nil, err
+	}
+
+	// the sentinels may not have noticed the failover yet
+	role, err := redis.Values(redis.DoWithTimeout(con, s.config.getDialTimeout(), redisRole))
+	if err == nil && (len(role) == 0 || !isRedisRole(role[0], redisRoleMaster)) {
+		err = fmt.Errorf("redis at %s is not the master", addr)
+	}
+
+	if err != nil {
+		_ = con.Close()
+		s.resetMaster()
+		return nil, err
+	}
+
+	return &redisSentinelConn{Conn: con, addr: addr}, nil
+}
+
+// close idle connections to a previous master instead of reusing them
+func (s *redisSentinelRouter) testOnBorrow(con redis.Conn, _ time.Time) error {
+	addr, err := s.getMaster()
+	if err != nil {
+		// the sentinels are unavailable; keep using the connection
+		return nil
+	}
+
+	sentinelConn, ok := con.(*redisSentinelConn)
+	if !ok || sentinelConn.addr != addr {
+		return errRedisMasterChanged
+	}
+
+	return nil
+}
+
+// return the address of the master; asking the sentinels when it is not known.
+//
+// The sentinels are asked without holding masterMutex and concurrent callers share the result of a single discovery.
+func (s *redisSentinelRouter) getMaster() (string, error) {
+	s.masterMutex.Lock()
+	master := s.master
+	s.masterMutex.Unlock()
+
+	if master != "" {
+		return master, nil
+	}
+
+	addr, _, err := s.discovery.do(context.Background(), s.config.MasterName, func() ([]byte, error) {
+		addr, err := s.discoverMaster()
+		if err != nil {
+			return nil, err
+		}
+
+		s.masterMutex.Lock()
+		s.master = addr
+		s.masterMutex.Unlock()
+
+		return []byte(addr), nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return string(addr), nil
+}
+
+// ask each sentinel (in order) for the address of the master
+func (s *redisSentinelRouter) discoverMaster() (string, error) {
+	err := errors.New("no redis sentinel addresses")
+	for _, sentinelAddr := range s.config.Addrs {
+		var addr string
+
+		addr, err = s.askSentinel(sentinelAddr)
+		if err == nil {
+			return addr, nil
+		}
+	}
+
+	return "", err
+}
+
+// ask the sentinel for the address of the master
+func (s *redisSentinelRouter) askSentinel(sentinelAddr string) (string, error) {
+	con, err := redis.Dial("tcp", sentinelAddr, s.config.sentinelDialOptions()...)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = con.Close()
+	}()
+
+	hostAndPort, err := redis.Strings(con.Do(redisSentinelCommand, redisSentinelMasterArg, s.config.MasterName))
+	if err == redis.ErrNil {
+		return "", fmt.Errorf("redis sentinel at %s does not know master %s", sentinelAddr, s.config.MasterName)
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	if len(hostAndPort) != 2 {
+		return "", fmt.Errorf("unexpected SENTINEL reply: %v", hostAndPort)
+	}
+
+	return net.JoinHostPort(hostAndPort[0], hostAndPort[1]), nil
+}
+
+// discard the master so that it is discovered again
+func (s *redisSentinelRouter) resetMaster() {
+	s.masterMutex.Lock()
+	defer s.masterMutex.Unlock()
+
+	s.master = ""
+}
+
+// connection to the master at addr
+type redisSentinelConn struct {
+	redis.Conn
+
+	addr string
+}
+
+// DoWithTimeout implements redis.ConnWithTimeout
+func (c *redisSentinelConn) DoWithTimeout(timeout time.Duration, commandName string, args ...interface{}) (interface{}, error) {
+	return redis.DoWithTimeout(c.Conn, timeout, commandName, args...)
+}
+
+// ReceiveWithTimeout implements redis.ConnWithTimeout
+func (c *redisSentinelConn) ReceiveWithTimeout(timeout time.Duration) (interface{}, error) {
+	return redis.ReceiveWithTimeout(c.Conn, timeout)
+}
+
+// return true when a write was rejected because the server is a replica
+func isRedisReadOnly(replies []interface{}) bool {
+	for _, reply := range replies {
+		if redisErr, ok := reply.(redis.Error); ok && strings.HasPrefix(string(redisErr), redisReadOnlyPrefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// return true when the ROLE reply matches the role
+func isRedisRole(reply interface{}, role string) bool {
+	value, err := redis.String(reply, nil)
+	return err == nil && value == role
+}
@@ -0,0 +1,165 @@
+// Copyright 2017 Corey Scott http://www.sage42.org/
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisSentinelStorage_failover(t *testing.T) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelFn()
+
+	master := newFakeRedis(t)
+	replica := newFakeRedis(t)
+	replica.setRole("slave")
+
+	sentinel := newFakeRedis(t)
+	sentinel.setSentinelMaster(master.addr())
+
+	storage := NewRedisSentinelStorage(&RedisSentinelConfig{
+		Addrs:      []string{"127.0.0.1:1", sentinel.addr()},
+		MasterName: "mymaster",
+	}, 60*time.Second)
+
+	assert.Nil(t, storage.Set(ctx, "foo", []byte(`bar`)))
+	assert.Equal(t, []byte(`bar`), master.get("foo"))
+
+	// failover
+	master.setRole("slave")
+	replica.setRole(redisRoleMaster)
+	sentinel.setSentinelMaster(replica.addr())
+
+	// the first write fails as the pooled connection is to the old master
+	resultErr := storage.Set(ctx, "foo", []byte(`baz`))
+	assert.NotNil(t, resultErr)
+
+	// make the call
+	resultErr = storage.Set(ctx, "foo", []byte(`baz`))
+
+	// validate
+	assert.Nil(t, resultErr)
+	assert.Equal(t, []byte(`baz`), replica.get("foo"))
+
+	// the pool (e.g. for use by RedisInvalidationBus) also follows the master
+	con := storage.Pool.Get()
+	defer func() {
+		_ = con.Close()
+	}()
+
+	result, resultErr := redis.Bytes(con.Do(redisGet, "foo"))
+	assert.Nil(t, resultErr)
+	assert.Equal(t, []byte(`baz`), result)
+}
+
+func TestRedisSentinelStorage_notMaster(t *testing.T) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelFn()
+
+	// the sentinel has not noticed the failover
+	replica := newFakeRedis(t)
+	replica.setRole("slave")
+
+	sentinel := newFakeRedis(t)
+	sentinel.setSentinelMaster(replica.addr())
+
+	storage := NewRedisSentinelStorage(&RedisSentinelConfig{
+		Addrs:      []string{sentinel.addr()},
+		MasterName: "mymaster",
+	}, 60*time.Second)
+
+	// make the call
+	_, resultErr := storage.Get(ctx, "foo")
+
+	// validate
+	assert.NotNil(t, resultErr)
+	assert.Contains(t, resultErr.Error(), "is not the master")
+}
+
+func TestRedisSentinelStorage_unknownMaster(t *testing.T) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelFn()
+
+	sentinel := newFakeRedis(t)
+
+	storage := NewRedisSentinelStorage(&RedisSentinelConfig{
+		Addrs:      []string{sentinel.addr()},
+		MasterName: "mymaster",
+	}, 60*time.Second)
+
+	// make the call
+	_, resultErr := storage.Get(ctx, "foo")
+
+	// validate
+	assert.NotNil(t, resultErr)
+	assert.Contains(t, resultErr.Error(), "does not know master mymaster")
+}
+
+func TestRedisSentinelStorage_sentinelTimeout(t *testing.T) {
+	// a sentinel that accepts connections but never replies
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() {
+		_ = listener.Close()
+	}()
+
+	go func() {
+		for {
+			con, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer func() {
+				_ = con.Close()
+			}()
+		}
+	}()
+
+	storage := NewRedisSentinelStorage(&RedisSentinelConfig{
+		Addrs:       []string{listener.Addr().String()},
+		MasterName:  "mymaster",
+		DialTimeout: 100 * time.Millisecond,
+	}, 60*time.Second)
+
+	router := storage.router.(*redisSentinelRouter)
+
+	// make the call
+	resultCh := make(chan error, 1)
+	go func() {
+		_, err := router.getMaster()
+		resultCh <- err
+	}()
+
+	// validate the mutex is not held while asking the sentinel
+	time.Sleep(50 * time.Millisecond)
+	assert.True(t, router.masterMutex.TryLock())
+	router.masterMutex.Unlock()
+
+	select {
+	case resultErr := <-resultCh:
+		netErr, ok := resultErr.(net.Error)
+		assert.True(t, ok && netErr.Timeout(), "unexpected error: %v", resultErr)
+
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "the sentinel query did not time out")
+	}
+}
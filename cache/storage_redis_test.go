@@ -32,6 +32,10 @@ func TestRedisStorage_implements(t *testing.T) {
 	assert.Implements(t, (*Storage)(nil), &RedisStorage{})
 }
 
+func TestRedisStorage_Add(t *testing.T) {
+	testAddStorage(t, getTestFakeRedisStorage(newFakeRedis(t)))
+}
+
 func TestRedisStorage_happyPath(t *testing.T) {
 	skip.IfNotSet(t, RedisTestFlag)
 
@@ -120,12 +124,321 @@ func TestRedisStorage_setWithCtxDone(t *testing.T) {
 	assert.Equal(t, context.Canceled, resultErr)
 }
 
+func TestRedisStorage_implementsMulti(t *testing.T) {
+	assert.Implements(t, (*MultiStorage)(nil), &RedisStorage{})
+}
+
+func TestRedisStorage_multi(t *testing.T) {
+	skip.IfNotSet(t, RedisTestFlag)
+
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	keyA := getTestKey() + ".a"
+	keyB := getTestKey() + ".b"
+	missingKey := getTestKey() + ".missing"
+
+	storage := getTestRedisStorage()
+
+	// set the values
+	items := map[string][]byte{
+		keyA: []byte(`this is A`),
+		keyB: []byte(`this is B`),
+	}
+	resultErr := storage.SetMulti(ctx, items)
+	assert.Nil(t, resultErr)
+
+	// get the values
+	result, resultErr := storage.GetMulti(ctx, []string{keyA, missingKey, keyB})
+	assert.Nil(t, resultErr)
+	assert.Equal(t, items, result)
+}
+
+func TestRedisStorage_SetWithTTL(t *testing.T) {
+	skip.IfNotSet(t, RedisTestFlag)
+
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	key := getTestKey()
+
+	storage := getTestRedisStorage()
+
+	// set a value
+	resultErr := storage.SetWithTTL(ctx, key, []byte(`this is foo`), 1*time.Second)
+	assert.Nil(t, resultErr)
+
+	// wait for it to expire
+	<-time.After(1100 * time.Millisecond)
+
+	_, resultErr = storage.Get(ctx, key)
+	assert.Equal(t, ErrCacheMiss, resultErr)
+}
+
+func TestRedisStorage_implementsTags(t *testing.T) {
+	assert.Implements(t, (*TagStorage)(nil), &RedisStorage{})
+}
+
+func TestRedisStorage_InvalidateTag(t *testing.T) {
+	skip.IfNotSet(t, RedisTestFlag)
+
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	keyA := getTestKey() + ".a"
+	keyB := getTestKey() + ".b"
+	untaggedKey := getTestKey() + ".untagged"
+	tag := getTestKey() + ".tag"
+
+	storage := getTestRedisStorage()
+
+	// set and tag the values
+	for _, key := range []string{keyA, keyB, untaggedKey} {
+		assert.Nil(t, storage.Set(ctx, key, []byte(`this is foo`)))
+	}
+	assert.Nil(t, storage.Tag(ctx, keyA, []string{tag}, 0))
+	assert.Nil(t, storage.Tag(ctx, keyB, []string{tag}, 0))
+
+	// invalidate
+	resultErr := storage.InvalidateTag(ctx, tag)
+	assert.Nil(t, resultErr)
+
+	// validate
+	result, resultErr := storage.GetMulti(ctx, []string{keyA, keyB, untaggedKey})
+	assert.Nil(t, resultErr)
+	assert.Equal(t, map[string][]byte{untaggedKey: []byte(`this is foo`)}, result)
+
+	// invalidating an unknown tag does nothing
+	resultErr = storage.InvalidateTag(ctx, tag+".unknown")
+	assert.Nil(t, resultErr)
+}
+
+func TestRedisStorage_TagRemovesExpired(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelFn()
+
+	server := newFakeRedis(t)
+	storage := getTestFakeRedisStorage(server)
+
+	// a key that expired a minute ago
+	server.addMember(redisTagKey("tag"), "expired", float64(time.Now().Add(-1*time.Minute).Unix()))
+
+	// make the call
+	resultErr := storage.Tag(ctx, "a", []string{"tag"}, 1*time.Hour)
+
+	// validate
+	assert.Nil(t, resultErr)
+	assert.Equal(t, []string{"a"}, server.members(redisTagKey("tag")))
+}
+
+func TestRedisTagExpiry(t *testing.T) {
+	now := time.Unix(1000, 0)
+
+	scenarios := []struct {
+		desc          string
+		ttl           time.Duration
+		expectedScore int64
+		expectedTTL   int64
+	}{
+		{
+			desc:          "storage TTL",
+			ttl:           0,
+			expectedScore: 1060,
+			expectedTTL:   60,
+		},
+		{
+			desc:          "shorter item TTL",
+			ttl:           10 * time.Second,
+			expectedScore: 1010,
+			expectedTTL:   60,
+		},
+		{
+			desc:          "longer item TTL",
+			ttl:           2 * time.Minute,
+			expectedScore: 1120,
+			expectedTTL:   120,
+		},
+	}
+
+	for _, s := range scenarios {
+		scenario := s
+		t.Run(scenario.desc, func(t *testing.T) {
+			score, ttl := redisTagExpiry(now, 60, scenario.ttl)
+			assert.Equal(t, scenario.expectedScore, score)
+			assert.Equal(t, scenario.expectedTTL, ttl)
+		})
+	}
+}
+
+func TestRedisStorage_releasesConnections(t *testing.T) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelFn()
+
+	server := newFakeRedis(t)
+	storage := getTestFakeRedisStorage(server)
+
+	// make the calls
+	assert.Nil(t, storage.Set(ctx, "foo", []byte(`bar`)))
+	assert.Equal(t, storage.Pool.IdleCount(), storage.Pool.ActiveCount())
+
+	_, resultErr := storage.Get(ctx, "foo")
+	assert.Nil(t, resultErr)
+	assert.Equal(t, storage.Pool.IdleCount(), storage.Pool.ActiveCount())
+
+	_, resultErr = storage.Get(ctx, "unknown")
+	assert.Equal(t, ErrCacheMiss, resultErr)
+	assert.Equal(t, storage.Pool.IdleCount(), storage.Pool.ActiveCount())
+
+	_, resultErr = storage.GetMulti(ctx, []string{"foo", "unknown"})
+	assert.Nil(t, resultErr)
+	assert.Equal(t, storage.Pool.IdleCount(), storage.Pool.ActiveCount())
+
+	_, resultErr = storage.do(ctx, "UNKNOWN", "foo")
+	assert.NotNil(t, resultErr)
+	assert.Equal(t, storage.Pool.IdleCount(), storage.Pool.ActiveCount())
+
+	// validate
+	assert.Equal(t, 1, storage.Pool.IdleCount())
+}
+
+func TestRedisStorage_contextDeadline(t *testing.T) {
+	server := newFakeRedis(t)
+	server.setDelay(2 * time.Second)
+
+	storage := getTestFakeRedisStorage(server)
+
+	ctx, cancelFn := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancelFn()
+
+	// make the call
+	start := time.Now()
+	_, resultErr := storage.Get(ctx, "foo")
+
+	// validate
+	assert.Equal(t, context.DeadlineExceeded, resultErr)
+	assert.True(t, time.Since(start) < 1*time.Second)
+
+	// the connection is discarded (instead of waiting for the reply)
+	assert.Eventually(t, func() bool {
+		return storage.Pool.ActiveCount() == 0
+	}, 1*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 0, storage.Pool.IdleCount())
+}
+
+func TestRedisStorage_cancelled(t *testing.T) {
+	scenarios := []struct {
+		desc       string
+		dial       func(addr string) (redis.Conn, error)
+		maxRelease time.Duration
+	}{
+		{
+			desc: "RedisDial closes the connection at once",
+			dial: func(addr string) (redis.Conn, error) {
+				return RedisDial("tcp", addr)
+			},
+			maxRelease: 50 * time.Millisecond,
+		},
+		{
+			desc: "other connections are discarded after Timeout",
+			dial: func(addr string) (redis.Conn, error) {
+				return redis.Dial("tcp", addr)
+			},
+			maxRelease: 1 * time.Second,
+		},
+	}
+
+	for _, s := range scenarios {
+		scenario := s
+		t.Run(scenario.desc, func(t *testing.T) {
+			server := newFakeRedis(t)
+			server.setDelay(2 * time.Second)
+
+			storage := getTestFakeRedisStorage(server)
+			storage.Pool.Dial = func() (redis.Conn, error) {
+				return scenario.dial(server.addr())
+			}
+			storage.Timeout = 500 * time.Millisecond
+
+			// the deadline is much longer than Timeout
+			ctx, cancelFn := context.WithTimeout(context.Background(), 30*time.Second)
+			go func() {
+				<-time.After(20 * time.Millisecond)
+				cancelFn()
+			}()
+
+			// make the call
+			start := time.Now()
+			_, resultErr := storage.Get(ctx, "foo")
+
+			// validate
+			assert.Equal(t, context.Canceled, resultErr)
+			assert.True(t, time.Since(start) < 100*time.Millisecond)
+
+			assert.Eventually(t, func() bool {
+				return storage.Pool.ActiveCount() == 0
+			}, scenario.maxRelease, 5*time.Millisecond)
+			assert.Equal(t, 0, storage.Pool.IdleCount())
+
+			// calls after the cancellation are not sent
+			commands := server.commandCount()
+
+			_, resultErr = storage.Get(ctx, "foo")
+			assert.Equal(t, context.Canceled, resultErr)
+			assert.Equal(t, commands, server.commandCount())
+		})
+	}
+}
+
+func TestRedisStorage_Timeout(t *testing.T) {
+	server := newFakeRedis(t)
+	server.setDelay(2 * time.Second)
+
+	storage := getTestFakeRedisStorage(server)
+	storage.Timeout = 50 * time.Millisecond
+
+	// make the call
+	start := time.Now()
+	resultErr := storage.Set(context.Background(), "foo", []byte(`bar`))
+
+	// validate
+	assert.Equal(t, context.DeadlineExceeded, resultErr)
+	assert.True(t, time.Since(start) < 1*time.Second)
+
+	// a longer deadline is limited to Timeout
+	ctx, cancelFn := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancelFn()
+
+	start = time.Now()
+	resultErr = storage.Set(ctx, "foo", []byte(`bar`))
+
+	assert.Equal(t, context.DeadlineExceeded, resultErr)
+	assert.True(t, time.Since(start) < 1*time.Second)
+}
+
+func TestTTLInSeconds(t *testing.T) {
+	assert.Equal(t, int64(1), ttlInSeconds(1*time.Millisecond))
+	assert.Equal(t, int64(1), ttlInSeconds(1*time.Second))
+	assert.Equal(t, int64(2), ttlInSeconds(1500*time.Millisecond))
+}
+
 func getTestRedisStorage() *RedisStorage {
 	return &RedisStorage{
 		Pool: &redis.Pool{
 			MaxIdle:     3,
 			IdleTimeout: 240 * time.Second,
-			Dial:        func() (redis.Conn, error) { return redis.Dial("tcp", ":6379") },
+			Dial:        func() (redis.Conn, error) { return RedisDial("tcp", ":6379") },
+		},
+		TTL: 60 * time.Second,
+	}
+}
+
+// return a storage that uses the fake redis server
+func getTestFakeRedisStorage(server *fakeRedis) *RedisStorage {
+	return &RedisStorage{
+		Pool: &redis.Pool{
+			MaxIdle: 3,
+			Dial:    func() (redis.Conn, error) { return RedisDial("tcp", server.addr()) },
 		},
 		TTL: 60 * time.Second,
 	}
@@ -0,0 +1,311 @@
+// Copyright 2017 Corey Scott http://www.sage42.org/
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/redis/rueidis"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// span attribute recording whether a value was served from the client-side cache
+const attrRedisClientCacheHit = attribute.Key("db.redis.client_cache_hit")
+
+// RueidisStorage implements Storage using rueidis (github.com/redis/rueidis); a maintained redis client that speaks
+// RESP3 and supports standalone, Redis Cluster and Sentinel deployments (depending on how the Client is created).
+//
+// When CacheTTL is set, Get and GetMulti use server-assisted client-side caching (CLIENT TRACKING): values are kept in
+// process memory for up to CacheTTL and are evicted as soon as the server reports that the key has changed (including
+// changes made by other instances).  Client-side caching requires Redis 6 or newer; with older servers create the
+// Client with `rueidis.ClientOption.DisableCache` and leave CacheTTL unset.
+//
+// Calls use the context's deadline, limited to Timeout (as with RedisStorage), and are protected by a per instance
+// circuit breaker (see Breaker).
+type RueidisStorage struct {
+	// Client sends the commands to the server(s); create it with `rueidis.NewClient()` (required)
+	Client rueidis.Client
+
+	// TTL is the default TTL for cache items; it can be overridden per item with SetWithTTL (required)
+	TTL time.Duration
+
+	// CacheTTL is the maximum duration a value is kept in process memory (optional - default client-side caching is disabled)
+	CacheTTL time.Duration
+
+	// Timeout is the maximum duration of each call; the context's deadline is used when it is earlier (optional -
+	// default 1 second)
+	Timeout time.Duration
+
+	// TracerProvider enables OpenTelemetry spans for every redis command (optional - default disabled)
+	TracerProvider trace.TracerProvider
+
+	// Breaker protects the calls to the server (optional - default is a NativeBreaker with its default settings).
+	// Use NoopBreaker to disable the circuit breaker.
+	Breaker CircuitBreaker
+
+	defaultBreaker     CircuitBreaker
+	defaultBreakerOnce sync.Once
+}
+
+// return the circuit breaker for this storage
+func (r *RueidisStorage) getBreaker() CircuitBreaker {
+	if r.Breaker != nil {
+		return r.Breaker
+	}
+
+	r.defaultBreakerOnce.Do(func() {
+		r.defaultBreaker = &NativeBreaker{}
+	})
+
+	return r.defaultBreaker
+}
+
+func (r *RueidisStorage) getTimeout() time.Duration {
+	if r.Timeout > 0 {
+		return r.Timeout
+	}
+
+	return defaultRedisTimeout
+}
+
+// Get implements Storage
+func (r *RueidisStorage) Get(ctx context.Context, key string) ([]byte, error) {
+	var bytes []byte
+	found := false
+
+	err := r.do(ctx, redisGet, func(ctx context.Context) (int, error) {
+		var result rueidis.RedisResult
+		if r.CacheTTL > 0 {
+			result = r.Client.DoCache(ctx, r.Client.B().Get().Key(key).Cache(), r.CacheTTL)
+		} else {
+			result = r.Client.Do(ctx, r.Client.B().Get().Key(key).Build())
+		}
+
+		trace.SpanFromContext(ctx).SetAttributes(attrRedisClientCacheHit.Bool(result.IsCacheHit()))
+
+		value, err := result.AsBytes()
+		if rueidis.IsRedisNil(err) {
+			return 0, nil
+		}
+
+		if err != nil {
+			return 0, err
+		}
+
+		bytes, found = value, true
+		return len(value), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !found {
+		return nil, ErrCacheMiss
+	}
+
+	return bytes, nil
+}
+
+// Set implements Storage
+func (r *RueidisStorage) Set(ctx context.Context, key string, bytes []byte) error {
+	return r.SetWithTTL(ctx, key, bytes, r.TTL)
+}
+
+// SetWithTTL implements TTLStorage
+func (r *RueidisStorage) SetWithTTL(ctx context.Context, key string, bytes []byte, ttl time.Duration) error {
+	return r.do(ctx, redisSet, func(ctx context.Context) (int, error) {
+		return len(bytes), r.Client.Do(ctx, r.setCommand(key, bytes, ttl)).Error()
+	})
+}
+
+// Add implements AddStorage using SET with the NX option
+func (r *RueidisStorage) Add(ctx context.Context, key string, bytes []byte, ttl time.Duration) (bool, error) {
+	if ttl <= 0 {
+		ttl = r.TTL
+	}
+
+	added := false
+	err := r.do(ctx, redisSet, func(ctx context.Context) (int, error) {
+		command := r.Client.B().Set().Key(key).Value(rueidis.BinaryString(bytes)).Nx().ExSeconds(ttlInSeconds(ttl)).Build()
+
+		err := r.Client.Do(ctx, command).Error()
+		if rueidis.IsRedisNil(err) {
+			// the key exists
+			return len(bytes), nil
+		}
+
+		added = err == nil
+		return len(bytes), err
+	})
+
+	return added, err
+}
+
+// Invalidate implements Storage
+func (r *RueidisStorage) Invalidate(ctx context.Context, key string) error {
+	return r.do(ctx, redisDel, func(ctx context.Context) (int, error) {
+		return 0, r.Client.Do(ctx, r.Client.B().Del().Key(key).Build()).Error()
+	})
+}
+
+// GetMulti implements MultiStorage using GET commands (served from the client-side cache where possible) or MGET
+// (when client-side caching is disabled); keys are split by hash slot when using a cluster
+func (r *RueidisStorage) GetMulti(ctx context.Context, keys []string) (map[string][]byte, error) {
+	out := make(map[string][]byte, len(keys))
+	if len(keys) == 0 {
+		return out, nil
+	}
+
+	err := r.do(ctx, redisMget, func(ctx context.Context) (int, error) {
+		var messages map[string]rueidis.RedisMessage
+		var err error
+		if r.CacheTTL > 0 {
+			messages, err = rueidis.MGetCache(r.Client, ctx, r.CacheTTL, keys)
+		} else {
+			messages, err = rueidis.MGet(r.Client, ctx, keys)
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		size := 0
+		for key, message := range messages {
+			if message.IsNil() {
+				continue
+			}
+
+			value, err := message.AsBytes()
+			if err != nil {
+				return 0, err
+			}
+
+			out[key] = value
+			size += len(value)
+		}
+
+		return size, nil
+	}, attribute.Int("db.redis.keys", len(keys)))
+	if err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// SetMulti implements MultiStorage using pipelined SET commands
+func (r *RueidisStorage) SetMulti(ctx context.Context, items map[string][]byte) error {
+	if len(items) == 0 {
+		return nil
+	}
+
+	commands := make(rueidis.Commands, 0, len(items))
+	size := 0
+	for key, bytes := range items {
+		commands = append(commands, r.setCommand(key, bytes, r.TTL))
+		size += len(bytes)
+	}
+
+	return r.do(ctx, redisSet, func(ctx context.Context) (int, error) {
+		return size, firstRueidisError(r.Client.DoMulti(ctx, commands...))
+	})
+}
+
+// Tag implements TagStorage by adding the key to a redis sorted set per tag, scored by the expiry of the item.
+//
+// Keys that have expired are removed from the sets on each write (see RedisStorage.Tag).  The tag sets expire after
+// the storage TTL or the item TTL (whichever is longer).
+func (r *RueidisStorage) Tag(ctx context.Context, key string, tags []string, ttl time.Duration) error {
+	if len(tags) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	score, ttlSeconds := redisTagExpiry(now, ttlInSeconds(r.TTL), ttl)
+
+	commands := make(rueidis.Commands, 0, len(tags)*3)
+	for _, tag := range tags {
+		commands = append(commands,
+			r.Client.B().Zadd().Key(redisTagKey(tag)).ScoreMember().ScoreMember(float64(score), key).Build(),
+			r.Client.B().Zremrangebyscore().Key(redisTagKey(tag)).Min("-inf").Max(redisTagExpired(now)).Build(),
+			r.Client.B().Expire().Key(redisTagKey(tag)).Seconds(ttlSeconds).Build(),
+		)
+	}
+
+	return r.do(ctx, redisZadd, func(ctx context.Context) (int, error) {
+		return 0, firstRueidisError(r.Client.DoMulti(ctx, commands...))
+	})
+}
+
+// InvalidateTag implements TagStorage by removing every key in the tag's set.
+//
+// Only the keys that were removed are taken out of the set; keys tagged during the invalidation are retained.
+func (r *RueidisStorage) InvalidateTag(ctx context.Context, tag string) error {
+	return r.do(ctx, redisDel, func(ctx context.Context) (int, error) {
+		keys, err := r.Client.Do(ctx, r.Client.B().Zrange().Key(redisTagKey(tag)).Min("0").Max("-1").Build()).AsStrSlice()
+		if err != nil || len(keys) == 0 {
+			return 0, err
+		}
+
+		// 1 command per key as the keys may be in different slots when using a cluster
+		commands := make(rueidis.Commands, 0, len(keys)+1)
+		for _, key := range keys {
+			commands = append(commands, r.Client.B().Del().Key(key).Build())
+		}
+		commands = append(commands, r.Client.B().Zrem().Key(redisTagKey(tag)).Member(keys...).Build())
+
+		return 0, firstRueidisError(r.Client.DoMulti(ctx, commands...))
+	})
+}
+
+// return the SET command that saves the value with the TTL
+func (r *RueidisStorage) setCommand(key string, bytes []byte, ttl time.Duration) rueidis.Completed {
+	return r.Client.B().Set().Key(key).Value(rueidis.BinaryString(bytes)).ExSeconds(ttlInSeconds(ttl)).Build()
+}
+
+// calls to redis protected by a circuit breaker; name is the command used to identify the calls in spans and fn
+// returns the size of the values sent or received
+func (r *RueidisStorage) do(ctx context.Context, name string, fn func(ctx context.Context) (int, error), attrs ...attribute.KeyValue) (err error) {
+	// limit the call to Timeout (the context's deadline is used when it is earlier)
+	ctx, cancelFn := context.WithTimeout(ctx, r.getTimeout())
+	defer cancelFn()
+
+	ctx, span := startStorageSpan(ctx, r.TracerProvider, "redis", name, attrs...)
+
+	size := 0
+	defer func() {
+		endSpan(span, size, err)
+	}()
+
+	return r.getBreaker().Do(ctx, func() error {
+		var fnErr error
+		size, fnErr = fn(ctx)
+		return fnErr
+	})
+}
+
+// return the first error (if any)
+func firstRueidisError(results []rueidis.RedisResult) error {
+	for _, result := range results {
+		err := result.Error()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
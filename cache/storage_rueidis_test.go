@@ -0,0 +1,329 @@
+// Copyright 2017 Corey Scott http://www.sage42.org/
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/redis/rueidis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestRueidisStorage_implements(t *testing.T) {
+	assert.Implements(t, (*Storage)(nil), &RueidisStorage{})
+	assert.Implements(t, (*TTLStorage)(nil), &RueidisStorage{})
+	assert.Implements(t, (*MultiStorage)(nil), &RueidisStorage{})
+	assert.Implements(t, (*TagStorage)(nil), &RueidisStorage{})
+}
+
+func TestRueidisStorage_Add(t *testing.T) {
+	testAddStorage(t, getTestRueidisStorage(t, newFakeRedis(t), 0))
+}
+
+func TestRueidisStorage_happyPath(t *testing.T) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelFn()
+
+	server := newFakeRedis(t)
+	storage := getTestRueidisStorage(t, server, 0)
+
+	// get a value (should fail)
+	result, resultErr := storage.Get(ctx, "foo")
+	assert.Nil(t, result)
+	assert.Equal(t, ErrCacheMiss, resultErr)
+
+	// set a value
+	resultErr = storage.Set(ctx, "foo", []byte(`this is foo`))
+	assert.Nil(t, resultErr)
+	assert.Equal(t, []byte(`this is foo`), server.get("foo"))
+
+	// get a value
+	result, resultErr = storage.Get(ctx, "foo")
+	assert.Equal(t, []byte(`this is foo`), result)
+	assert.Nil(t, resultErr)
+
+	// invalidate that value
+	resultErr = storage.Invalidate(ctx, "foo")
+	assert.Nil(t, resultErr)
+
+	result, resultErr = storage.Get(ctx, "foo")
+	assert.Nil(t, result)
+	assert.Equal(t, ErrCacheMiss, resultErr)
+}
+
+func TestRueidisStorage_clientSideCache(t *testing.T) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelFn()
+
+	server := newFakeRedis(t)
+	storage := getTestRueidisStorage(t, server, 1*time.Minute)
+	other := getTestRueidisStorage(t, server, 1*time.Minute)
+
+	assert.Nil(t, storage.Set(ctx, "foo", []byte(`bar`)))
+
+	// the first get is sent to the server
+	result, resultErr := storage.Get(ctx, "foo")
+	assert.Nil(t, resultErr)
+	assert.Equal(t, []byte(`bar`), result)
+
+	// later gets are served from memory
+	commands := server.commandCount()
+
+	result, resultErr = storage.Get(ctx, "foo")
+	assert.Nil(t, resultErr)
+	assert.Equal(t, []byte(`bar`), result)
+
+	results, resultErr := storage.GetMulti(ctx, []string{"foo"})
+	assert.Nil(t, resultErr)
+	assert.Equal(t, map[string][]byte{"foo": []byte(`bar`)}, results)
+
+	assert.Equal(t, commands, server.commandCount())
+
+	// changes made by another instance are sent to the tracking clients
+	assert.Nil(t, other.Set(ctx, "foo", []byte(`baz`)))
+
+	assert.Eventually(t, func() bool {
+		result, resultErr = storage.Get(ctx, "foo")
+		return resultErr == nil && string(result) == `baz`
+	}, 1*time.Second, 10*time.Millisecond)
+
+	// as are invalidations
+	assert.Nil(t, other.Invalidate(ctx, "foo"))
+
+	assert.Eventually(t, func() bool {
+		_, resultErr = storage.Get(ctx, "foo")
+		return resultErr == ErrCacheMiss
+	}, 1*time.Second, 10*time.Millisecond)
+}
+
+func TestRueidisStorage_multi(t *testing.T) {
+	scenarios := []struct {
+		desc     string
+		cacheTTL time.Duration
+	}{
+		{
+			desc:     "without client-side caching",
+			cacheTTL: 0,
+		},
+		{
+			desc:     "with client-side caching",
+			cacheTTL: 1 * time.Minute,
+		},
+	}
+
+	for _, s := range scenarios {
+		scenario := s
+		t.Run(scenario.desc, func(t *testing.T) {
+			ctx, cancelFn := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancelFn()
+
+			server := newFakeRedis(t)
+			storage := getTestRueidisStorage(t, server, scenario.cacheTTL)
+
+			// set the values
+			items := map[string][]byte{
+				"a": []byte(`this is A`),
+				"b": []byte(`this is B`),
+			}
+			resultErr := storage.SetMulti(ctx, items)
+			assert.Nil(t, resultErr)
+
+			// get the values
+			result, resultErr := storage.GetMulti(ctx, []string{"a", "missing", "b"})
+			assert.Nil(t, resultErr)
+			assert.Equal(t, items, result)
+
+			// no keys
+			result, resultErr = storage.GetMulti(ctx, nil)
+			assert.Nil(t, resultErr)
+			assert.Empty(t, result)
+		})
+	}
+}
+
+func TestRueidisStorage_InvalidateTag(t *testing.T) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelFn()
+
+	server := newFakeRedis(t)
+	storage := getTestRueidisStorage(t, server, 1*time.Minute)
+
+	// set and tag the values
+	for _, key := range []string{"a", "b", "untagged"} {
+		assert.Nil(t, storage.Set(ctx, key, []byte(`this is foo`)))
+	}
+	assert.Nil(t, storage.Tag(ctx, "a", []string{"tag"}, 0))
+	assert.Nil(t, storage.Tag(ctx, "b", []string{"tag"}, 0))
+
+	// read the values into the client-side cache
+	_, resultErr := storage.GetMulti(ctx, []string{"a", "b", "untagged"})
+	assert.Nil(t, resultErr)
+
+	// invalidate
+	resultErr = storage.InvalidateTag(ctx, "tag")
+	assert.Nil(t, resultErr)
+
+	// validate
+	assert.Eventually(t, func() bool {
+		result, resultErr := storage.GetMulti(ctx, []string{"a", "b", "untagged"})
+		return resultErr == nil && assert.ObjectsAreEqual(map[string][]byte{"untagged": []byte(`this is foo`)}, result)
+	}, 1*time.Second, 10*time.Millisecond)
+
+	// invalidating an unknown tag does nothing
+	resultErr = storage.InvalidateTag(ctx, "unknown")
+	assert.Nil(t, resultErr)
+}
+
+func TestRueidisStorage_TagRemovesExpired(t *testing.T) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelFn()
+
+	server := newFakeRedis(t)
+	storage := getTestRueidisStorage(t, server, 1*time.Minute)
+
+	// a key that expired a minute ago
+	server.addMember(redisTagKey("tag"), "expired", float64(time.Now().Add(-1*time.Minute).Unix()))
+
+	// make the call
+	resultErr := storage.Tag(ctx, "a", []string{"tag"}, 1*time.Hour)
+
+	// validate
+	assert.Nil(t, resultErr)
+	assert.Equal(t, []string{"a"}, server.members(redisTagKey("tag")))
+}
+
+func TestRueidisStorage_readOnly(t *testing.T) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelFn()
+
+	server := newFakeRedis(t)
+	server.setRole("slave")
+
+	storage := getTestRueidisStorage(t, server, 0)
+
+	// make the call
+	resultErr := storage.SetMulti(ctx, map[string][]byte{"a": []byte(`this is A`)})
+
+	// validate
+	assert.NotNil(t, resultErr)
+	assert.Contains(t, resultErr.Error(), redisReadOnlyPrefix)
+}
+
+func TestRueidisStorage_cancelled(t *testing.T) {
+	server := newFakeRedis(t)
+	storage := getTestRueidisStorage(t, server, 0)
+
+	ctx, cancelFn := context.WithCancel(context.Background())
+	cancelFn()
+
+	// make the call
+	_, resultErr := storage.Get(ctx, "foo")
+
+	// validate
+	assert.Equal(t, context.Canceled, resultErr)
+}
+
+func TestRueidisStorage_Timeout(t *testing.T) {
+	scenarios := []struct {
+		desc   string
+		getCtx func() (context.Context, context.CancelFunc)
+	}{
+		{
+			desc: "no deadline",
+			getCtx: func() (context.Context, context.CancelFunc) {
+				return context.WithCancel(context.Background())
+			},
+		},
+		{
+			desc: "a longer deadline is limited to Timeout",
+			getCtx: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), 30*time.Second)
+			},
+		},
+	}
+
+	for _, s := range scenarios {
+		scenario := s
+		t.Run(scenario.desc, func(t *testing.T) {
+			server := newFakeRedis(t)
+			storage := getTestRueidisStorage(t, server, 0)
+			storage.Timeout = 50 * time.Millisecond
+
+			server.setDelay(2 * time.Second)
+
+			ctx, cancelFn := scenario.getCtx()
+			defer cancelFn()
+
+			// make the call
+			start := time.Now()
+			resultErr := storage.Set(ctx, "foo", []byte(`bar`))
+
+			// validate
+			assert.Equal(t, context.DeadlineExceeded, resultErr)
+			assert.True(t, time.Since(start) < 1*time.Second)
+		})
+	}
+}
+
+func TestRueidisStorage_spans(t *testing.T) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelFn()
+
+	recorder := tracetest.NewSpanRecorder()
+
+	server := newFakeRedis(t)
+	storage := getTestRueidisStorage(t, server, 1*time.Minute)
+	storage.TracerProvider = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	assert.Nil(t, storage.Set(ctx, "foo", []byte(`bar`)))
+
+	// make the calls
+	for x := 0; x < 2; x++ {
+		_, resultErr := storage.Get(ctx, "foo")
+		assert.Nil(t, resultErr)
+	}
+
+	// validate
+	spans := recorder.Ended()
+	require.Len(t, spans, 3)
+
+	assert.Equal(t, "redis SET", spans[0].Name())
+	assert.Equal(t, "redis GET", spans[1].Name())
+	assert.Contains(t, spans[1].Attributes(), attrRedisClientCacheHit.Bool(false))
+	assert.Contains(t, spans[2].Attributes(), attrRedisClientCacheHit.Bool(true))
+	assert.Contains(t, spans[2].Attributes(), attrPayloadSize.Int(3))
+}
+
+// return a storage that uses the fake redis server; client-side caching is enabled when cacheTTL is set
+func getTestRueidisStorage(t *testing.T, server *fakeRedis, cacheTTL time.Duration) *RueidisStorage {
+	client, err := rueidis.NewClient(rueidis.ClientOption{
+		InitAddress:  []string{server.addr()},
+		DisableCache: cacheTTL == 0,
+		DisableRetry: true,
+	})
+	require.Nil(t, err)
+	t.Cleanup(client.Close)
+
+	return &RueidisStorage{
+		Client:   client,
+		TTL:      60 * time.Second,
+		CacheTTL: cacheTTL,
+	}
+}
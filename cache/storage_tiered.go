@@ -0,0 +1,281 @@
+// Copyright 2017 Corey Scott http://www.sage42.org/
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+// TieredStorage implements Storage by placing a fast local storage (L1) in front of a shared remote storage (L2).
+//
+// Reads are served from L1 where possible and L2 hits are back-filled into L1.  Writes and invalidates go to both.
+//
+// Each tier uses its own TTL; typically the L1 TTL should be much shorter than the L2 TTL as L1 is not
+// informed of changes made by other instances (unless a Bus is used to broadcast invalidations).
+type TieredStorage struct {
+	// L1 is the local storage; typically MemoryStorage (required)
+	L1 Storage
+
+	// L2 is the remote storage; e.g. RedisStorage or DynamoDbStorage (required)
+	L2 Storage
+
+	// Metrics allow for tracking the per tier hit/miss events (optional)
+	//
+	// When Metrics implements ExtendedMetrics, the operations of each tier are also observed (with storage names
+	// "l1" and "l2" and the Name of the Client making the call).
+	Metrics Metrics
+
+	// Bus broadcasts invalidations so that other instances remove the keys from their L1 (optional)
+	//
+	// See RedisInvalidationBus.
+	Bus InvalidationPublisher
+}
+
+// Get implements Storage
+func (r *TieredStorage) Get(ctx context.Context, key string) ([]byte, error) {
+	bytes, err := r.l1(ctx).Get(ctx, key)
+	if err == nil {
+		r.getMetrics().Track(CacheL1Hit)
+		return bytes, nil
+	}
+
+	// L1 errors are treated as misses as L2 is able to serve the request
+	r.getMetrics().Track(CacheL1Miss)
+
+	bytes, err = r.l2(ctx).Get(ctx, key)
+	if err != nil {
+		if err == ErrCacheMiss {
+			r.getMetrics().Track(CacheL2Miss)
+		}
+		return nil, err
+	}
+
+	r.getMetrics().Track(CacheL2Hit)
+	r.setL1(ctx, key, bytes)
+
+	return bytes, nil
+}
+
+// Set implements Storage
+func (r *TieredStorage) Set(ctx context.Context, key string, bytes []byte) error {
+	err := r.l2(ctx).Set(ctx, key, bytes)
+	if err != nil {
+		// ensure L1 does not serve data that L2 does not have
+		_ = r.l1(ctx).Invalidate(ctx, key)
+		return err
+	}
+
+	r.setL1(ctx, key, bytes)
+	return nil
+}
+
+// SetWithTTL implements TTLStorage
+//
+// The supplied TTL is applied to both tiers (where supported).  When L1 is a MemoryStorage, its TTL remains the upper
+// limit for items in L1.
+func (r *TieredStorage) SetWithTTL(ctx context.Context, key string, bytes []byte, ttl time.Duration) error {
+	err := setWithTTL(ctx, r.l2(ctx), key, bytes, ttl)
+	if err != nil {
+		// ensure L1 does not serve data that L2 does not have
+		_ = r.l1(ctx).Invalidate(ctx, key)
+		return err
+	}
+
+	err = setWithTTL(ctx, r.l1(ctx), key, bytes, ttl)
+	if err != nil {
+		// ensure L1 does not serve an older value
+		_ = r.l1(ctx).Invalidate(ctx, key)
+	}
+	return nil
+}
+
+// Add implements AddStorage (when L2 does); L1 is only updated when the value was added to L2
+func (r *TieredStorage) Add(ctx context.Context, key string, bytes []byte, ttl time.Duration) (bool, error) {
+	added, err := add(ctx, r.l2(ctx), key, bytes, ttl)
+	if err != nil || !added {
+		// ensure L1 does not serve data that L2 does not have
+		_ = r.l1(ctx).Invalidate(ctx, key)
+		return false, err
+	}
+
+	err = setWithTTL(ctx, r.l1(ctx), key, bytes, ttl)
+	if err != nil {
+		// ensure L1 does not serve an older value
+		_ = r.l1(ctx).Invalidate(ctx, key)
+	}
+	return true, nil
+}
+
+// Invalidate implements Storage
+func (r *TieredStorage) Invalidate(ctx context.Context, key string) error {
+	errL1 := r.l1(ctx).Invalidate(ctx, key)
+
+	errL2 := r.l2(ctx).Invalidate(ctx, key)
+	if errL2 != nil {
+		return errL2
+	}
+
+	if r.Bus != nil {
+		err := r.Bus.Publish(ctx, key)
+		if err != nil {
+			return err
+		}
+	}
+
+	return errL1
+}
+
+// GetMulti implements MultiStorage
+func (r *TieredStorage) GetMulti(ctx context.Context, keys []string) (map[string][]byte, error) {
+	// L1 errors are treated as misses as L2 is able to serve the request
+	out, err := getMulti(ctx, r.l1(ctx), keys)
+	if err != nil {
+		out = map[string][]byte{}
+	}
+
+	var remaining []string
+	for _, key := range keys {
+		if _, found := out[key]; found {
+			r.getMetrics().Track(CacheL1Hit)
+			continue
+		}
+
+		r.getMetrics().Track(CacheL1Miss)
+		remaining = append(remaining, key)
+	}
+
+	if len(remaining) == 0 {
+		return out, nil
+	}
+
+	fromL2, err := getMulti(ctx, r.l2(ctx), remaining)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, key := range remaining {
+		if _, found := fromL2[key]; found {
+			r.getMetrics().Track(CacheL2Hit)
+		} else {
+			r.getMetrics().Track(CacheL2Miss)
+		}
+	}
+
+	for key, bytes := range fromL2 {
+		out[key] = bytes
+	}
+
+	r.setMultiL1(ctx, fromL2)
+
+	return out, nil
+}
+
+// SetMulti implements MultiStorage
+func (r *TieredStorage) SetMulti(ctx context.Context, items map[string][]byte) error {
+	err := setMulti(ctx, r.l2(ctx), items)
+	if err != nil {
+		// ensure L1 does not serve data that L2 does not have
+		for key := range items {
+			_ = r.l1(ctx).Invalidate(ctx, key)
+		}
+		return err
+	}
+
+	r.setMultiL1(ctx, items)
+	return nil
+}
+
+// Tag implements TagStorage
+//
+// L2 must implement TagStorage; L1 is tagged when it also implements TagStorage.
+func (r *TieredStorage) Tag(ctx context.Context, key string, tags []string, ttl time.Duration) error {
+	err := tag(ctx, r.l2(ctx), key, tags, ttl)
+	if err != nil {
+		return err
+	}
+
+	err = tag(ctx, r.l1(ctx), key, tags, ttl)
+	if err != nil && err != ErrTagsNotSupported {
+		// ensure L1 does not serve a value that can no longer be invalidated by tag
+		_ = r.l1(ctx).Invalidate(ctx, key)
+	}
+	return nil
+}
+
+// InvalidateTag implements TagStorage
+func (r *TieredStorage) InvalidateTag(ctx context.Context, tag string) error {
+	errL1 := invalidateTag(ctx, r.l1(ctx), tag)
+	if errL1 == ErrTagsNotSupported {
+		errL1 = nil
+	}
+
+	errL2 := invalidateTag(ctx, r.l2(ctx), tag)
+	if errL2 != nil {
+		return errL2
+	}
+
+	if r.Bus != nil {
+		err := r.Bus.PublishTags(ctx, tag)
+		if err != nil {
+			return err
+		}
+	}
+
+	return errL1
+}
+
+// write many items to L1; failures are not returned as L2 already has the data
+func (r *TieredStorage) setMultiL1(ctx context.Context, items map[string][]byte) {
+	if len(items) == 0 {
+		return
+	}
+
+	err := setMulti(ctx, r.l1(ctx), items)
+	if err != nil {
+		// ensure L1 does not serve older values
+		for key := range items {
+			_ = r.l1(ctx).Invalidate(ctx, key)
+		}
+	}
+}
+
+// write to L1; failures are not returned as L2 already has the data
+func (r *TieredStorage) setL1(ctx context.Context, key string, bytes []byte) {
+	err := r.l1(ctx).Set(ctx, key, bytes)
+	if err != nil {
+		// ensure L1 does not serve an older value
+		_ = r.l1(ctx).Invalidate(ctx, key)
+	}
+}
+
+// return the L1 storage; instrumented (with the name of the cache making the call) when extended metrics are used
+func (r *TieredStorage) l1(ctx context.Context) Storage {
+	return instrument(r.L1, r.Metrics, cacheNameOf(ctx), "l1")
+}
+
+// return the L2 storage; instrumented (with the name of the cache making the call) when extended metrics are used
+func (r *TieredStorage) l2(ctx context.Context) Storage {
+	return instrument(r.L2, r.Metrics, cacheNameOf(ctx), "l2")
+}
+
+// return the supplied metric tracker or a no-op implementation
+func (r *TieredStorage) getMetrics() Metrics {
+	if r.Metrics != nil {
+		return r.Metrics
+	}
+
+	return noopMetrics
+}
@@ -0,0 +1,293 @@
+// Copyright 2017 Corey Scott http://www.sage42.org/
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestTieredStorage_implements(t *testing.T) {
+	assert.Implements(t, (*Storage)(nil), &TieredStorage{})
+}
+
+func TestTieredStorage_getL1Hit(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	key := getTestKey()
+	data := []byte(`this is foo`)
+
+	l1 := &MemoryStorage{}
+	assert.Nil(t, l1.Set(ctx, key, data))
+
+	l2 := &MockStorage{}
+
+	metrics := &MockMetrics{}
+	metrics.On("Track", CacheL1Hit)
+
+	storage := &TieredStorage{
+		L1:      l1,
+		L2:      l2,
+		Metrics: metrics,
+	}
+
+	// make the call
+	result, resultErr := storage.Get(ctx, key)
+	assert.Equal(t, data, result)
+	assert.Nil(t, resultErr)
+
+	assert.True(t, l2.AssertExpectations(t))
+	assert.True(t, metrics.AssertExpectations(t))
+}
+
+func TestTieredStorage_getL2Hit(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	key := getTestKey()
+	data := []byte(`this is foo`)
+
+	l1 := &MemoryStorage{}
+
+	l2 := &MockStorage{}
+	l2.On("Get", mock.Anything, key).Return(data, nil).Once()
+
+	metrics := &MockMetrics{}
+	metrics.On("Track", CacheL1Miss).Once()
+	metrics.On("Track", CacheL2Hit).Once()
+	metrics.On("Track", CacheL1Hit).Once()
+
+	storage := &TieredStorage{
+		L1:      l1,
+		L2:      l2,
+		Metrics: metrics,
+	}
+
+	// first call is served by L2
+	result, resultErr := storage.Get(ctx, key)
+	assert.Equal(t, data, result)
+	assert.Nil(t, resultErr)
+
+	// second call is served by the back-filled L1
+	result, resultErr = storage.Get(ctx, key)
+	assert.Equal(t, data, result)
+	assert.Nil(t, resultErr)
+
+	assert.True(t, l2.AssertExpectations(t))
+	assert.True(t, metrics.AssertExpectations(t))
+}
+
+func TestTieredStorage_getMiss(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	key := getTestKey()
+
+	l2 := &MockStorage{}
+	l2.On("Get", mock.Anything, key).Return(nil, ErrCacheMiss)
+
+	metrics := &MockMetrics{}
+	metrics.On("Track", CacheL1Miss)
+	metrics.On("Track", CacheL2Miss)
+
+	storage := &TieredStorage{
+		L1:      &MemoryStorage{},
+		L2:      l2,
+		Metrics: metrics,
+	}
+
+	// make the call
+	result, resultErr := storage.Get(ctx, key)
+	assert.Nil(t, result)
+	assert.Equal(t, ErrCacheMiss, resultErr)
+
+	assert.True(t, l2.AssertExpectations(t))
+	assert.True(t, metrics.AssertExpectations(t))
+}
+
+func TestTieredStorage_Add(t *testing.T) {
+	testAddStorage(t, &TieredStorage{
+		L1: &MemoryStorage{},
+		L2: &MemoryStorage{},
+	})
+}
+
+func TestTieredStorage_Set(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	key := getTestKey()
+	data := []byte(`this is foo`)
+
+	l1 := &MemoryStorage{}
+
+	l2 := &MockStorage{}
+	l2.On("Set", mock.Anything, key, data).Return(nil)
+
+	storage := &TieredStorage{
+		L1: l1,
+		L2: l2,
+	}
+
+	// make the call
+	resultErr := storage.Set(ctx, key, data)
+	assert.Nil(t, resultErr)
+
+	// validate
+	result, resultErr := l1.Get(ctx, key)
+	assert.Equal(t, data, result)
+	assert.Nil(t, resultErr)
+
+	assert.True(t, l2.AssertExpectations(t))
+}
+
+func TestTieredStorage_setL2Error(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	key := getTestKey()
+
+	l1 := &MemoryStorage{}
+	assert.Nil(t, l1.Set(ctx, key, []byte(`old value`)))
+
+	l2 := &MockStorage{}
+	l2.On("Set", mock.Anything, key, mock.Anything).Return(errors.New("something failed"))
+
+	storage := &TieredStorage{
+		L1: l1,
+		L2: l2,
+	}
+
+	// make the call
+	resultErr := storage.Set(ctx, key, []byte(`new value`))
+	assert.NotNil(t, resultErr)
+
+	// validate L1 no longer has the old value
+	_, resultErr = l1.Get(ctx, key)
+	assert.Equal(t, ErrCacheMiss, resultErr)
+
+	assert.True(t, l2.AssertExpectations(t))
+}
+
+func TestTieredStorage_Invalidate(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	key := getTestKey()
+
+	l1 := &MemoryStorage{}
+	assert.Nil(t, l1.Set(ctx, key, []byte(`this is foo`)))
+
+	l2 := &MockStorage{}
+	l2.On("Invalidate", mock.Anything, key).Return(nil)
+
+	storage := &TieredStorage{
+		L1: l1,
+		L2: l2,
+	}
+
+	// make the call
+	resultErr := storage.Invalidate(ctx, key)
+	assert.Nil(t, resultErr)
+
+	// validate
+	_, resultErr = l1.Get(ctx, key)
+	assert.Equal(t, ErrCacheMiss, resultErr)
+
+	assert.True(t, l2.AssertExpectations(t))
+}
+
+func TestTieredStorage_multi(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	keyL1 := getTestKey() + ".l1"
+	keyL2 := getTestKey() + ".l2"
+	missingKey := getTestKey() + ".missing"
+
+	l1 := &MemoryStorage{}
+	assert.Nil(t, l1.Set(ctx, keyL1, []byte(`from L1`)))
+
+	l2 := &MockStorage{}
+	l2.On("Get", mock.Anything, keyL2).Return([]byte(`from L2`), nil)
+	l2.On("Get", mock.Anything, missingKey).Return(nil, ErrCacheMiss)
+
+	metrics := &MockMetrics{}
+	metrics.On("Track", CacheL1Hit).Once()
+	metrics.On("Track", CacheL1Miss).Twice()
+	metrics.On("Track", CacheL2Hit).Once()
+	metrics.On("Track", CacheL2Miss).Once()
+
+	storage := &TieredStorage{
+		L1:      l1,
+		L2:      l2,
+		Metrics: metrics,
+	}
+
+	// make the call
+	result, resultErr := storage.GetMulti(ctx, []string{keyL1, keyL2, missingKey})
+	assert.Nil(t, resultErr)
+	assert.Equal(t, map[string][]byte{
+		keyL1: []byte(`from L1`),
+		keyL2: []byte(`from L2`),
+	}, result)
+
+	// validate L1 was back-filled
+	result2, resultErr := l1.Get(ctx, keyL2)
+	assert.Nil(t, resultErr)
+	assert.Equal(t, []byte(`from L2`), result2)
+
+	assert.True(t, l2.AssertExpectations(t))
+	assert.True(t, metrics.AssertExpectations(t))
+}
+
+func TestTieredStorage_InvalidateTag(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	key := getTestKey()
+
+	l1 := &MemoryStorage{}
+	l2 := &MemoryStorage{}
+
+	storage := &TieredStorage{
+		L1: l1,
+		L2: l2,
+	}
+
+	// set and tag a value
+	assert.Nil(t, storage.Set(ctx, key, []byte(`this is foo`)))
+	assert.Nil(t, storage.Tag(ctx, key, []string{"user:1"}, 0))
+
+	// make the call
+	resultErr := storage.InvalidateTag(ctx, "user:1")
+	assert.Nil(t, resultErr)
+
+	// validate both tiers
+	_, resultErr = l1.Get(ctx, key)
+	assert.Equal(t, ErrCacheMiss, resultErr)
+
+	_, resultErr = l2.Get(ctx, key)
+	assert.Equal(t, ErrCacheMiss, resultErr)
+
+	// L2 must support tags
+	storage.L2 = &MockStorage{}
+	assert.Equal(t, ErrTagsNotSupported, storage.InvalidateTag(ctx, "user:1"))
+}
@@ -0,0 +1,103 @@
+// Copyright 2017 Corey Scott http://www.sage42.org/
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// name of the OpenTelemetry tracer
+const tracerName = "github.com/corsc/go-commons/cache"
+
+// span attributes
+const (
+	attrCacheName   = attribute.Key("cache.name")
+	attrCacheKey    = attribute.Key("cache.key")
+	attrCacheKeys   = attribute.Key("cache.keys")
+	attrCacheHit    = attribute.Key("cache.hit")
+	attrStorage     = attribute.Key("cache.storage")
+	attrPayloadSize = attribute.Key("cache.payload_size")
+	attrDbSystem    = attribute.Key("db.system")
+	attrDbOperation = attribute.Key("db.operation")
+)
+
+// return a tracer from the provider; when the provider is not set, spans are not recorded
+func tracer(provider trace.TracerProvider) trace.Tracer {
+	if provider == nil {
+		return noop.NewTracerProvider().Tracer(tracerName)
+	}
+
+	return provider.Tracer(tracerName)
+}
+
+// start a span for a client operation
+func (c *Client) startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	attrs = append(attrs, attrCacheName.String(c.Name), attrStorage.String(storageName(c.Storage)))
+
+	return tracer(c.TracerProvider).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// return a context for an asynchronous write.
+//
+// The context keeps the values of the caller's context but is not cancelled with it and the returned span is the
+// root of a new trace that is linked to the caller's span.
+func (c *Client) startAsyncSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	attrs = append(attrs, attrCacheName.String(c.Name), attrStorage.String(storageName(c.Storage)))
+
+	return tracer(c.TracerProvider).Start(context.WithoutCancel(ctx), name,
+		trace.WithNewRoot(),
+		trace.WithLinks(trace.LinkFromContext(ctx)),
+		trace.WithAttributes(attrs...),
+	)
+}
+
+// record the hit or miss (and the size of the value on a hit) on the current span
+func setSpanHit(ctx context.Context, hit bool, size int) {
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(attrCacheHit.Bool(hit))
+
+	if size > 0 {
+		span.SetAttributes(attrPayloadSize.Int(size))
+	}
+}
+
+// start a span for a storage call
+func startStorageSpan(ctx context.Context, provider trace.TracerProvider, system string, operation string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	attrs = append(attrs, attrDbSystem.String(system), attrDbOperation.String(operation))
+
+	return tracer(provider).Start(ctx, system+" "+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...),
+	)
+}
+
+// end the span; recording the payload size and error (cache misses are not errors)
+func endSpan(span trace.Span, size int, err error) {
+	if size > 0 {
+		span.SetAttributes(attrPayloadSize.Int(size))
+	}
+
+	if err != nil && err != ErrCacheMiss {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
@@ -0,0 +1,141 @@
+// Copyright 2017 Corey Scott http://www.sage42.org/
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestClient_Get_tracing(t *testing.T) {
+	// inputs
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "parent")
+	key := getTestKey()
+
+	client := &Client{
+		Name:           "users",
+		Storage:        &MemoryStorage{},
+		TracerProvider: provider,
+	}
+
+	builder := BuilderFunc(func(ctx context.Context, key string, dest BinaryEncoder) error {
+		dest.(*myDTO).Name = "bob"
+		return nil
+	})
+
+	// make the calls
+	assert.Nil(t, client.Get(ctx, key, &myDTO{}, builder))
+	assert.Nil(t, client.waitForPending(1*time.Second))
+	assert.Nil(t, client.Get(ctx, key, &myDTO{}, builder))
+	parent.End()
+
+	// validate
+	spans := testSpansByName(recorder)
+
+	miss := spans["cache.Get"][0]
+	assert.Equal(t, parent.SpanContext().SpanID(), miss.Parent().SpanID())
+	assert.Contains(t, miss.Attributes(), attrCacheHit.Bool(false))
+	assert.Contains(t, miss.Attributes(), attrCacheName.String("users"))
+	assert.Contains(t, miss.Attributes(), attrStorage.String("memory"))
+
+	hit := spans["cache.Get"][1]
+	assert.Contains(t, hit.Attributes(), attrCacheHit.Bool(true))
+
+	build := spans["cache.Build"][0]
+	assert.Equal(t, miss.SpanContext().SpanID(), build.Parent().SpanID())
+
+	// the async write is a new trace that is linked to the build
+	asyncSet := spans["cache.AsyncSet"][0]
+	assert.NotEqual(t, parent.SpanContext().TraceID(), asyncSet.SpanContext().TraceID())
+	assert.Equal(t, 1, len(asyncSet.Links()))
+	assert.Equal(t, build.SpanContext(), asyncSet.Links()[0].SpanContext)
+}
+
+func TestClient_Get_tracingError(t *testing.T) {
+	// inputs
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	client := &Client{
+		Storage:        &MemoryStorage{},
+		TracerProvider: provider,
+	}
+
+	// make the call
+	resultErr := client.Get(context.Background(), getTestKey(), &myDTO{}, BuilderFunc(func(ctx context.Context, key string, dest BinaryEncoder) error {
+		return errors.New("something failed")
+	}))
+	assert.NotNil(t, resultErr)
+
+	// validate
+	spans := testSpansByName(recorder)
+	assert.Equal(t, codes.Error, spans["cache.Get"][0].Status().Code)
+	assert.Equal(t, codes.Error, spans["cache.Build"][0].Status().Code)
+}
+
+func TestDynamoDbStorage_tracing(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	key := getTestKey()
+
+	recorder := tracetest.NewSpanRecorder()
+	storage := &DynamoDbStorage{
+		Service:        newFakeDynamoDb(),
+		TableName:      "cachetest",
+		TTL:            60 * time.Second,
+		TracerProvider: sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)),
+	}
+
+	// make the calls
+	assert.Nil(t, storage.Set(ctx, key, []byte(`foo`)))
+
+	_, resultErr := storage.Get(ctx, key)
+	assert.Nil(t, resultErr)
+
+	// validate
+	spans := testSpansByName(recorder)
+
+	get := spans["dynamodb GetItem"][0]
+	assert.Contains(t, get.Attributes(), attribute.String("db.system", "dynamodb"))
+	assert.Contains(t, get.Attributes(), attrPayloadSize.Int(3))
+
+	assert.Equal(t, 1, len(spans["dynamodb PutItem"]))
+}
+
+func TestPayloadSize(t *testing.T) {
+	assert.Equal(t, 6, payloadSize([]byte(`foo`), []interface{}{"key", []byte(`bar`)}))
+	assert.Equal(t, 6, payloadSize([]interface{}{[]byte(`foo`), nil, []byte(`bar`)}, []interface{}{"a", "b", "c"}))
+}
+
+func testSpansByName(recorder *tracetest.SpanRecorder) map[string][]sdktrace.ReadOnlySpan {
+	out := map[string][]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		out[span.Name()] = append(out[span.Name()], span)
+	}
+
+	return out
+}
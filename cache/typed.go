@@ -0,0 +1,128 @@
+// Copyright 2017 Corey Scott http://www.sage42.org/
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+// Typed is a type-safe wrapper around Client which removes the need to implement BinaryEncoder for every cached type.
+//
+// Values are converted to and from bytes using the Serializer; all other features (coalescing, metrics, TTLs,
+// compression, namespaces, etc) are provided by the Client.
+type Typed[T any] struct {
+	// Client is the underlying cache client (required)
+	Client *Client
+
+	// Serializer converts values to and from bytes (optional - default JSONSerializer)
+	Serializer Serializer[T]
+}
+
+// TypedBuilderFunc builds the value for a cache miss (used by Typed)
+type TypedBuilderFunc[T any] func(ctx context.Context) (T, error)
+
+// Get attempts to retrieve the value from cache and when it misses will run the builder func to create the value.
+//
+// See Client.Get for more details.
+func (t *Typed[T]) Get(ctx context.Context, key string, builder TypedBuilderFunc[T]) (T, error) {
+	dest := t.newValue()
+
+	err := t.Client.Get(ctx, key, dest, BuilderFunc(func(ctx context.Context, key string, dest BinaryEncoder) error {
+		value, err := builder(ctx)
+		if err != nil {
+			return err
+		}
+
+		dest.(*typedValue[T]).value = value
+		return nil
+	}))
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+
+	return dest.value, nil
+}
+
+// Set will update the cache with the supplied key/value pair
+// NOTE: generally this need not be called is it is called implicitly by Get
+func (t *Typed[T]) Set(ctx context.Context, key string, value T) {
+	t.SetWithTTL(ctx, key, value, 0)
+}
+
+// SetWithTTL will update the cache with the supplied key/value pair which will expire after the supplied TTL.
+//
+// See Client.SetWithTTL for more details.
+func (t *Typed[T]) SetWithTTL(ctx context.Context, key string, value T, ttl time.Duration) {
+	dest := t.newValue()
+	dest.value = value
+
+	t.Client.SetWithTTL(ctx, key, dest, ttl)
+}
+
+// Invalidate will force invalidate any matching key in the cache
+func (t *Typed[T]) Invalidate(ctx context.Context, key string) error {
+	return t.Client.Invalidate(ctx, key)
+}
+
+func (t *Typed[T]) newValue() *typedValue[T] {
+	return &typedValue[T]{
+		serializer: t.getSerializer(),
+	}
+}
+
+// return the supplied serializer or the default
+func (t *Typed[T]) getSerializer() Serializer[T] {
+	if t.Serializer != nil {
+		return t.Serializer
+	}
+
+	return JSONSerializer[T]{}
+}
+
+// adapts a value and its serializer to BinaryEncoder so that it can be used with Client
+type typedValue[T any] struct {
+	value      T
+	serializer Serializer[T]
+}
+
+// MarshalBinary implements BinaryEncoder
+func (v *typedValue[T]) MarshalBinary() ([]byte, error) {
+	return v.serializer.Marshal(v.value)
+}
+
+// UnmarshalBinary implements BinaryEncoder
+func (v *typedValue[T]) UnmarshalBinary(data []byte) error {
+	value, err := v.serializer.Unmarshal(data)
+	if err != nil {
+		return err
+	}
+
+	v.value = value
+	return nil
+}
+
+// CacheTags implements Tagged when the value does
+func (v *typedValue[T]) CacheTags() []string {
+	return tagsOf(v.value)
+}
+
+// implements destCloner; a zero value would not have a serializer
+func (v *typedValue[T]) newLike() BinaryEncoder {
+	return &typedValue[T]{
+		serializer: v.serializer,
+	}
+}
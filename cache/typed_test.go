@@ -0,0 +1,159 @@
+// Copyright 2017 Corey Scott http://www.sage42.org/
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestTyped_Get(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	key := getTestKey()
+
+	metrics := &MockMetrics{}
+	metrics.On("Track", CacheMiss).Once()
+	metrics.On("Track", CacheHit).Once()
+
+	typed := &Typed[myUser]{
+		Client: &Client{
+			Storage: &MemoryStorage{},
+			Metrics: metrics,
+		},
+		Serializer: MsgpackSerializer[myUser]{},
+	}
+
+	builderCalls := 0
+	builder := func(ctx context.Context) (myUser, error) {
+		builderCalls++
+		return myUser{ID: 1, Name: "bob"}, nil
+	}
+
+	// miss
+	result, resultErr := typed.Get(ctx, key, builder)
+	assert.Nil(t, resultErr)
+	assert.Equal(t, myUser{ID: 1, Name: "bob"}, result)
+	assert.Nil(t, typed.Client.waitForPending(1*time.Second))
+
+	// hit
+	result, resultErr = typed.Get(ctx, key, builder)
+	assert.Nil(t, resultErr)
+	assert.Equal(t, myUser{ID: 1, Name: "bob"}, result)
+
+	assert.Equal(t, 1, builderCalls)
+	assert.True(t, metrics.AssertExpectations(t))
+}
+
+func TestTyped_Get_builderError(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	key := getTestKey()
+
+	typed := &Typed[*myUser]{
+		Client: &Client{
+			Storage: &MemoryStorage{},
+		},
+	}
+
+	// make the call
+	result, resultErr := typed.Get(ctx, key, func(ctx context.Context) (*myUser, error) {
+		return nil, errors.New("something failed")
+	})
+
+	// validate
+	assert.Nil(t, result)
+	assert.IsType(t, &LambdaError{}, resultErr)
+}
+
+func TestTyped_SetAndInvalidate(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	key := getTestKey()
+
+	typed := &Typed[string]{
+		Client: &Client{
+			Storage: &MemoryStorage{},
+		},
+	}
+
+	builder := func(ctx context.Context) (string, error) {
+		return "built", nil
+	}
+
+	// set
+	typed.Set(ctx, key, "set")
+
+	result, resultErr := typed.Get(ctx, key, builder)
+	assert.Nil(t, resultErr)
+	assert.Equal(t, "set", result)
+
+	// invalidate
+	assert.Nil(t, typed.Invalidate(ctx, key))
+
+	result, resultErr = typed.Get(ctx, key, builder)
+	assert.Nil(t, resultErr)
+	assert.Equal(t, "built", result)
+}
+
+func TestTyped_Get_staleRefresh(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	key := getTestKey()
+
+	metrics := &MockMetrics{}
+	metrics.On("Track", mock.Anything)
+
+	typed := &Typed[myUser]{
+		Client: &Client{
+			Storage: &MemoryStorage{},
+			Metrics: metrics,
+			SoftTTL: 1 * time.Nanosecond,
+		},
+		Serializer: GobSerializer[myUser]{},
+	}
+
+	typed.Set(ctx, key, myUser{ID: 1, Name: "bob"})
+
+	// make the call
+	result, resultErr := typed.Get(ctx, key, func(ctx context.Context) (myUser, error) {
+		return myUser{ID: 1, Name: "bobby"}, nil
+	})
+	assert.Nil(t, resultErr)
+	assert.Equal(t, myUser{ID: 1, Name: "bob"}, result)
+
+	// wait for the background refresh
+	<-time.After(10 * time.Millisecond)
+	assert.Nil(t, typed.Client.waitForPending(1*time.Second))
+
+	// validate the refreshed value was saved using the serializer
+	entry, resultErr := typed.Client.decodeEntry(testStoredBytes(t, typed.Client, key))
+	assert.Nil(t, resultErr)
+
+	stored, resultErr := GobSerializer[myUser]{}.Unmarshal(entry.payload)
+	assert.Nil(t, resultErr)
+	assert.Equal(t, "bobby", stored.Name)
+
+	metrics.AssertCalled(t, "Track", CacheStaleHit)
+}
@@ -0,0 +1,156 @@
+// Copyright 2017 Corey Scott http://www.sage42.org/
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"sync"
+)
+
+// OverflowPolicy controls what happens to an asynchronous write when the write queue is full
+type OverflowPolicy int
+
+const (
+	// OverflowDropNewest will discard the new write
+	OverflowDropNewest OverflowPolicy = iota
+
+	// OverflowDropOldest will discard the oldest queued write to make space for the new write
+	OverflowDropOldest
+
+	// OverflowWriteSync will perform the new write synchronously (in the caller's goroutine)
+	OverflowWriteSync
+)
+
+// default size of the async write queue
+const defaultAsyncWriteQueueSize = 100
+
+// a single queued write
+type asyncWrite func()
+
+// writePool is a fixed set of workers that process asynchronous writes from a bounded queue
+type writePool struct {
+	startOnce sync.Once
+	stopOnce  sync.Once
+
+	queue chan asyncWrite
+	stop  chan struct{}
+}
+
+// start the workers (only the first call has any effect)
+func (p *writePool) start(workers int, queueSize int, onDone func()) {
+	p.startOnce.Do(func() {
+		p.queue = make(chan asyncWrite, queueSize)
+		p.stop = make(chan struct{})
+
+		for x := 0; x < workers; x++ {
+			go p.work(onDone)
+		}
+	})
+}
+
+// process writes until stopped
+func (p *writePool) work(onDone func()) {
+	for {
+		select {
+		case write := <-p.queue:
+			write()
+			onDone()
+
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+// stop the workers; any queued writes are abandoned
+func (p *writePool) shutdown() {
+	// prevent the pool from being started after (or while) it is stopped
+	p.startOnce.Do(func() {})
+
+	p.stopOnce.Do(func() {
+		if p.stop != nil {
+			close(p.stop)
+		}
+	})
+}
+
+// run the write asynchronously; either on a new goroutine or via the write pool when configured
+//
+// startAsyncWrite must have been called and returned true before calling this
+func (c *Client) runAsync(write asyncWrite) {
+	if c.AsyncWriteWorkers <= 0 {
+		go func() {
+			defer c.finishAsyncWrite()
+
+			write()
+		}()
+		return
+	}
+
+	c.writePool.start(c.AsyncWriteWorkers, c.getAsyncWriteQueueSize(), c.finishAsyncWrite)
+
+	select {
+	case c.writePool.queue <- write:
+		// queued
+		return
+
+	default:
+		// queue is full
+	}
+
+	switch c.AsyncWriteOverflow {
+	case OverflowDropOldest:
+		select {
+		case <-c.writePool.queue:
+			c.onWriteDropped()
+
+		default:
+			// a worker took the oldest item
+		}
+
+		select {
+		case c.writePool.queue <- write:
+			// queued
+
+		default:
+			// still full
+			c.onWriteDropped()
+		}
+
+	case OverflowWriteSync:
+		defer c.finishAsyncWrite()
+
+		write()
+
+	default:
+		c.onWriteDropped()
+	}
+}
+
+// track a write that was discarded due to the queue being full
+func (c *Client) onWriteDropped() {
+	defer c.finishAsyncWrite()
+
+	c.log(LevelWarn, OpSet, "cache update dropped as the write queue is full")
+	c.getMetrics().Track(CacheWriteDropped)
+}
+
+// return the configured write queue size or the default
+func (c *Client) getAsyncWriteQueueSize() int {
+	if c.AsyncWriteQueueSize > 0 {
+		return c.AsyncWriteQueueSize
+	}
+
+	return defaultAsyncWriteQueueSize
+}
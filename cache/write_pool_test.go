@@ -0,0 +1,137 @@
+// Copyright 2017 Corey Scott http://www.sage42.org/
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClient_asyncWriteWorkers(t *testing.T) {
+	// inputs
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+
+	storage := &MemoryStorage{}
+	client := &Client{
+		Storage:           storage,
+		AsyncWriteWorkers: 2,
+	}
+
+	// make the calls
+	for x := 0; x < 10; x++ {
+		resultErr := client.Get(ctx, getTestKey(), &myDTO{}, BuilderFunc(func(ctx context.Context, key string, dest BinaryEncoder) error {
+			return nil
+		}))
+		assert.Nil(t, resultErr)
+	}
+
+	// validate
+	resultErr := client.Close(ctx)
+	assert.Nil(t, resultErr)
+	assert.Equal(t, 10, storage.Len())
+}
+
+func TestClient_asyncWriteOverflow(t *testing.T) {
+	scenarios := []struct {
+		desc            string
+		policy          OverflowPolicy
+		expectedWrites  []string
+		expectedDropped int
+	}{
+		{
+			desc:            "drop newest",
+			policy:          OverflowDropNewest,
+			expectedWrites:  []string{"first", "second"},
+			expectedDropped: 1,
+		},
+		{
+			desc:            "drop oldest",
+			policy:          OverflowDropOldest,
+			expectedWrites:  []string{"first", "third"},
+			expectedDropped: 1,
+		},
+		{
+			desc:           "write sync",
+			policy:         OverflowWriteSync,
+			expectedWrites: []string{"third", "first", "second"},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		t.Run(scenario.desc, func(t *testing.T) {
+			ctx, cancelFn := context.WithCancel(context.Background())
+			defer cancelFn()
+
+			dropped := 0
+			client := &Client{
+				Metrics: MetricsFunc(func(event Event) {
+					if event == CacheWriteDropped {
+						dropped++
+					}
+				}),
+				AsyncWriteWorkers:   1,
+				AsyncWriteQueueSize: 1,
+				AsyncWriteOverflow:  scenario.policy,
+			}
+
+			mutex := &sync.Mutex{}
+			var writes []string
+			started := make(chan struct{})
+			release := make(chan struct{})
+
+			newWrite := func(name string) asyncWrite {
+				return func() {
+					if name == "first" {
+						close(started)
+						<-release
+					}
+
+					mutex.Lock()
+					writes = append(writes, name)
+					mutex.Unlock()
+				}
+			}
+
+			// the first write blocks the only worker
+			assert.True(t, client.startAsyncWrite(context.Background()))
+			client.runAsync(newWrite("first"))
+			<-started
+
+			// the second write fills the queue
+			assert.True(t, client.startAsyncWrite(context.Background()))
+			client.runAsync(newWrite("second"))
+
+			// the third write overflows
+			assert.True(t, client.startAsyncWrite(context.Background()))
+			client.runAsync(newWrite("third"))
+
+			close(release)
+
+			flushCtx, flushCancelFn := context.WithTimeout(ctx, 1*time.Second)
+			defer flushCancelFn()
+
+			resultErr := client.Close(flushCtx)
+			assert.Nil(t, resultErr)
+
+			assert.Equal(t, scenario.expectedWrites, writes)
+			assert.Equal(t, scenario.expectedDropped, dropped)
+		})
+	}
+}